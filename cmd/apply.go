@@ -0,0 +1,170 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"cube/manifest"
+	"cube/service"
+	"cube/task"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply manifests to the manager",
+	Long: `cube apply command.
+
+The apply command reads task and service manifests written in YAML or JSON,
+compares them with the tasks and services on the manager and creates, updates
+or deletes them so that the manager matches the manifests. Tasks cannot be
+updated, so a task whose manifest has changed is stopped and started again.
+
+With --prune, tasks and services that are not in the manifests are deleted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filenames, _ := cmd.Flags().GetStringArray("filename")
+		prune, _ := cmd.Flags().GetBool("prune")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		changes, ok := planManifests(manager, filenames, prune)
+		if !ok {
+			return
+		}
+		if len(changes) == 0 {
+			log.Println("Nothing to apply")
+			return
+		}
+
+		for _, c := range changes {
+			printChange(c)
+			if dryRun {
+				continue
+			}
+			if !applyChange(manager, c) {
+				return
+			}
+		}
+	},
+}
+
+// planManifests はマニフェストを読み、マネージャの現在の状態と比べる
+func planManifests(manager string, filenames []string, prune bool) ([]manifest.Change, bool) {
+	manifests, err := readManifests(filenames)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+
+	var tasks []*task.Task
+	if !getJSON(fmt.Sprintf("http://%s/v1/tasks", manager), &tasks) {
+		return nil, false
+	}
+	var services []*service.Service
+	if !getJSON(fmt.Sprintf("http://%s/v1/services", manager), &services) {
+		return nil, false
+	}
+
+	changes, err := manifest.Plan(manifests, tasks, services, prune)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+
+	return changes, true
+}
+
+// readManifests はファイルかディレクトリ内の.yaml、.yml、.jsonファイルを読む
+func readManifests(filenames []string) ([]manifest.Manifest, error) {
+	var paths []string
+	for _, f := range filenames {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, f)
+			continue
+		}
+		for _, pattern := range []string{"*.yaml", "*.yml", "*.json"} {
+			matches, _ := filepath.Glob(filepath.Join(f, pattern))
+			paths = append(paths, matches...)
+		}
+	}
+
+	var manifests []manifest.Manifest
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		m, err := manifest.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", p, err)
+		}
+		manifests = append(manifests, m...)
+	}
+
+	return manifests, nil
+}
+
+func printChange(c manifest.Change) {
+	fmt.Println(c)
+	for _, f := range c.Fields {
+		fmt.Printf("    %s\n", f)
+	}
+}
+
+func applyChange(manager string, c manifest.Change) bool {
+	switch c.Kind {
+	case manifest.KindService:
+		switch c.Action {
+		case manifest.ActionCreate:
+			return sendJSON(http.MethodPost, fmt.Sprintf("http://%s/v1/services", manager), c.Service, http.StatusCreated, nil)
+		case manifest.ActionUpdate:
+			return sendJSON(http.MethodPut, fmt.Sprintf("http://%s/v1/services/%s", manager, c.ID), c.Service, http.StatusOK, nil)
+		case manifest.ActionDelete:
+			return sendJSON(http.MethodDelete, fmt.Sprintf("http://%s/v1/services/%s", manager, c.ID), nil, http.StatusNoContent, nil)
+		}
+	case manifest.KindTask:
+		if c.Action == manifest.ActionUpdate || c.Action == manifest.ActionDelete {
+			if !sendJSON(http.MethodDelete, fmt.Sprintf("http://%s/v1/tasks/%s", manager, c.ID), nil, http.StatusNoContent, nil) {
+				return false
+			}
+		}
+		if c.Action == manifest.ActionCreate || c.Action == manifest.ActionUpdate {
+			t := *c.Task
+			t.ID = uuid.New()
+			te := task.TaskEvent{
+				ID:        uuid.New(),
+				State:     task.Running,
+				Timestamp: time.Now(),
+				Task:      t,
+			}
+			return sendJSON(http.MethodPost, fmt.Sprintf("http://%s/v1/tasks", manager), te, http.StatusCreated, nil)
+		}
+		return true
+	}
+
+	log.Printf("Unknown change %v", c)
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+
+	applyCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	applyCmd.Flags().StringArrayP("filename", "f", nil, "Manifest file or directory. Can be given more than once")
+	applyCmd.Flags().Bool("prune", false, "Delete tasks and services that are not in the manifests")
+	applyCmd.Flags().Bool("dry-run", false, "Only print the changes")
+	applyCmd.MarkFlagRequired("filename")
+}
@@ -0,0 +1,226 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"cube/labels"
+	"cube/manager"
+	"cube/taints"
+	"cube/task"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// describeCmd represents the describe command
+var describeCmd = &cobra.Command{
+	Use:   "describe",
+	Short: "Show details of a resource",
+	Long: `cube describe command.
+
+The describe command shows the details of a single resource.`,
+}
+
+// describeTaskCmd represents the describe task command
+var describeTaskCmd = &cobra.Command{
+	Use:   "task <id|name>",
+	Short: "Show details of a task",
+	Long: `cube describe task command.
+
+The describe task command shows a task, the worker it runs on, its restarts,
+ports, health check history and the events the manager has received for it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		addr, _ := cmd.Flags().GetString("manager")
+
+		var d manager.TaskDescription
+		if !getJSON(fmt.Sprintf("http://%s/v1/tasks/%s", addr, args[0]), &d) {
+			return
+		}
+
+		printTaskDescription(os.Stdout, &d, time.Now().UTC())
+	},
+}
+
+func printTaskDescription(out io.Writer, d *manager.TaskDescription, now time.Time) {
+	t := d.Task
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	field := func(name string, value any) {
+		fmt.Fprintf(w, "%s:\t%v\n", name, value)
+	}
+
+	field("Name", t.Name)
+	field("ID", t.ID)
+	field("State", t.State)
+	field("Image", t.Image)
+	if len(t.Cmd) > 0 {
+		field("Command", strings.Join(t.Cmd, " "))
+	}
+	field("Worker", orNone(d.Worker))
+	if t.PendingReason != "" {
+		field("Pending Reason", t.PendingReason)
+	}
+	if t.ServiceID != uuid.Nil {
+		field("Service", fmt.Sprintf("%s (revision %d)", t.ServiceID, t.ServiceRevision))
+	}
+	if t.IsJob() {
+		field("Mode", task.ModeJob)
+	} else {
+		field("Mode", task.ModeService)
+	}
+	field("Container ID", orNone(t.ContainerID))
+	field("Started", describeTime(t.StartTime, now))
+	field("Finished", describeTime(t.FinishTime, now))
+	if !t.FinishTime.IsZero() {
+		field("Exit Code", t.ExitCode)
+	}
+	field("Restart Policy", t.Restart())
+	field("Restart Count", fmt.Sprintf("%d/%d", t.RestartCount, t.RetryLimit()))
+	if !t.NextRetry.IsZero() {
+		field("Next Retry", describeTime(t.NextRetry, now))
+	}
+	field("Resources", fmt.Sprintf("cpu=%v memory=%s disk=%s", t.Cpu, units.BytesSize(float64(t.Memory)), units.BytesSize(float64(t.Disk))))
+	field("Labels", orNone(labels.Format(t.Labels)))
+	if t.NodeSelector != "" {
+		field("Node Selector", t.NodeSelector)
+	}
+	w.Flush()
+
+	if a := t.Affinity; a != nil {
+		fmt.Fprintln(out, "Affinity:")
+		var terms []string
+		add := func(kind string, ts []task.AffinityTerm) {
+			for _, term := range ts {
+				if term.Required {
+					terms = append(terms, fmt.Sprintf("%s %s (required)", kind, term.Selector))
+				} else {
+					terms = append(terms, fmt.Sprintf("%s %s (weight %d)", kind, term.Selector, term.Weight))
+				}
+			}
+		}
+		add("node", a.Node)
+		add("task", a.Task)
+		add("anti-task", a.AntiTask)
+		printList(out, terms)
+	}
+
+	if len(t.Tolerations) > 0 {
+		fmt.Fprintln(out, "Tolerations:")
+		var tols []string
+		for _, tol := range t.Tolerations {
+			s := tol.Key
+			if tol.Operator == taints.OpExists {
+				s = orNone(s) + " exists"
+			} else {
+				s += "=" + tol.Value
+			}
+			if tol.Effect != "" {
+				s += ":" + tol.Effect
+			}
+			tols = append(tols, s)
+		}
+		printList(out, tols)
+	}
+
+	fmt.Fprintln(out, "Ports:")
+	var ports []string
+	for port, bindings := range t.HostPorts {
+		for _, b := range bindings {
+			ports = append(ports, fmt.Sprintf("%s -> %s:%s", port, b.HostIP, b.HostPort))
+		}
+	}
+	for port := range t.ExposedPorts {
+		if len(t.HostPorts[port]) == 0 {
+			ports = append(ports, fmt.Sprintf("%s (not published)", port))
+		}
+	}
+	sort.Strings(ports)
+	printList(out, ports)
+
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	field("Health", orNone(t.Health))
+	field("Ready", t.Ready)
+	if t.HealthMessage != "" {
+		field("Health Message", t.HealthMessage)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out, "Health History:")
+	if len(t.HealthHistory) == 0 {
+		printList(out, nil)
+	} else {
+		w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  TIME\tHEALTH\tREADY\tMESSAGE")
+		for _, h := range t.HealthHistory {
+			fmt.Fprintf(w, "  %s\t%s\t%v\t%s\n", describeTime(h.Time, now), orNone(h.Health), h.Ready, h.Message)
+		}
+		w.Flush()
+	}
+
+	fmt.Fprintln(out, "Events:")
+	if len(d.Events) == 0 {
+		printList(out, nil)
+	} else {
+		w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  TIME\tREQUEST\tEVENT")
+		for _, e := range d.Events {
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", describeTime(e.Timestamp, now), describeEvent(e), e.ID)
+		}
+		w.Flush()
+	}
+}
+
+// describeEvent はイベントがタスクに何を要求したかを返す
+func describeEvent(e *task.TaskEvent) string {
+	switch {
+	case e.State == task.Completed:
+		return "Stop"
+	case e.Task.RestartCount > 0:
+		return fmt.Sprintf("Restart #%d", e.Task.RestartCount)
+	}
+	return "Start"
+}
+
+func describeTime(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "<none>"
+	}
+	if t.After(now) {
+		return fmt.Sprintf("%s (in %s)", t.Format(time.RFC3339), units.HumanDuration(t.Sub(now)))
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), units.HumanDuration(now.Sub(t)))
+}
+
+func printList(out io.Writer, items []string) {
+	if len(items) == 0 {
+		fmt.Fprintln(out, "  <none>")
+		return
+	}
+	for _, item := range items {
+		fmt.Fprintf(out, "  %s\n", item)
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+func init() {
+	rootCmd.AddCommand(describeCmd)
+	describeCmd.AddCommand(describeTaskCmd)
+
+	describeCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+}
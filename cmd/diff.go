@@ -0,0 +1,47 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show what apply would change",
+	Long: `cube diff command.
+
+The diff command compares manifests with the tasks and services on the manager
+and prints what apply would create (+), update (~) and delete (-). It exits
+with status 1 if there are differences.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filenames, _ := cmd.Flags().GetStringArray("filename")
+		prune, _ := cmd.Flags().GetBool("prune")
+
+		changes, ok := planManifests(manager, filenames, prune)
+		if !ok {
+			return
+		}
+
+		for _, c := range changes {
+			printChange(c)
+		}
+		if len(changes) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	diffCmd.Flags().StringArrayP("filename", "f", nil, "Manifest file or directory. Can be given more than once")
+	diffCmd.Flags().Bool("prune", false, "Also show tasks and services that are not in the manifests")
+	diffCmd.MarkFlagRequired("filename")
+}
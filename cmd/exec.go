@@ -0,0 +1,148 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"cube/task"
+	"cube/util"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/moby/term"
+	"github.com/spf13/cobra"
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec <task-id> -- <command> [args...]",
+	Short: "Run a command in a running task",
+	Long: `cube exec command.
+
+The exec command runs a command inside a running task through the Cube manager.
+Use -i to pass stdin to the command and -t to allocate a TTY.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		stdin, _ := cmd.Flags().GetBool("stdin")
+		tty, _ := cmd.Flags().GetBool("tty")
+
+		opts := task.ExecOptions{Cmd: args[1:], Tty: tty, Stdin: stdin}
+		data, _ := json.Marshal(opts)
+
+		url := fmt.Sprintf("http://%s/v1/tasks/%s/exec", manager, args[0])
+		resp, conn, err := util.PostUpgrade(context.Background(), url, bytes.NewBuffer(data))
+		if err != nil {
+			log.Printf("Error connecting to %v: %v", url, err)
+			return
+		}
+
+		if conn == nil {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			log.Printf("Error starting exec in task %s (%d): %s", args[0], resp.StatusCode, body)
+			return
+		}
+		defer conn.Close()
+
+		var mu sync.Mutex
+		send := func(typ byte, payload []byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			task.WriteFrame(conn, typ, payload)
+		}
+
+		var state *term.State
+		fd, isTerminal := term.GetFdInfo(os.Stdin)
+		if tty && isTerminal {
+			state, err = term.SetRawTerminal(fd)
+			if err != nil {
+				log.Printf("Error setting terminal to raw mode: %v", err)
+				return
+			}
+
+			resize := func() {
+				if ws, err := term.GetWinsize(fd); err == nil {
+					send(task.FrameResize, task.ResizePayload(uint(ws.Height), uint(ws.Width)))
+				}
+			}
+			resize()
+
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, syscall.SIGWINCH)
+			defer signal.Stop(sigs)
+			go func() {
+				for range sigs {
+					resize()
+				}
+			}()
+		}
+
+		if stdin {
+			go func() {
+				buf := make([]byte, 32*1024)
+				for {
+					n, err := os.Stdin.Read(buf)
+					if n > 0 {
+						send(task.FrameStdin, buf[:n])
+					}
+					if err != nil {
+						send(task.FrameCloseStdin, nil)
+						return
+					}
+				}
+			}()
+		} else {
+			send(task.FrameCloseStdin, nil)
+		}
+
+		code := runExecStream(conn)
+		if state != nil {
+			term.RestoreTerminal(fd, state)
+		}
+		if code != 0 {
+			conn.Close()
+			os.Exit(code)
+		}
+	},
+}
+
+// runExecStream はワーカーから届いた出力を書き出し、コマンドの終了コードを返す
+func runExecStream(conn io.Reader) int {
+	for {
+		typ, payload, err := task.ReadFrame(conn)
+		if err != nil {
+			log.Printf("Exec stream closed: %v", err)
+			return 1
+		}
+
+		switch typ {
+		case task.FrameStdout:
+			os.Stdout.Write(payload)
+		case task.FrameStderr:
+			os.Stderr.Write(payload)
+		case task.FrameExit:
+			code, err := task.ParseExitPayload(payload)
+			if err != nil {
+				return 1
+			}
+			return code
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	execCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	execCmd.Flags().BoolP("stdin", "i", false, "Pass stdin to the command")
+	execCmd.Flags().BoolP("tty", "t", false, "Allocate a TTY")
+}
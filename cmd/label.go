@@ -0,0 +1,113 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"cube/labels"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// labelCmd represents the label command
+var labelCmd = &cobra.Command{
+	Use:   "label",
+	Short: "Update the labels of a task or node",
+	Long: `cube label command.
+
+The label command adds, changes and removes labels. Labels are given as
+KEY=VALUE to set a label and KEY- to remove it.`,
+}
+
+// labelTaskCmd represents the label task command
+var labelTaskCmd = &cobra.Command{
+	Use:   "task <id|name> KEY=VALUE|KEY- ...",
+	Short: "Update the labels of a task",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		sendLabels(fmt.Sprintf("http://%s/v1/tasks/%s/labels", manager, args[0]), args[1:])
+	},
+}
+
+// labelNodeCmd represents the label node command
+var labelNodeCmd = &cobra.Command{
+	Use:   "node <name> KEY=VALUE|KEY- ...",
+	Short: "Update the labels of a node",
+	Long: `cube label node command.
+
+The label node command updates the labels of a node. Tasks choose nodes by
+their labels with a node selector. Node labels are kept in the manager's
+memory and have to be set again when the manager restarts.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		sendLabels(fmt.Sprintf("http://%s/v1/nodes/%s/labels", manager, args[0]), args[1:])
+	},
+}
+
+func sendLabels(url string, args []string) {
+	changes, err := parseLabelChanges(args)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var out struct {
+		Name   string
+		Labels map[string]string
+	}
+	if !sendJSON(http.MethodPatch, url, changes, http.StatusOK, &out) {
+		return
+	}
+
+	log.Printf("Labels of %s: %s", out.Name, labels.Format(out.Labels))
+}
+
+// parseLabelChanges は"KEY=VALUE"と"KEY-"を変更の形式に変換する。値がnilのラベルは削除する
+func parseLabelChanges(args []string) (map[string]*string, error) {
+	changes := make(map[string]*string)
+	for _, arg := range args {
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			changes[key] = &value
+			continue
+		}
+		if key, ok := strings.CutSuffix(arg, "-"); ok {
+			changes[key] = nil
+			continue
+		}
+		return nil, fmt.Errorf("invalid label %q, must be KEY=VALUE or KEY-", arg)
+	}
+
+	return changes, nil
+}
+
+// parseLabels は"KEY=VALUE"の一覧をラベルに変換する
+func parseLabels(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	m := make(map[string]string)
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q, must be KEY=VALUE", arg)
+		}
+		m[key] = value
+	}
+
+	return m, labels.Validate(m)
+}
+
+func init() {
+	rootCmd.AddCommand(labelCmd)
+	labelCmd.AddCommand(labelTaskCmd)
+	labelCmd.AddCommand(labelNodeCmd)
+
+	labelCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+}
@@ -0,0 +1,67 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs <task-id>",
+	Short: "Print the logs of a task",
+	Long: `cube logs command.
+
+The logs command fetches the logs of a task from the worker it runs on, through the Cube manager.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		follow, _ := cmd.Flags().GetBool("follow")
+		tail, _ := cmd.Flags().GetString("tail")
+		since, _ := cmd.Flags().GetString("since")
+		stdout, _ := cmd.Flags().GetBool("stdout")
+		stderr, _ := cmd.Flags().GetBool("stderr")
+
+		q := url.Values{}
+		q.Set("follow", fmt.Sprint(follow))
+		q.Set("tail", tail)
+		q.Set("since", since)
+		q.Set("stdout", fmt.Sprint(stdout))
+		q.Set("stderr", fmt.Sprint(stderr))
+
+		u := fmt.Sprintf("http://%s/v1/tasks/%s/logs?%s", manager, args[0], q.Encode())
+		resp, err := http.Get(u)
+		if err != nil {
+			log.Printf("Error connecting to %v: %v", u, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			log.Printf("Error getting logs for task %s (%d): %s", args[0], resp.StatusCode, body)
+			return
+		}
+
+		io.Copy(os.Stdout, resp.Body)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	logsCmd.Flags().BoolP("follow", "f", false, "Follow log output")
+	logsCmd.Flags().String("tail", "all", "Number of lines to show from the end of the logs")
+	logsCmd.Flags().String("since", "", "Show logs since timestamp or relative time (e.g. 10m)")
+	logsCmd.Flags().Bool("stdout", true, "Show stdout")
+	logsCmd.Flags().Bool("stderr", true, "Show stderr")
+}
@@ -20,7 +20,28 @@ The manager controls the orchestration system and is responsible for:
 - Accepting tasks from users
 - Scheduling tasks onto worker nodes
 - Rescheduling tasks in the event of a node failure
-- Periodically polling workers to get task updates`,
+- Periodically polling workers to get task updates
+
+The scheduler is built from filter and score plugins. The built-in profiles
+are roundrobin, epvm, binpack and spread. binpack places tasks on the nodes
+with the most CPU and memory already allocated to free whole nodes, and spread
+places them on the nodes with the least. Every built-in profile weights its
+placement strategy at 1 and the preferred affinity and PreferNoSchedule taints
+at 10, so the preferences outweigh the strategy, which decides among equally
+preferred nodes. A profile file can also be given to --scheduler:
+
+  name: web
+  filters: [nodeSelector, affinity, taints, resources, ports]
+  scores:
+    - name: affinity
+      weight: 2
+    - name: roundRobin
+
+Filters default to all of the filter plugins above when omitted. The score
+plugins are affinity, taints, roundRobin, epvm, mostAllocated and
+leastAllocated. Their weight defaults to 1, and a weight of 0 keeps the plugin
+from affecting the ranking. An argument containing a path separator or ending
+in .yaml or .yml is always read as a profile file.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		host, _ := cmd.Flags().GetString("host")
@@ -31,6 +52,7 @@ The manager controls the orchestration system and is responsible for:
 
 		m, err := manager.New(workers, scheduler, dbType)
 		if err != nil {
+			log.Println(err)
 			return
 		}
 		api := manager.Api{Address: host, Port: port, Manager: m}
@@ -38,6 +60,7 @@ The manager controls the orchestration system and is responsible for:
 		go m.ProcessTasks()
 		go m.UpdateTasks()
 		go m.DoHealthChecks()
+		go m.ReconcileServices()
 		log.Printf("Starting manager API on http://%s:%d", host, port)
 		api.Start()
 
@@ -51,7 +74,7 @@ func init() {
 	managerCmd.Flags().IntP("port", "p", 5556, "Port on which listen")
 
 	managerCmd.Flags().StringSliceP("workers", "w", []string{"localhost:5556"}, "List of workers on which the manager will schedule tasks")
-	managerCmd.Flags().StringP("scheduler", "s", "epvm", "Name of schedule to use.")
+	managerCmd.Flags().StringP("scheduler", "s", "epvm", "Name of a built-in scheduler profile (roundrobin, epvm, binpack, spread) or path to a scheduler profile file")
 	managerCmd.Flags().StringP("dbtype", "d", "memory", "Type of datastore to use for tasks memory or persistent")
 
 }
@@ -4,13 +4,16 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"cube/labels"
 	"cube/node"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/spf13/cobra"
@@ -27,7 +30,9 @@ The node command allows a user to get the information about the nodes in the clu
 
 		manager, _ := cmd.Flags().GetString("manager")
 
-		url := fmt.Sprintf("http://%s/nodes", manager)
+		selector, _ := cmd.Flags().GetString("selector")
+
+		url := fmt.Sprintf("http://%s/v1/nodes?selector=%s", manager, neturl.QueryEscape(selector))
 		resp, err := http.Get(url)
 		if err != nil {
 			log.Println(err)
@@ -40,9 +45,14 @@ The node command allows a user to get the information about the nodes in the clu
 		json.Unmarshal(body, &nodes)
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
 
-		fmt.Fprintln(w, "NAME\tMEMORY (MiB)\tDISK (Gib)\tROLE\tTASKS\t")
+		// CPU、メモリ、ディスクは割り当て済み/容量で表示する
+		fmt.Fprintln(w, "NAME\tCPU\tMEMORY (MiB)\tDISK (Gib)\tROLE\tTASKS\tLABELS\tTAINTS\t")
 		for _, node := range nodes {
-			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t\n", node.Name, node.Memory/1000, node.Disk/1000/1000/1000, node.Role, node.TaskCount)
+			var ts []string
+			for _, t := range node.Taints {
+				ts = append(ts, t.String())
+			}
+			fmt.Fprintf(w, "%s\t%v/%d\t%d/%d\t%d/%d\t%s\t%d\t%s\t%s\t\n", node.Name, node.CpuAllocated, node.Cores, node.MemoryAllocated/1000, node.Memory/1000, node.DiskAllocated/1000/1000/1000, node.Disk/1000/1000/1000, node.Role, node.TaskCount, labels.Format(node.Labels), strings.Join(ts, ","))
 		}
 		w.Flush()
 	},
@@ -52,4 +62,5 @@ func init() {
 	rootCmd.AddCommand(nodeCmd)
 
 	nodeCmd.Flags().StringP("manager", "m", "localhost:5555", "Manger to talk to")
+	nodeCmd.Flags().StringP("selector", "l", "", "Only show nodes whose labels match this selector")
 }
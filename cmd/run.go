@@ -5,6 +5,7 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -47,7 +48,7 @@ The run command starts a new task`,
 			return
 		}
 
-		url := fmt.Sprintf("http://%s/tasks", manager)
+		url := fmt.Sprintf("http://%s/v1/tasks", manager)
 		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 		if err != nil {
 			log.Println(err)
@@ -56,6 +57,11 @@ The run command starts a new task`,
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusCreated {
+			var e struct{ Message string }
+			if err := json.NewDecoder(resp.Body).Decode(&e); err == nil && e.Message != "" {
+				log.Printf("Error sending request: %v: %s", resp.StatusCode, e.Message)
+				return
+			}
 			log.Printf("Error sending request: %v", resp.StatusCode)
 			return
 		}
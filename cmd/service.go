@@ -0,0 +1,294 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/spf13/cobra"
+)
+
+// serviceCmd represents the service command
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage services",
+	Long: `cube service command.
+
+A service keeps the given number of replicas of a task running.`,
+}
+
+// serviceCreateCmd represents the service create command
+var serviceCreateCmd = &cobra.Command{
+	Use:   "create <name> [-- <command> [args...]]",
+	Short: "Create a service",
+	Long: `cube service create command.
+
+The create command creates a service. The task template can be read from a
+task specification file with -f and is overridden by the other flags.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+		image, _ := cmd.Flags().GetString("image")
+		replicas, _ := cmd.Flags().GetInt("replicas")
+		env, _ := cmd.Flags().GetStringArray("env")
+		expose, _ := cmd.Flags().GetStringArray("expose")
+		maxSurge, _ := cmd.Flags().GetInt("max-surge")
+		maxUnavailable, _ := cmd.Flags().GetInt("max-unavailable")
+		labelArgs, _ := cmd.Flags().GetStringArray("label")
+		nodeSelector, _ := cmd.Flags().GetString("node-selector")
+
+		s := service.Service{
+			Name:         args[0],
+			Image:        image,
+			Replicas:     replicas,
+			UpdateConfig: service.UpdateConfig{MaxSurge: maxSurge, MaxUnavailable: maxUnavailable},
+		}
+		if filename != "" {
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if err := json.Unmarshal(data, &s.Template); err != nil {
+				log.Printf("Error reading %s: %v", filename, err)
+				return
+			}
+			if s.Image == "" {
+				s.Image = s.Template.Image
+			}
+		}
+
+		if len(args) > 1 {
+			s.Template.Cmd = args[1:]
+		}
+		s.Template.Env = append(s.Template.Env, env...)
+		taskLabels, err := parseLabels(labelArgs)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for k, v := range taskLabels {
+			if s.Template.Labels == nil {
+				s.Template.Labels = map[string]string{}
+			}
+			s.Template.Labels[k] = v
+		}
+		if nodeSelector != "" {
+			s.Template.NodeSelector = nodeSelector
+		}
+		for _, p := range expose {
+			if s.Template.ExposedPorts == nil {
+				s.Template.ExposedPorts = nat.PortSet{}
+			}
+			proto, port := nat.SplitProtoPort(p)
+			np, err := nat.NewPort(proto, port)
+			if err != nil {
+				log.Printf("Invalid port %s: %v", p, err)
+				return
+			}
+			s.Template.ExposedPorts[np] = struct{}{}
+		}
+
+		var created service.Service
+		url := fmt.Sprintf("http://%s/v1/services", manager)
+		if !sendJSON(http.MethodPost, url, s, http.StatusCreated, &created) {
+			return
+		}
+
+		log.Printf("Service %s (%s) has been created", created.Name, created.ID)
+	},
+}
+
+// serviceScaleCmd represents the service scale command
+var serviceScaleCmd = &cobra.Command{
+	Use:   "scale <name> <replicas>",
+	Short: "Change the number of replicas of a service",
+	Long: `cube service scale command.
+
+The scale command changes the number of replicas of a service.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		replicas, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Printf("Invalid number of replicas %s", args[1])
+			return
+		}
+
+		url := fmt.Sprintf("http://%s/v1/services/%s", manager, args[0])
+		var s service.Service
+		if !getJSON(url, &s) {
+			return
+		}
+
+		s.Replicas = replicas
+		if !sendJSON(http.MethodPut, url, s, http.StatusOK, &s) {
+			return
+		}
+
+		log.Printf("Service %s has been scaled to %d replicas", s.Name, s.Replicas)
+	},
+}
+
+// serviceLsCmd represents the service ls command
+var serviceLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List services",
+	Long: `cube service ls command.
+
+The ls command lists services and how many of their replicas are running and ready.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		var services []*service.Service
+		if !getJSON(fmt.Sprintf("http://%s/v1/services", manager), &services) {
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "ID\tNAME\tIMAGE\tREVISION\tREPLICAS\tREADY\tUPDATE\t")
+		for _, s := range services {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d/%d\t%d/%d\t%s\t\n", s.ID, s.Name, s.Image, s.Revision, s.Status.Replicas, s.Replicas, s.Status.ReadyReplicas, s.Replicas, s.Status.UpdateState)
+		}
+		w.Flush()
+	},
+}
+
+// serviceRollbackCmd represents the service rollback command
+var serviceRollbackCmd = &cobra.Command{
+	Use:   "rollback <name>",
+	Short: "Roll a service back to a previous revision",
+	Long: `cube service rollback command.
+
+The rollback command rolls a service back to the previous revision, or to the
+revision given with --to-revision. The tasks are replaced with a rolling update.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		revision, _ := cmd.Flags().GetInt("to-revision")
+
+		data, _ := json.Marshal(struct{ Revision int }{revision})
+		url := fmt.Sprintf("http://%s/v1/services/%s/rollback", manager, args[0])
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+		if err != nil {
+			log.Printf("Error connecting to %v: %v", url, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var e struct{ Message string }
+			if err := json.NewDecoder(resp.Body).Decode(&e); err == nil && e.Message != "" {
+				log.Printf("Error sending request: %v: %s", resp.StatusCode, e.Message)
+				return
+			}
+			log.Printf("Error sending request: %v", resp.StatusCode)
+			return
+		}
+
+		var s service.Service
+		json.NewDecoder(resp.Body).Decode(&s)
+		log.Printf("Service %s is rolling back: %s", s.Name, s.Status.Message)
+	},
+}
+
+// sendJSON はbodyをJSONで送り、レスポンスをoutに読む。bodyとoutはnilでもよい
+func sendJSON(method, url string, body any, expected int, out any) bool {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			log.Println(err)
+			return false
+		}
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(data))
+	if err != nil {
+		log.Printf("Error creating request %v: %v", url, err)
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Error connecting to %v: %v", url, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expected {
+		var e struct{ Message string }
+		if err := json.NewDecoder(resp.Body).Decode(&e); err == nil && e.Message != "" {
+			log.Printf("Error sending request: %v: %s", resp.StatusCode, e.Message)
+			return false
+		}
+		log.Printf("Error sending request: %v", resp.StatusCode)
+		return false
+	}
+
+	if out == nil {
+		return true
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		log.Printf("Error decoding response: %v", err)
+		return false
+	}
+
+	return true
+}
+
+func getJSON(url string, out any) bool {
+	resp, err := http.Get(url)
+	if err != nil {
+		log.Printf("Error connecting to %v: %v", url, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Error getting %v: %v", url, resp.StatusCode)
+		return false
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		log.Printf("Error decoding response: %v", err)
+		return false
+	}
+
+	return true
+}
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceCreateCmd)
+	serviceCmd.AddCommand(serviceScaleCmd)
+	serviceCmd.AddCommand(serviceLsCmd)
+	serviceCmd.AddCommand(serviceRollbackCmd)
+
+	serviceCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+
+	serviceCreateCmd.Flags().StringP("filename", "f", "", "Task specification file used as the template")
+	serviceCreateCmd.Flags().String("image", "", "Image to run")
+	serviceCreateCmd.Flags().IntP("replicas", "r", 1, "Number of replicas")
+	serviceCreateCmd.Flags().StringArrayP("env", "e", nil, "Environment variables in KEY=VALUE form")
+	serviceCreateCmd.Flags().StringArray("expose", nil, "Container ports to expose, e.g. 80/tcp")
+	serviceCreateCmd.Flags().StringArrayP("label", "l", nil, "Labels of the tasks in KEY=VALUE form")
+	serviceCreateCmd.Flags().String("node-selector", "", "Only run the tasks on nodes whose labels match this selector")
+	serviceCreateCmd.Flags().Int("max-surge", 0, "Number of extra tasks started during a rolling update")
+	serviceCreateCmd.Flags().Int("max-unavailable", 0, "Number of tasks that may be unavailable during a rolling update")
+
+	serviceRollbackCmd.Flags().Int("to-revision", 0, "Revision to roll back to. Defaults to the previous revision")
+}
@@ -4,13 +4,17 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"cube/labels"
 	"cube/task"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -24,13 +28,34 @@ var statusCmd = &cobra.Command{
 	Short: "Status command to list tasks",
 	Long: `cube status command.
 
-The status command allow a user to get the status of tasks from the Cube manager.`,
+The status command allow a user to get the status of tasks from the Cube manager.
+Tasks can be filtered by state, worker, image, name prefix and label selector, sorted by start
+or finish time and listed a page at a time with --limit and --cursor.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		manager, _ := cmd.Flags().GetString("manager")
+		states, _ := cmd.Flags().GetStringSlice("state")
+		limit, _ := cmd.Flags().GetInt("limit")
 
-		url := fmt.Sprintf("http://%s/tasks", manager)
-		resp, _ := http.Get(url)
+		q := url.Values{}
+		for _, name := range []string{"worker", "image", "name", "selector", "sort", "cursor"} {
+			if v, _ := cmd.Flags().GetString(name); v != "" {
+				q.Set(name, v)
+			}
+		}
+		if len(states) > 0 {
+			q.Set("state", strings.Join(states, ","))
+		}
+		if limit > 0 {
+			q.Set("limit", strconv.Itoa(limit))
+		}
+
+		u := fmt.Sprintf("http://%s/v1/tasks?%s", manager, q.Encode())
+		resp, err := http.Get(u)
+		if err != nil {
+			log.Printf("Error connecting to %v: %v", u, err)
+			return
+		}
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
 			log.Println(err)
@@ -38,6 +63,13 @@ The status command allow a user to get the status of tasks from the Cube manager
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != http.StatusOK {
+			var e struct{ Message string }
+			json.Unmarshal(body, &e)
+			log.Printf("Error getting tasks: %v: %s", resp.StatusCode, e.Message)
+			return
+		}
+
 		var tasks []*task.Task
 		err = json.Unmarshal(body, &tasks)
 		if err != nil {
@@ -46,7 +78,7 @@ The status command allow a user to get the status of tasks from the Cube manager
 		}
 
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
-		fmt.Fprintln(w, "ID\tNAME\tCREATED\tSTATE\tCONTAINERNAME\tIMAGE\t")
+		fmt.Fprintln(w, "ID\tNAME\tCREATED\tSTATE\tREADY\tCONTAINERNAME\tIMAGE\tLABELS\t")
 		for _, task := range tasks {
 			var start string
 			if task.StartTime.IsZero() {
@@ -56,9 +88,13 @@ The status command allow a user to get the status of tasks from the Cube manager
 			}
 
 			state := task.State.String()
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", task.ID, task.Name, start, state, task.Name, task.Image)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%s\t%s\t%s\t\n", task.ID, task.Name, start, state, task.Ready, task.Name, task.Image, labels.Format(task.Labels))
 		}
 		w.Flush()
+
+		if next := resp.Header.Get("X-Next-Cursor"); next != "" {
+			fmt.Fprintf(os.Stderr, "More tasks are available, use --cursor %s\n", next)
+		}
 	},
 }
 
@@ -66,4 +102,12 @@ func init() {
 	rootCmd.AddCommand(statusCmd)
 
 	statusCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	statusCmd.Flags().StringSlice("state", nil, "Only show tasks in these states, e.g. Running,Failed")
+	statusCmd.Flags().String("worker", "", "Only show tasks on this worker")
+	statusCmd.Flags().String("image", "", "Only show tasks of this image, with or without the tag")
+	statusCmd.Flags().String("name", "", "Only show tasks whose name starts with this prefix")
+	statusCmd.Flags().StringP("selector", "l", "", "Only show tasks whose labels match this selector, e.g. env=prod,tier in (web,api)")
+	statusCmd.Flags().String("sort", "", "Sort by startTime or finishTime. Prefix with - for newest first")
+	statusCmd.Flags().Int("limit", 0, "Maximum number of tasks to show")
+	statusCmd.Flags().String("cursor", "", "Continue a previous listing from this cursor")
 }
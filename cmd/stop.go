@@ -4,9 +4,11 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"cube/task"
 	"fmt"
 	"log"
 	"net/http"
+	neturl "net/url"
 
 	"github.com/spf13/cobra"
 )
@@ -17,11 +19,31 @@ var stopCmd = &cobra.Command{
 	Short: "Stop a running task",
 	Long: `cube stop command
 
-The stop command stops a running task`,
+The stop command stops a running task, or with --selector every running task
+whose labels match the selector`,
 	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		manager, _ := cmd.Flags().GetString("manager")
-		url := fmt.Sprintf("http://%s/tasks/%s", manager, args[0])
+		selector, _ := cmd.Flags().GetString("selector")
+
+		if selector != "" {
+			var stopped []*task.Task
+			url := fmt.Sprintf("http://%s/v1/tasks?selector=%s", manager, neturl.QueryEscape(selector))
+			if !sendJSON(http.MethodDelete, url, nil, http.StatusOK, &stopped) {
+				return
+			}
+			for _, t := range stopped {
+				log.Printf("Task %s (%s) is being stopped", t.ID, t.Name)
+			}
+			log.Printf("%d tasks matching %s are being stopped", len(stopped), selector)
+			return
+		}
+		if len(args) == 0 {
+			log.Println("Either a task ID or --selector is required")
+			return
+		}
+
+		url := fmt.Sprintf("http://%s/v1/tasks/%s", manager, args[0])
 		client := &http.Client{}
 		req, err := http.NewRequest("DELETE", url, nil)
 		if err != nil {
@@ -48,5 +70,6 @@ func init() {
 	rootCmd.AddCommand(stopCmd)
 
 	stopCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	stopCmd.Flags().StringP("selector", "l", "", "Stop every running task whose labels match this selector")
 
 }
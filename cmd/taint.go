@@ -0,0 +1,87 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"cube/manager"
+	"cube/taints"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// taintCmd represents the taint command
+var taintCmd = &cobra.Command{
+	Use:   "taint",
+	Short: "Update the taints of a node",
+}
+
+// taintNodeCmd represents the taint node command
+var taintNodeCmd = &cobra.Command{
+	Use:   "node <name> KEY[=VALUE]:EFFECT|KEY[:EFFECT]- ...",
+	Short: "Update the taints of a node",
+	Long: `cube taint node command.
+
+The taint node command adds taints to a node and removes them. Only tasks that
+tolerate the taints of a node are placed on it. The effect is one of
+NoSchedule, PreferNoSchedule and NoExecute. Tasks already running on the node
+that do not tolerate a NoExecute taint are stopped and scheduled again on
+another node. Node taints are kept in the manager's memory and have to be set
+again when the manager restarts.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		addr, _ := cmd.Flags().GetString("manager")
+
+		req, err := parseTaintChanges(args[1:])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		var resp manager.TaintNodeResponse
+		url := fmt.Sprintf("http://%s/v1/nodes/%s/taints", addr, args[0])
+		if !sendJSON(http.MethodPatch, url, req, http.StatusOK, &resp) {
+			return
+		}
+
+		var ts []string
+		for _, t := range resp.Node.Taints {
+			ts = append(ts, t.String())
+		}
+		log.Printf("Taints of %s: %s", resp.Node.Name, strings.Join(ts, ","))
+		for _, t := range resp.Evicted {
+			log.Printf("Evicted task %s (%s)", t.Name, t.ID)
+		}
+	},
+}
+
+// parseTaintChanges は"KEY=VALUE:EFFECT"を加えるテイント、"KEY:EFFECT-"と"KEY-"を除くテイントに変換する
+func parseTaintChanges(args []string) (manager.TaintNodeRequest, error) {
+	var req manager.TaintNodeRequest
+	for _, arg := range args {
+		if s, ok := strings.CutSuffix(arg, "-"); ok {
+			key, effect, _ := strings.Cut(s, ":")
+			req.Remove = append(req.Remove, taints.Taint{Key: key, Effect: effect})
+			continue
+		}
+
+		t, err := taints.Parse(arg)
+		if err != nil {
+			return req, err
+		}
+		req.Add = append(req.Add, t)
+	}
+
+	return req, nil
+}
+
+func init() {
+	rootCmd.AddCommand(taintCmd)
+	taintCmd.AddCommand(taintNodeCmd)
+
+	taintCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+}
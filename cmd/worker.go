@@ -25,17 +25,21 @@ The worker runs tasks and responds to the manager's requests about task state.`,
 		port, _ := cmd.Flags().GetInt("port")
 		name, _ := cmd.Flags().GetString("name")
 		dbType, _ := cmd.Flags().GetString("dbtype")
+		runtime, _ := cmd.Flags().GetString("runtime")
+		allowedHostPaths, _ := cmd.Flags().GetStringSlice("allowed-host-paths")
 
 		log.Println("Starting worker")
-		w, err := worker.New(name, dbType)
+		w, err := worker.New(name, dbType, runtime)
 		if err != nil {
 			log.Println(err)
 			return
 		}
+		w.AllowedHostPaths = allowedHostPaths
 		api := worker.Api{Address: host, Port: port, Worker: w}
 		go w.RunTasks()
 		go w.CollectStats()
 		go w.UpdateTasks()
+		go w.ProbeTasks()
 		go log.Printf("Starting worker API on http://%s:%d", host, port)
 		api.Start()
 
@@ -49,5 +53,7 @@ func init() {
 	workerCmd.Flags().IntP("port", "p", 5556, "Port on which listen")
 	workerCmd.Flags().StringP("name", "n", fmt.Sprintf("worker-%s", uuid.New().String()), "Name of the worker")
 	workerCmd.Flags().StringP("dbtype", "d", "memory", "Type of datastore to use for tasks memory or persistent")
+	workerCmd.Flags().StringP("runtime", "r", "docker", "Runtime used to run tasks: docker or exec")
+	workerCmd.Flags().StringSlice("allowed-host-paths", []string{}, "Host paths tasks are allowed to bind mount")
 
 }
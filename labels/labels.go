@@ -0,0 +1,97 @@
+package labels
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// キーは"team"や"example.com/team"の形式。名前と値は英数字で始まり英数字で終わる63文字以内
+var (
+	validName   = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9_.]{0,61}[a-zA-Z0-9])?$`)
+	validPrefix = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+)
+
+func ValidateKey(key string) error {
+	name := key
+	if prefix, n, ok := strings.Cut(key, "/"); ok {
+		if !validPrefix.MatchString(prefix) {
+			return fmt.Errorf("invalid label key %q: prefix must be a DNS subdomain", key)
+		}
+		name = n
+	}
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+
+	return nil
+}
+
+func ValidateValue(value string) error {
+	if value != "" && !validName.MatchString(value) {
+		return fmt.Errorf("invalid label value %q", value)
+	}
+
+	return nil
+}
+
+func Validate(labels map[string]string) error {
+	var errs []error
+	for _, k := range Keys(labels) {
+		if err := ValidateKey(k); err != nil {
+			errs = append(errs, err)
+		}
+		if err := ValidateValue(labels[k]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Update はchangesをlabelsに反映したマップを返す。値がnilのキーは削除する
+func Update(labels map[string]string, changes map[string]*string) (map[string]string, error) {
+	updated := make(map[string]string, len(labels))
+	for k, v := range labels {
+		updated[k] = v
+	}
+
+	for k, v := range changes {
+		if v == nil {
+			delete(updated, k)
+			continue
+		}
+		updated[k] = *v
+	}
+
+	if err := Validate(updated); err != nil {
+		return nil, err
+	}
+	if len(updated) == 0 {
+		return nil, nil
+	}
+
+	return updated, nil
+}
+
+// Format はラベルを"k1=v1,k2=v2"の形式でキーの順に並べる
+func Format(labels map[string]string) string {
+	var pairs []string
+	for _, k := range Keys(labels) {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func Keys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,90 @@
+package labels
+
+import "testing"
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     string
+	}{
+		{"env=prod", "env=prod"},
+		{"env==prod, tier!=db", "env=prod,tier!=db"},
+		{"env=prod,tier in (web, api)", "env=prod,tier in (web,api)"},
+		{"tier notin (db),canary,!legacy", "tier notin (db),canary,!legacy"},
+		{"example.com/team=infra", "example.com/team=infra"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.selector)
+		if err != nil {
+			t.Errorf("%q: %v", tt.selector, err)
+			continue
+		}
+		if got := s.String(); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.selector, tt.want, got)
+		}
+	}
+
+	for _, selector := range []string{"tier in web", "tier like (web)", "-env=prod", "env=pr od", "tier in (web"} {
+		if _, err := Parse(selector); err == nil {
+			t.Errorf("%q: expected an error", selector)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "tier": "web"}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"canary!=true", true},
+		{"env=prod,tier in (web,api)", true},
+		{"env=prod,tier in (db)", false},
+		{"tier notin (db)", true},
+		{"tier", true},
+		{"!tier", false},
+		{"!canary", true},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.selector)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.selector, err)
+		}
+		if got := s.Matches(labels); got != tt.want {
+			t.Errorf("%q: expected %v, got %v", tt.selector, tt.want, got)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	prod := "prod"
+	original := map[string]string{"env": "dev", "tier": "web"}
+
+	updated, err := Update(original, map[string]*string{"env": &prod, "tier": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Format(updated); got != "env=prod" {
+		t.Errorf("expected env=prod, got %s", got)
+	}
+	if original["env"] != "dev" {
+		t.Error("expected the original labels to be left unchanged")
+	}
+
+	if updated, _ := Update(updated, map[string]*string{"env": nil}); updated != nil {
+		t.Errorf("expected no labels, got %v", updated)
+	}
+
+	bad := "a b"
+	if _, err := Update(original, map[string]*string{"env": &bad}); err == nil {
+		t.Error("expected an invalid value to be rejected")
+	}
+}
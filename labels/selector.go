@@ -0,0 +1,179 @@
+package labels
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	OpEquals       = "="
+	OpNotEquals    = "!="
+	OpIn           = "in"
+	OpNotIn        = "notin"
+	OpExists       = "exists"
+	OpDoesNotExist = "!"
+)
+
+// Requirement はセレクタの条件の一つ
+type Requirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+func (r Requirement) Matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+
+	switch r.Operator {
+	case OpEquals:
+		return ok && v == r.Values[0]
+	case OpNotEquals:
+		return !ok || v != r.Values[0]
+	case OpIn:
+		return ok && slices.Contains(r.Values, v)
+	case OpNotIn:
+		return !ok || !slices.Contains(r.Values, v)
+	case OpExists:
+		return ok
+	case OpDoesNotExist:
+		return !ok
+	}
+
+	return false
+}
+
+func (r Requirement) String() string {
+	switch r.Operator {
+	case OpExists:
+		return r.Key
+	case OpDoesNotExist:
+		return "!" + r.Key
+	case OpIn, OpNotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ","))
+	}
+
+	return r.Key + r.Operator + r.Values[0]
+}
+
+// Selector はすべての条件に合うラベルを選ぶ。空のセレクタはすべてに合う
+type Selector []Requirement
+
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+func (s Selector) String() string {
+	var parts []string
+	for _, r := range s {
+		parts = append(parts, r.String())
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Parse は"env=prod,tier in (web,api),!canary"のようなセレクタを読む。
+// 使える条件は key=value、key==value、key!=value、key in (v1,v2)、key notin (v1,v2)、key、!key
+func Parse(selector string) (Selector, error) {
+	var s Selector
+
+	for _, part := range splitRequirements(selector) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		r, err := parseRequirement(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", selector, err)
+		}
+		s = append(s, r)
+	}
+
+	return s, nil
+}
+
+// splitRequirements は括弧の中を除いてカンマで区切る
+func splitRequirements(selector string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, selector[start:])
+}
+
+func parseRequirement(part string) (Requirement, error) {
+	if key, ok := strings.CutPrefix(part, "!"); ok {
+		key = strings.TrimSpace(key)
+		return Requirement{Key: key, Operator: OpDoesNotExist}, ValidateKey(key)
+	}
+
+	for _, op := range []string{"!=", "==", "="} {
+		if key, value, ok := strings.Cut(part, op); ok {
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if err := ValidateKey(key); err != nil {
+				return Requirement{}, err
+			}
+			if err := ValidateValue(value); err != nil {
+				return Requirement{}, err
+			}
+			if op == "==" {
+				op = OpEquals
+			}
+			return Requirement{Key: key, Operator: op, Values: []string{value}}, nil
+		}
+	}
+
+	fields := strings.Fields(part)
+	if len(fields) == 1 {
+		return Requirement{Key: fields[0], Operator: OpExists}, ValidateKey(fields[0])
+	}
+
+	key, rest, _ := strings.Cut(part, " ")
+	rest = strings.TrimSpace(rest)
+	op, list, _ := strings.Cut(rest, "(")
+	op = strings.TrimSpace(op)
+	if op != OpIn && op != OpNotIn {
+		return Requirement{}, fmt.Errorf("unknown operator %q", op)
+	}
+	list, ok := strings.CutSuffix(strings.TrimSpace(list), ")")
+	if !ok {
+		return Requirement{}, fmt.Errorf("%s requires a list of values in parentheses", op)
+	}
+
+	r := Requirement{Key: key, Operator: op}
+	if err := ValidateKey(key); err != nil {
+		return r, err
+	}
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if err := ValidateValue(v); err != nil {
+			return r, err
+		}
+		r.Values = append(r.Values, v)
+	}
+
+	return r, nil
+}
@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"cube/util"
 	"fmt"
 	"net/http"
 
@@ -16,14 +17,42 @@ type Api struct {
 
 func (a *Api) initRouter() {
 	a.Router = chi.NewRouter()
-	a.Router.Route("/tasks", func(r chi.Router) {
+	a.Router.Route("/v1", a.routes)
+	// バージョンのないパスは以前のクライアントのために状態を数値で返す
+	a.Router.Group(func(r chi.Router) {
+		r.Use(util.LegacyStates)
+		a.routes(r)
+	})
+}
+
+func (a *Api) routes(r chi.Router) {
+	r.Route("/tasks", func(r chi.Router) {
 		r.Post("/", a.StartTaskHanndler)
 		r.Get("/", a.GetTaskHandler)
+		r.Delete("/", a.StopTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
+			r.Get("/", a.DescribeTaskHandler)
 			r.Delete("/", a.StopTaskHandler)
+			r.Patch("/labels", a.LabelTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Post("/exec", a.ExecTaskHandler)
 		})
 	})
-	a.Router.Get("/nodes", a.GetNodesHandler)
+	r.Route("/services", func(r chi.Router) {
+		r.Post("/", a.CreateServiceHandler)
+		r.Get("/", a.GetServicesHandler)
+		r.Route("/{serviceID}", func(r chi.Router) {
+			r.Get("/", a.GetServiceHandler)
+			r.Put("/", a.UpdateServiceHandler)
+			r.Delete("/", a.DeleteServiceHandler)
+			r.Post("/rollback", a.RollbackServiceHandler)
+		})
+	})
+	r.Route("/nodes", func(r chi.Router) {
+		r.Get("/", a.GetNodesHandler)
+		r.Patch("/{nodeName}/labels", a.LabelNodeHandler)
+		r.Patch("/{nodeName}/taints", a.TaintNodeHandler)
+	})
 }
 
 func (a *Api) Start() {
@@ -1,10 +1,15 @@
 package manager
 
 import (
+	"cube/labels"
 	"cube/node"
+	"cube/service"
+	"cube/taints"
 	"cube/task"
+	"cube/util"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -38,28 +43,113 @@ func (a *Api) StartTaskHanndler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := te.Task.Validate(); err != nil {
+		msg := fmt.Sprintf("[Manager] Invalid task %v: %v", te.Task.ID, err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	a.Manager.mu.Lock()
 	a.Manager.AddTask(te)
+	a.Manager.mu.Unlock()
 	log.Printf("Added task %v\n", te.Task.ID)
 	w.WriteHeader(201)
 	json.NewEncoder(w).Encode(te.Task)
 }
 
+// GetTaskHandler はクエリパラメータで絞り込んだタスクを返す。
+// 続きがある場合は次のページのカーソルをX-Next-Cursorヘッダで返す
 func (a *Api) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	q, err := ParseTaskQuery(r.URL.Query())
+	var tasks []*task.Task
+	var next string
+	if err == nil {
+		tasks, next, err = a.Manager.QueryTasks(q)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Invalid task query: %v", err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	if tasks == nil {
+		tasks = []*task.Task{}
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(a.Manager.GetTasks())
+	json.NewEncoder(w).Encode(tasks)
 }
 
-func (a *Api) GetNodesHandler(w http.ResponseWriter, r *http.Request) {
+func (a *Api) DescribeTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	taskID := chi.URLParam(r, "taskID")
+	d, err := a.Manager.DescribeTask(taskID)
+	if err != nil {
+		log.Printf("No task %v found: %v", taskID, err)
+		w.WriteHeader(404)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(d)
+}
+
+func (a *Api) GetNodesHandler(w http.ResponseWriter, r *http.Request) {
+	selector, err := labels.Parse(r.URL.Query().Get("selector"))
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Invalid node query: %v", err)
+		log.Println(msg)
 
-	var nodes []*node.Node
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	// 統計の取得には時間がかかるので、muを取る前に済ませる
 	for _, n := range a.Manager.GetNodes() {
-		node.GetStats(n)
-		nodes = append(nodes, n)
+		a.Manager.updateNodeStats(n)
+	}
+
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	a.Manager.refreshNodes()
+	nodes := []*node.Node{}
+	for _, n := range a.Manager.GetNodes() {
+		if selector.Matches(n.Labels) {
+			nodes = append(nodes, n)
+		}
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
 	json.NewEncoder(w).Encode(nodes)
 }
 
@@ -71,6 +161,9 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
 	tID, _ := uuid.Parse(taskID)
 	taskToStop, err := a.Manager.TaskDb.Get(tID.String())
 	if err != nil {
@@ -79,17 +172,492 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	te := task.TaskEvent{
-		ID:        uuid.New(),
-		State:     task.Completed,
-		Timestamp: time.Now(),
+	te := a.Manager.StopTask(taskToStop)
+
+	log.Printf("Added task %v to stop task %v\n", te.ID, taskToStop.ContainerID)
+	w.WriteHeader(204)
+}
+
+// StopTasksHandler はselectorに合うタスクをすべて停止し、停止したタスクを返す。
+// すべてのタスクを誤って止めないように、selectorは必須とする
+func (a *Api) StopTasksHandler(w http.ResponseWriter, r *http.Request) {
+	selector, err := labels.Parse(r.URL.Query().Get("selector"))
+	if err == nil && selector.Empty() {
+		err = fmt.Errorf("selector is required to stop several tasks")
+	}
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Invalid request: %v", err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	stopped := a.Manager.StopTasks(selector)
+	if stopped == nil {
+		stopped = []*task.Task{}
 	}
 
-	taskCopy := *taskToStop
-	taskCopy.State = task.Completed
-	te.Task = taskCopy
-	a.Manager.AddTask(te)
+	log.Printf("Stopping %d tasks matching %s\n", len(stopped), selector)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(stopped)
+}
 
-	log.Printf("Added task %v to stop task %v\n", te.ID, taskToStop.ContainerID)
+// LabelTaskHandler はタスクのラベルを変更する。
+// bodyは{"env": "prod", "canary": null}の形式で、値がnullのラベルは削除する
+func (a *Api) LabelTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	changes, decodeErr := decodeLabels(r)
+
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	t, err := a.Manager.GetTask(taskID)
+	if err != nil {
+		log.Printf("No task %v found", taskID)
+		w.WriteHeader(404)
+		return
+	}
+
+	updated, err := applyLabels(t.Labels, changes, decodeErr)
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Invalid labels for task %v: %v", t.ID, err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	t.Labels = updated
+	a.Manager.TaskDb.Put(t.ID.String(), t)
+
+	log.Printf("Updated labels of task %v: %s\n", t.ID, labels.Format(t.Labels))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(t)
+}
+
+// LabelNodeHandler はノードのラベルを変更する。bodyの形式はLabelTaskHandlerと同じ
+func (a *Api) LabelNodeHandler(w http.ResponseWriter, r *http.Request) {
+	nodeName := chi.URLParam(r, "nodeName")
+	changes, decodeErr := decodeLabels(r)
+
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	n, err := a.Manager.GetNode(nodeName)
+	if err != nil {
+		log.Printf("No node %v found", nodeName)
+		w.WriteHeader(404)
+		return
+	}
+
+	updated, err := applyLabels(n.Labels, changes, decodeErr)
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Invalid labels for node %v: %v", n.Name, err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	n.Labels = updated
+
+	log.Printf("Updated labels of node %v: %s\n", n.Name, labels.Format(n.Labels))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(n)
+}
+
+// TaintNodeRequest はノードに加えるテイントと除くテイント。除くテイントのEffectが空ならキーが同じものをすべて除く
+type TaintNodeRequest struct {
+	Add    []taints.Taint
+	Remove []taints.Taint
+}
+
+// TaintNodeResponse は変更後のノードと、NoExecuteのテイントで退避させたタスク
+type TaintNodeResponse struct {
+	Node    *node.Node
+	Evicted []*task.Task
+}
+
+// TaintNodeHandler はノードのテイントを変更する。
+// NoExecuteのテイントを許容しないタスクは停止し、pendingキューに戻して別のノードで動かす
+func (a *Api) TaintNodeHandler(w http.ResponseWriter, r *http.Request) {
+	nodeName := chi.URLParam(r, "nodeName")
+
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	n, err := a.Manager.GetNode(nodeName)
+	if err != nil {
+		log.Printf("No node %v found", nodeName)
+		w.WriteHeader(404)
+		return
+	}
+
+	var req TaintNodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		msg := fmt.Sprintf("[Manager] Error unmarshalling body: %v", err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	evicted, err := a.Manager.TaintNode(n, req.Add, req.Remove)
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Invalid taints for node %v: %v", n.Name, err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	log.Printf("Updated taints of node %v, evicted %d tasks\n", n.Name, len(evicted))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(TaintNodeResponse{Node: n, Evicted: evicted})
+}
+
+// decodeLabels はラベルの変更を読む。muを取る前に呼ぶ
+func decodeLabels(r *http.Request) (map[string]*string, error) {
+	var changes map[string]*string
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		return nil, fmt.Errorf("error unmarshalling body: %v", err)
+	}
+
+	return changes, nil
+}
+
+func applyLabels(current map[string]string, changes map[string]*string, decodeErr error) (map[string]string, error) {
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	return labels.Update(current, changes)
+}
+
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tID, _ := uuid.Parse(taskID)
+
+	worker, ok := a.Manager.taskWorker(tID)
+	if !ok {
+		log.Printf("No worker found for task %v", taskID)
+		w.WriteHeader(404)
+		return
+	}
+
+	url := fmt.Sprintf("http://%s/tasks/%s/logs?%s", worker, tID, r.URL.RawQuery)
+	req, err := http.NewRequestWithContext(r.Context(), "GET", url, nil)
+	if err != nil {
+		log.Printf("Error creating request %v: %v", url, err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Error connecting to %v: %v", worker, err)
+		log.Println(msg)
+
+		w.WriteHeader(502)
+		e := ErrResponse{
+			HTTPStatusCode: 502,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	util.StreamResponse(w, resp.Body)
+}
+
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tID, _ := uuid.Parse(taskID)
+
+	worker, ok := a.Manager.taskWorker(tID)
+	if !ok {
+		log.Printf("No worker found for task %v", taskID)
+		w.WriteHeader(404)
+		return
+	}
+
+	url := fmt.Sprintf("http://%s/tasks/%s/exec", worker, tID)
+	// クライアントが切断してハンドラが戻ったらワーカーへの接続も閉じる
+	resp, upstream, err := util.PostUpgrade(r.Context(), url, r.Body)
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Error connecting to %v: %v", worker, err)
+		log.Println(msg)
+
+		w.WriteHeader(502)
+		e := ErrResponse{
+			HTTPStatusCode: 502,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	if upstream == nil {
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	defer upstream.Close()
+
+	conn, buf, err := util.Hijack(w)
+	if err != nil {
+		log.Printf("Error hijacking connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// どちらかが閉じたらもう一方も閉じる
+	go func() {
+		io.Copy(upstream, buf)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+}
+
+func (a *Api) CreateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	s := service.Service{}
+	err := d.Decode(&s)
+	if err == nil {
+		err = s.Validate()
+	}
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Invalid service %s: %v", s.Name, err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	if _, err := a.Manager.GetService(s.Name); err == nil {
+		msg := fmt.Sprintf("[Manager] Service %s already exists", s.Name)
+		log.Println(msg)
+
+		w.WriteHeader(409)
+		e := ErrResponse{
+			HTTPStatusCode: 409,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	created := service.Service{ID: s.ID, Name: s.Name}
+	if created.ID == uuid.Nil {
+		created.ID = uuid.New()
+	}
+	created.Apply(&s)
+	created.CreatedAt = time.Now().UTC()
+	created.UpdatedAt = created.CreatedAt
+	s = created
+	a.Manager.ServiceDb.Put(s.ID.String(), &s)
+
+	log.Printf("Added service %s (%v)\n", s.Name, s.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) GetServicesHandler(w http.ResponseWriter, r *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	services := a.Manager.GetServices()
+	if services == nil {
+		services = []*service.Service{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(services)
+}
+
+func (a *Api) GetServiceHandler(w http.ResponseWriter, r *http.Request) {
+	serviceID := chi.URLParam(r, "serviceID")
+
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	s, err := a.Manager.GetService(serviceID)
+	if err != nil {
+		log.Printf("No service %v found", serviceID)
+		w.WriteHeader(404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(s)
+}
+
+// UpdateServiceHandler はサービスの仕様を置き換える。
+// ImageかTemplateが変わった場合は新しいリビジョンを作り、ローリングアップデートを始める
+func (a *Api) UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	serviceID := chi.URLParam(r, "serviceID")
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	s := service.Service{}
+	decodeErr := d.Decode(&s)
+
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	current, err := a.Manager.GetService(serviceID)
+	if err != nil {
+		log.Printf("No service %v found", serviceID)
+		w.WriteHeader(404)
+		return
+	}
+
+	err = decodeErr
+	if err == nil && s.Name != "" && s.Name != current.Name {
+		err = fmt.Errorf("cannot rename service %s to %s", current.Name, s.Name)
+	}
+	s.ID = current.ID
+	s.Name = current.Name
+	if err == nil {
+		err = s.Validate()
+	}
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Invalid service %s: %v", current.Name, err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	current.Apply(&s)
+	current.UpdatedAt = time.Now().UTC()
+	a.Manager.ServiceDb.Put(current.ID.String(), current)
+
+	log.Printf("Updated service %s (%v) to revision %d\n", current.Name, current.ID, current.Revision)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(current)
+}
+
+// RollbackServiceHandler はサービスを指定したリビジョンに戻す。指定しない場合は一つ前のリビジョンに戻す
+func (a *Api) RollbackServiceHandler(w http.ResponseWriter, r *http.Request) {
+	serviceID := chi.URLParam(r, "serviceID")
+
+	var req struct{ Revision int }
+	var decodeErr error
+	if r.ContentLength != 0 {
+		decodeErr = json.NewDecoder(r.Body).Decode(&req)
+	}
+
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	s, err := a.Manager.GetService(serviceID)
+	if err != nil {
+		log.Printf("No service %v found", serviceID)
+		w.WriteHeader(404)
+		return
+	}
+
+	err = decodeErr
+	if err == nil {
+		err = s.Rollback(req.Revision)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("[Manager] Unable to roll back service %s: %v", s.Name, err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	s.UpdatedAt = time.Now().UTC()
+	a.Manager.ServiceDb.Put(s.ID.String(), s)
+
+	log.Printf("Rolling back service %s (%v) as revision %d\n", s.Name, s.ID, s.Revision)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(s)
+}
+
+// DeleteServiceHandler はサービスを削除する。タスクは次の調整で停止する
+func (a *Api) DeleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	serviceID := chi.URLParam(r, "serviceID")
+
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+
+	s, err := a.Manager.GetService(serviceID)
+	if err != nil {
+		log.Printf("No service %v found", serviceID)
+		w.WriteHeader(404)
+		return
+	}
+
+	a.Manager.ServiceDb.Delete(s.ID.String())
+
+	log.Printf("Deleted service %s (%v)\n", s.Name, s.ID)
 	w.WriteHeader(204)
 }
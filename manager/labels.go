@@ -0,0 +1,63 @@
+package manager
+
+import (
+	"cube/labels"
+	"cube/node"
+	"cube/task"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func (m *Manager) GetNode(name string) (*node.Node, error) {
+	for _, n := range m.WorkerNodes {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+
+	return nil, fmt.Errorf("node %s not found", name)
+}
+
+// StopTask はタスクを停止するイベントをpendingキューに追加する。退避中のタスクは別のノードで動かさない
+func (m *Manager) StopTask(t *task.Task) task.TaskEvent {
+	delete(m.evicting, t.ID)
+	return m.queueStop(t)
+}
+
+func (m *Manager) queueStop(t *task.Task) task.TaskEvent {
+	taskCopy := *t
+	taskCopy.State = task.Completed
+
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Completed,
+		Timestamp: time.Now(),
+		Task:      taskCopy,
+	}
+	m.AddTask(te)
+	m.stopRequests[t.ID] = time.Time{}
+
+	return te
+}
+
+// StopTasks はセレクタに合う動いているタスクをすべて停止し、停止したタスクを返す
+func (m *Manager) StopTasks(selector labels.Selector) []*task.Task {
+	var stopped []*task.Task
+	for _, t := range m.GetTasks() {
+		switch t.State {
+		case task.Pending, task.Scheduled, task.Running:
+		default:
+			continue
+		}
+		if !selector.Matches(t.Labels) {
+			continue
+		}
+
+		m.StopTask(t)
+		stopped = append(stopped, t)
+	}
+
+	return stopped
+}
@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"cube/labels"
+	"cube/task"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestStopTasksBySelector(t *testing.T) {
+	m, _ := newTestCluster(t)
+	tasks := putQueryTasks(m)
+	tasks[0].Labels = map[string]string{"env": "prod", "tier": "web"}
+	tasks[1].Labels = map[string]string{"env": "dev", "tier": "web"}
+	tasks[2].Labels = map[string]string{"env": "prod", "tier": "api"}
+	tasks[3].Labels = map[string]string{"env": "prod", "tier": "web"}
+
+	selector, _ := labels.Parse("env=prod,tier in (web,api)")
+	stopped := m.StopTasks(selector)
+	sort.Slice(stopped, func(i, j int) bool { return stopped[i].Name < stopped[j].Name })
+
+	// 完了したjob-1はすでに止まっているので含めない
+	if names(stopped) != "[api-1 web-1]" {
+		t.Errorf("expected web-1 and api-1 to be stopped, got %s", names(stopped))
+	}
+	if m.Penging.Len() != 2 {
+		t.Errorf("expected 2 stop events, got %d", m.Penging.Len())
+	}
+}
+
+func TestQueryTasksBySelector(t *testing.T) {
+	m, _ := newTestCluster(t)
+	tasks := putQueryTasks(m)
+	tasks[0].Labels = map[string]string{"tier": "web"}
+	tasks[1].Labels = map[string]string{"tier": "web", "canary": "true"}
+
+	v, _ := url.ParseQuery("selector=" + url.QueryEscape("tier=web,!canary"))
+	q, err := ParseTaskQuery(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := m.QueryTasks(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(got) != "[web-1]" {
+		t.Errorf("expected [web-1], got %s", names(got))
+	}
+
+	v, _ = url.ParseQuery("selector=" + url.QueryEscape("tier in web"))
+	if _, err := ParseTaskQuery(v); err == nil {
+		t.Error("expected an invalid selector to be rejected")
+	}
+}
+
+func TestSendWorkHonorsNodeSelector(t *testing.T) {
+	m, _ := newTestCluster(t)
+
+	te := newTaskEvent("selector")
+	te.Task.NodeSelector = "disk=ssd"
+	m.AddTask(te)
+	m.SendWork()
+
+	if _, ok := m.TaskWorkerMap[te.Task.ID]; ok {
+		t.Fatal("expected the task not to be scheduled on a node without the label")
+	}
+	if m.Penging.Len() != 1 {
+		t.Fatalf("expected the task to be requeued, got %d", m.Penging.Len())
+	}
+
+	m.WorkerNodes[0].Labels = map[string]string{"disk": "ssd"}
+	m.SendWork()
+
+	if got := m.TaskWorkerMap[te.Task.ID]; got != m.Workers[0] {
+		t.Errorf("expected the task to be scheduled on %s, got %q", m.Workers[0], got)
+	}
+}
+
+func TestLabelApi(t *testing.T) {
+	m, _ := newTestCluster(t)
+	tasks := putQueryTasks(m)
+
+	api := Api{Manager: m}
+	api.initRouter()
+	srv := httptest.NewServer(api.Router)
+	t.Cleanup(srv.Close)
+
+	patch := func(path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPatch, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := patch("/v1/tasks/web-1/labels", `{"env": "prod", "tier": "web"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	resp = patch("/v1/tasks/"+tasks[0].ID.String()+"/labels", `{"tier": null}`)
+	var tk task.Task
+	json.NewDecoder(resp.Body).Decode(&tk)
+	if got := labels.Format(tk.Labels); got != "env=prod" {
+		t.Errorf("expected env=prod, got %s", got)
+	}
+	if resp := patch("/v1/tasks/web-1/labels", `{"env": "a b"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid label, got %d", resp.StatusCode)
+	}
+
+	if resp := patch("/v1/nodes/"+m.Workers[0]+"/labels", `{"disk": "ssd"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+	if got := labels.Format(m.WorkerNodes[0].Labels); got != "disk=ssd" {
+		t.Errorf("expected disk=ssd on the node, got %s", got)
+	}
+	if resp := patch("/v1/nodes/unknown/labels", `{"disk": "ssd"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown node, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/v1/tasks", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without a selector, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, srv.URL+"/v1/tasks?selector=env%3Dprod", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stopped []*task.Task
+	json.NewDecoder(resp.Body).Decode(&stopped)
+	if names(stopped) != "[web-1]" {
+		t.Errorf("expected web-1 to be stopped, got %s", names(stopped))
+	}
+}
+
+func TestSendWorkHonorsAntiAffinity(t *testing.T) {
+	m, _ := newTestCluster(t)
+
+	newReplica := func() task.TaskEvent {
+		te := newTaskEvent("replica")
+		te.Task.ExposedPorts = nil
+		te.Task.Labels = map[string]string{"app": "web"}
+		te.Task.Affinity = &task.Affinity{AntiTask: []task.AffinityTerm{{Selector: "app=web", Required: true}}}
+		return te
+	}
+
+	first, second := newReplica(), newReplica()
+	m.AddTask(first)
+	m.AddTask(second)
+	m.SendWork()
+	m.SendWork()
+
+	if _, ok := m.TaskWorkerMap[first.Task.ID]; !ok {
+		t.Fatal("expected the first replica to be scheduled")
+	}
+	if _, ok := m.TaskWorkerMap[second.Task.ID]; ok {
+		t.Error("expected the second replica not to share the node with the first")
+	}
+	if m.Penging.Len() != 1 {
+		t.Errorf("expected the second replica to wait in the pending queue, got %d", m.Penging.Len())
+	}
+}
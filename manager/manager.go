@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"cube/node"
 	"cube/scheduler"
+	"cube/service"
 	"cube/store"
 	"cube/task"
 	"cube/worker"
@@ -12,10 +13,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/docker/go-connections/nat"
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
 )
@@ -33,14 +34,9 @@ func New(workers []string, schedulerType, dbType string) (*Manager, error) {
 		nodes = append(nodes, n)
 	}
 
-	var s scheduler.Scheduler
-	switch schedulerType {
-	case "roundrobin":
-		s = &scheduler.RoundRobin{Name: "roundrobin"}
-	case "epvm":
-		s = &scheduler.Epvm{Name: "epvm"}
-	default:
-		s = &scheduler.RoundRobin{Name: "roundrobin"}
+	s, err := scheduler.Load(schedulerType)
+	if err != nil {
+		return nil, err
 	}
 
 	m := &Manager{
@@ -50,15 +46,21 @@ func New(workers []string, schedulerType, dbType string) (*Manager, error) {
 		WorkerTaskMap: workerTaskMap,
 		WorkerNodes:   nodes,
 		Scheduler:     s,
+		portConflicts: make(map[string]map[string]time.Time),
+		stopping:      make(map[uuid.UUID]bool),
+		stopRequests:  make(map[uuid.UUID]time.Time),
+		evicting:      make(map[uuid.UUID]bool),
+		evictedFrom:   make(map[uuid.UUID]string),
 	}
 
 	var ts store.Store[*task.Task]
 	var es store.Store[*task.TaskEvent]
-	var err error
+	var ss store.Store[*service.Service]
 	switch dbType {
 	case "memory":
 		ts = store.NewInMemoryTaskStore[*task.Task]()
 		es = store.NewInMemoryTaskStore[*task.TaskEvent]()
+		ss = store.NewInMemoryTaskStore[*service.Service]()
 	case "persistent":
 		ts, err = store.NewPersistentTaskStore[*task.Task]("tasks.db", 0600, "tasks")
 		if err != nil {
@@ -69,40 +71,154 @@ func New(workers []string, schedulerType, dbType string) (*Manager, error) {
 		if err != nil {
 			return nil, err
 		}
+
+		ss, err = store.NewPersistentTaskStore[*service.Service]("services.db", 0600, "services")
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	m.TaskDb = ts
 	m.EventDb = es
+	m.ServiceDb = ss
 
 	return m, nil
 
 }
 
 type Manager struct {
+	// mu はAPIのハンドラと各ループがキュー、マップ、ストア、ノードを読み書きする間に取る。
+	// マネージャのメソッドは取らないので呼び出す側で取る。updateTasksだけはワーカーと通信する間は外す
+	mu            sync.Mutex
 	Penging       queue.Queue
 	TaskDb        store.Store[*task.Task]
 	EventDb       store.Store[*task.TaskEvent]
+	ServiceDb     store.Store[*service.Service]
 	Workers       []string
 	WorkerTaskMap map[string][]uuid.UUID
 	TaskWorkerMap map[uuid.UUID]string
 	LastWorker    int
 	WorkerNodes   []*node.Node
 	Scheduler     scheduler.Scheduler
+	portConflicts map[string]map[string]time.Time
+	// サービスの縮小で停止を依頼し、まだ止まっていないタスク
+	stopping map[uuid.UUID]bool
+	// 停止を依頼し、ワーカーがまだ完了を報告していないタスクと、停止をワーカーに送った時刻。
+	// キューで待っている間はゼロ
+	stopRequests map[uuid.UUID]time.Time
+	// NoExecuteのテイントで退避させ、ワーカーでの停止を待っているタスク
+	evicting map[uuid.UUID]bool
+	// 退避させたタスクと、退避させる前のワーカー
+	evictedFrom map[uuid.UUID]string
 }
 
+// ワーカーから使用中だと報告されたホストポートを、そのワーカーで避ける期間
+const portConflictCooldown = time.Minute
+
+// 停止を送ってもワーカーがタスクを止めない場合に、停止を送り直すまでの期間
+const stopRetryPeriod = time.Minute
+
 func (m *Manager) SelectWorker(t task.Task) (*node.Node, error) {
+	m.refreshNodes()
 	candidates := m.Scheduler.SelectCandidateNodes(t, m.WorkerNodes)
 	if candidates == nil {
-		msg := m.logln("No available candidates match resource request for task %v", t.ID)
-		err := errors.New(msg)
-		return nil, err
+		reason := scheduler.Unschedulable(m.Scheduler, t, m.WorkerNodes)
+		m.logln("No available candidates match resource request for task %v: %s", t.ID, reason)
+		return nil, errors.New(reason)
 	}
 	scores := m.Scheduler.Score(t, candidates)
 	sectedNode := m.Scheduler.Pick(scores, candidates)
+	if b, ok := m.Scheduler.(scheduler.Binder); ok {
+		b.Bind(t, sectedNode)
+	}
 
 	return sectedNode, nil
 }
 
+// refreshNodes は各ノードに割り当てたタスクの数とリソース、確保済みのホストポート、タスクのラベルをTaskDbから計算し直す
+func (m *Manager) refreshNodes() {
+	nodes := make(map[string]*node.Node)
+	for _, n := range m.WorkerNodes {
+		n.TaskCount = 0
+		n.CpuAllocated = 0
+		n.MemoryAllocated = 0
+		n.DiskAllocated = 0
+		n.PortsAllocated = make(map[string]string)
+		n.TaskLabels = make(map[string]map[string]string)
+		nodes[n.Name] = n
+	}
+
+	for _, t := range m.GetTasks() {
+		if t.State != task.Scheduled && t.State != task.Running {
+			continue
+		}
+
+		n, ok := nodes[m.TaskWorkerMap[t.ID]]
+		if !ok {
+			continue
+		}
+
+		n.TaskCount++
+		n.CpuAllocated += t.Cpu
+		// ノードのメモリはKiB単位
+		n.MemoryAllocated += t.Memory / 1024
+		n.DiskAllocated += t.Disk
+		for _, port := range t.BoundHostPorts() {
+			n.PortsAllocated[port] = t.ID.String()
+		}
+		n.TaskLabels[t.ID.String()] = t.Labels
+	}
+
+	for worker, conflicts := range m.portConflicts {
+		for port, at := range conflicts {
+			if time.Since(at) > portConflictCooldown {
+				delete(conflicts, port)
+				continue
+			}
+
+			if n, ok := nodes[worker]; ok {
+				if _, taken := n.PortsAllocated[port]; !taken {
+					n.PortsAllocated[port] = ""
+				}
+			}
+		}
+	}
+}
+
+func (m *Manager) recordPortConflict(worker string, ports []string) {
+	if m.portConflicts[worker] == nil {
+		m.portConflicts[worker] = make(map[string]time.Time)
+	}
+
+	for _, port := range ports {
+		m.portConflicts[worker][port] = time.Now()
+	}
+}
+
+// unassignTask はスケジュールできなかったタスクをワーカーの割り当てから外し、pendingキューに戻す
+// forgetAssignment はタスクidをワーカーworkerに割り当てた記録を消す
+func (m *Manager) forgetAssignment(worker string, taskID uuid.UUID) {
+	delete(m.TaskWorkerMap, taskID)
+
+	var ids []uuid.UUID
+	for _, id := range m.WorkerTaskMap[worker] {
+		if id != taskID {
+			ids = append(ids, id)
+		}
+	}
+	m.WorkerTaskMap[worker] = ids
+}
+
+func (m *Manager) unassignTask(worker string, te task.TaskEvent) {
+	m.forgetAssignment(worker, te.Task.ID)
+
+	te.Task.State = task.Pending
+	m.TaskDb.Put(te.Task.ID.String(), &te.Task)
+	m.Penging.Enqueue(te)
+}
+
+// updateTasks はワーカーからタスクの状態とノードの統計を取得して記録する。
+// ワーカーと通信している間は他のループやハンドラを止めないよう、記録する間だけmuを取る
 func (m *Manager) updateTasks() {
 
 	for _, worker := range m.Workers {
@@ -127,28 +243,97 @@ func (m *Manager) updateTasks() {
 			continue
 		}
 
-		for _, t := range tasks {
-			m.logln("Attempting to update task %v", t.ID)
+		m.mu.Lock()
+		m.recordTasks(worker, tasks)
+		m.mu.Unlock()
 
-			taskPersisted, err := m.TaskDb.Get(t.ID.String())
-			if err != nil {
-				m.logln("Task with ID %s not found", t.ID)
-				return
-			}
+		// 応答のあるワーカーのノードの容量を更新する
+		if n, err := m.GetNode(worker); err == nil {
+			m.updateNodeStats(n)
+		}
+	}
 
-			if taskPersisted.State != t.State {
-				taskPersisted.State = t.State
-			}
+	m.mu.Lock()
+	for _, n := range m.WorkerNodes {
+		m.evictTasks(n)
+	}
+	m.mu.Unlock()
+
+	m.logln("Update task")
+}
+
+// recordTasks はワーカーworkerから取得したタスクの状態をTaskDbに記録する
+func (m *Manager) recordTasks(worker string, tasks []*task.Task) {
+	for _, t := range tasks {
+		m.logln("Attempting to update task %v", t.ID)
 
-			taskPersisted.StartTime = t.StartTime
-			taskPersisted.FinishTime = t.FinishTime
-			taskPersisted.ContainerID = t.ContainerID
-			taskPersisted.HostPorts = t.HostPorts
+		taskPersisted, err := m.TaskDb.Get(t.ID.String())
+		if err != nil {
+			m.logln("Task with ID %s not found", t.ID)
+			continue
+		}
+
+		// 退避させる前のワーカーには停止したタスクが残るので、同じワーカーに戻すまでは無視する
+		if from, ok := m.evictedFrom[t.ID]; ok && from == worker && m.TaskWorkerMap[t.ID] != worker {
+			continue
+		}
+		if m.evicting[t.ID] && t.State == task.Completed {
+			delete(m.stopRequests, t.ID)
+			m.rescheduleTask(taskPersisted)
+			continue
+		}
+
+		sentAt, stopPending := m.stopRequests[t.ID]
+		switch {
+		case t.State == task.Completed:
+			delete(m.stopRequests, t.ID)
+			stopPending = false
+		case stopPending && !sentAt.IsZero() && time.Since(sentAt) > stopRetryPeriod:
+			m.logln("Task %s is still %v on the worker, sending the stop again", t.ID, t.State)
+			m.queueStop(taskPersisted)
 		}
 
+		// 停止を依頼している間は、ワーカーが停止を処理する前の状態を返してきても完了のままにする
+		if taskPersisted.State != t.State && !(stopPending && taskPersisted.State == task.Completed) {
+			taskPersisted.State = t.State
+		}
+
+		taskPersisted.StartTime = t.StartTime
+		taskPersisted.FinishTime = t.FinishTime
+		taskPersisted.ExitCode = t.ExitCode
+		taskPersisted.ContainerID = t.ContainerID
+		taskPersisted.Pid = t.Pid
+		taskPersisted.HostPorts = t.HostPorts
+		taskPersisted.Ready = t.Ready
+		taskPersisted.Health = t.Health
+		taskPersisted.HealthMessage = t.HealthMessage
+		taskPersisted.RecordHealth(time.Now().UTC())
+		m.TaskDb.Put(taskPersisted.ID.String(), taskPersisted)
 	}
+}
 
-	m.logln("Update task")
+// updateNodeStats はノードの統計と容量をワーカーから取得する。取得している間はmuを取らない
+func (m *Manager) updateNodeStats(n *node.Node) {
+	fetched := node.Node{Ip: n.Ip}
+	if node.GetStats(&fetched) == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n.Cores = fetched.Cores
+	n.Memory = fetched.Memory
+	n.Disk = fetched.Disk
+	n.Stats = fetched.Stats
+}
+
+// taskWorker はタスクを割り当てたワーカーを返す
+func (m *Manager) taskWorker(id uuid.UUID) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.TaskWorkerMap[id]
+	return w, ok
 }
 
 func (m *Manager) SendWork() {
@@ -173,18 +358,41 @@ func (m *Manager) SendWork() {
 			}
 
 			if te.State == task.Completed && task.ValidStateTransition(persistedTask.State, te.State) {
+				m.stopRequests[te.Task.ID] = time.Now()
 				m.stopTask(taskWorker, te.Task.ID.String())
 				return
 			}
+			delete(m.stopRequests, te.Task.ID)
 
 			m.logln("Invalid rquest: existing task %s is in state %v and cannot transition to the completed state", persistedTask.ID.String(), persistedTask.State)
 			return
 		}
 
+		// まだワーカーに割り当てていないタスクは停止を送らずにそのまま完了にする
+		if te.State == task.Completed {
+			delete(m.stopRequests, te.Task.ID)
+		}
+		if persisted, err := m.TaskDb.Get(te.Task.ID.String()); err == nil && persisted.State == task.Completed {
+			m.logln("Task %s has been stopped before it was scheduled", te.Task.ID)
+			return
+		}
+		if te.State == task.Completed {
+			m.logln("Task %s has not been scheduled yet, marking it completed", te.Task.ID)
+			t := te.Task
+			t.State = task.Completed
+			t.FinishTime = time.Now().UTC()
+			m.TaskDb.Put(t.ID.String(), &t)
+			return
+		}
+
 		t := te.Task
 		w, err := m.SelectWorker(t)
 		if err != nil {
 			m.logln("Error selecting worker for task %s: %v", t.ID, err)
+			t.State = task.Pending
+			t.PendingReason = err.Error()
+			m.TaskDb.Put(t.ID.String(), &t)
+			m.Penging.Enqueue(te)
 			return
 		}
 
@@ -194,7 +402,9 @@ func (m *Manager) SendWork() {
 		m.TaskWorkerMap[t.ID] = w.Name
 
 		t.State = task.Scheduled
+		t.PendingReason = ""
 		m.TaskDb.Put(t.ID.String(), &t)
+		te.Task = t
 
 		data, err := json.Marshal(te)
 		if err != nil {
@@ -220,18 +430,24 @@ func (m *Manager) SendWork() {
 			}
 
 			m.logln("Response error (%d): %s", e.HTTPStatusCode, e.Message)
+
+			if resp.StatusCode == http.StatusConflict {
+				m.logln("Unable to schedule task %s on %s, will try another worker", t.ID, w.Name)
+				m.recordPortConflict(w.Name, t.BoundHostPorts())
+				m.unassignTask(w.Name, te)
+			}
 			return
 		}
 
-		t = task.Task{}
-		err = d.Decode(&t)
+		newTask := task.Task{}
+		err = d.Decode(&newTask)
 		if err != nil {
 			m.logln("Error decoding response %s", err)
 			return
 		}
 
-		t.ScheduledOn = w.Name
-		m.logln("%#v", t)
+		newTask.ScheduledOn = w.Name
+		m.logln("%#v", newTask)
 	} else {
 		m.logln("No Work in the queue")
 	}
@@ -256,6 +472,66 @@ func (m *Manager) GetTasks() []*task.Task {
 	return tasks
 }
 
+// TaskDescription はタスクの詳細。Eventsは古い順に並べる
+type TaskDescription struct {
+	Task   *task.Task
+	Worker string
+	Events []*task.TaskEvent
+}
+
+// GetTask はIDか名前でタスクを探す。同じ名前のタスクが複数ある場合は動いているもの、最後に起動したものを返す
+func (m *Manager) GetTask(key string) (*task.Task, error) {
+	if id, err := uuid.Parse(key); err == nil {
+		return m.TaskDb.Get(id.String())
+	}
+
+	var found *task.Task
+	for _, t := range m.GetTasks() {
+		if t.Name != key {
+			continue
+		}
+		switch {
+		case found == nil:
+			found = t
+		case (t.State == task.Running) != (found.State == task.Running):
+			if t.State == task.Running {
+				found = t
+			}
+		case t.StartTime.After(found.StartTime):
+			found = t
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("task %s not found", key)
+	}
+
+	return found, nil
+}
+
+func (m *Manager) DescribeTask(key string) (*TaskDescription, error) {
+	t, err := m.GetTask(key)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := m.EventDb.List()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &TaskDescription{Task: t, Worker: m.TaskWorkerMap[t.ID]}
+	for _, e := range events {
+		if e.Task.ID == t.ID {
+			d.Events = append(d.Events, e)
+		}
+	}
+	sort.SliceStable(d.Events, func(i, j int) bool {
+		return d.Events[i].Timestamp.Before(d.Events[j].Timestamp)
+	})
+
+	return d, nil
+}
+
 func (m *Manager) UpdateTasks() {
 	for {
 		m.logln("Checking for task updates from workers")
@@ -269,7 +545,9 @@ func (m *Manager) UpdateTasks() {
 func (m *Manager) ProcessTasks() {
 	for {
 		m.logln("Proccessing any tasks in the queue")
+		m.mu.Lock()
 		m.SendWork()
+		m.mu.Unlock()
 		m.logln("Sleeping for 10 seconds")
 		time.Sleep(10 * time.Second)
 	}
@@ -278,74 +556,62 @@ func (m *Manager) ProcessTasks() {
 func (m *Manager) DoHealthChecks() {
 	for {
 		m.logln("Performing task health check")
+		m.mu.Lock()
 		m.doHealthChecks()
+		m.mu.Unlock()
 		m.logln("Task health checks completed")
-		m.logln("Sleeping for 60 seconds")
-		time.Sleep(60 * time.Second)
-	}
-}
-
-func (m *Manager) checkTaskHealth(t task.Task) error {
-	m.logln("Calling health check for task %s: %s", t.ID, t.HealthCheck)
-	w := m.TaskWorkerMap[t.ID]
-	hostport := getHostPort(t.HostPorts)
-	if hostport == nil {
-		m.logln("Hostport is empty")
-		return nil
-	}
-
-	worker := strings.Split(w, ":")
-	url := fmt.Sprintf("http://%s:%s%s", worker[0], *hostport, t.HealthCheck)
-
-	m.logln("Calling health check for task %s: %s", t.ID, url)
-	resp, err := http.Get(url)
-	if err != nil {
-		msg := fmt.Sprintf("Error connecting to health check %s", url)
-		m.logln(msg)
-		return errors.New(msg)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		msg := fmt.Sprintf("Error health check for task %s did not return 200", t.ID)
-		m.logln(msg)
-		return errors.New(msg)
-	}
-
-	m.logln("Task %s health check response: %v", t.ID, resp.StatusCode)
-
-	return nil
-}
-
-func getHostPort(ports nat.PortMap) *string {
-	for k := range ports {
-		return &ports[k][0].HostPort
+		m.logln("Sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
 	}
-	return nil
 }
 
 func (m *Manager) doHealthChecks() {
+	now := time.Now().UTC()
 	for _, t := range m.GetTasks() {
-
-		if t.RestartCount > 3 {
+		// 退避中のタスクは停止を待ってから別のノードで動かす
+		if m.evicting[t.ID] {
 			continue
 		}
-
 		switch t.State {
 		case task.Running:
-			if err := m.checkTaskHealth(*t); err != nil {
-				m.restartTask(t)
+			// ヘルスチェック自体はワーカーが行い、結果をHealthで報告してくる
+			if t.Health == task.HealthUnhealthy {
+				m.logln("Task %s is unhealthy: %s", t.ID, t.HealthMessage)
+				m.retryTask(t, now)
 			}
 		case task.Failed:
-			m.restartTask(t)
+			m.retryTask(t, now)
 		}
 	}
 }
 
+// retryTask はリスタートポリシーと再起動回数の上限、バックオフに従ってタスクを再起動する
+func (m *Manager) retryTask(t *task.Task, now time.Time) {
+	if t.Restart() == task.RestartNever {
+		return
+	}
+
+	if t.RestartCount >= t.RetryLimit() {
+		m.logln("Task %s has been restarted %d times, giving up", t.ID, t.RestartCount)
+		return
+	}
+
+	if now.Before(t.NextRetry) {
+		m.logln("Task %s will be restarted after %s", t.ID, t.NextRetry.Format(time.RFC3339))
+		return
+	}
+
+	m.restartTask(t)
+}
+
 func (m *Manager) restartTask(t *task.Task) {
 
 	w := m.TaskWorkerMap[t.ID]
 	t.State = task.Scheduled
+	t.Ready = false
+	t.Health = ""
 	t.RestartCount++
+	t.NextRetry = time.Now().UTC().Add(task.RestartBackoff(t.RestartCount))
 	m.TaskDb.Put(t.ID.String(), t)
 
 	te := task.TaskEvent{
@@ -354,6 +620,9 @@ func (m *Manager) restartTask(t *task.Task) {
 		Timestamp: time.Now(),
 		Task:      *t,
 	}
+	if err := m.EventDb.Put(te.ID.String(), &te); err != nil {
+		m.logln("Error attempting to store task event %s: %s\n", te.ID.String(), err)
+	}
 	data, err := json.Marshal(te)
 	if err != nil {
 		m.logln("Unable to marshal task object: %v.", t)
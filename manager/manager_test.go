@@ -0,0 +1,658 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"cube/task"
+	"cube/util"
+	"cube/worker"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+func newTestCluster(t *testing.T) (*Manager, *worker.Worker) {
+	t.Helper()
+
+	w, err := worker.NewWithRuntime("test-worker", "memory", task.NewFake())
+	if err != nil {
+		t.Fatalf("error creating worker: %v", err)
+	}
+	w.Ports.HostPortFree = nil
+
+	api := worker.Api{Worker: w}
+	srv := httptest.NewServer(api.Handler())
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	m, err := New([]string{u.Host}, "roundrobin", "memory")
+	if err != nil {
+		t.Fatalf("error creating manager: %v", err)
+	}
+
+	return m, w
+}
+
+func newTaskEvent(name string) task.TaskEvent {
+	return task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now(),
+		Task: task.Task{
+			ID:           uuid.New(),
+			Name:         name,
+			State:        task.Pending,
+			Image:        "example/echo:latest",
+			ExposedPorts: nat.PortSet{"7777/tcp": struct{}{}},
+		},
+	}
+}
+
+func putRunningTask(t *testing.T, m *Manager, health string) *task.Task {
+	t.Helper()
+
+	tk := &task.Task{
+		ID:          uuid.New(),
+		Name:        "health",
+		State:       task.Running,
+		HealthCheck: &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/health"}},
+		Health:      health,
+	}
+	m.TaskDb.Put(tk.ID.String(), tk)
+	m.TaskWorkerMap[tk.ID] = m.Workers[0]
+
+	return tk
+}
+
+func TestSendWorkSchedulesTaskOnWorker(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	te := newTaskEvent("send")
+	m.AddTask(te)
+	m.SendWork()
+
+	if m.Penging.Len() != 0 {
+		t.Errorf("expected pending queue to be empty, got %d", m.Penging.Len())
+	}
+
+	persisted, err := m.TaskDb.Get(te.Task.ID.String())
+	if err != nil {
+		t.Fatalf("task not stored: %v", err)
+	}
+	if persisted.State != task.Scheduled {
+		t.Errorf("expected state %v, got %v", task.Scheduled, persisted.State)
+	}
+	if got := m.TaskWorkerMap[te.Task.ID]; got != m.Workers[0] {
+		t.Errorf("expected task to be mapped to %s, got %s", m.Workers[0], got)
+	}
+	if _, err := m.EventDb.Get(te.ID.String()); err != nil {
+		t.Errorf("event not stored: %v", err)
+	}
+	if w.Queue.Len() != 1 {
+		t.Errorf("expected worker to receive 1 task, got %d", w.Queue.Len())
+	}
+}
+
+func TestSendWorkStopsScheduledTask(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	te := newTaskEvent("stop")
+	m.AddTask(te)
+	m.SendWork()
+
+	// ワーカー側でタスクが登録されていないとDELETEは404になる
+	w.Db.Put(te.Task.ID.String(), &te.Task)
+
+	persisted, _ := m.TaskDb.Get(te.Task.ID.String())
+	persisted.State = task.Running
+
+	stop := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Completed,
+		Timestamp: time.Now(),
+		Task:      *persisted,
+	}
+	m.AddTask(stop)
+	m.SendWork()
+
+	if w.Queue.Len() != 2 {
+		t.Fatalf("expected worker to receive a stop request, queue has %d", w.Queue.Len())
+	}
+}
+
+func TestSendWorkRequeuesTaskWhenHostPortIsTaken(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	first := newTaskEvent("first")
+	first.Task.PortBindings = map[string]string{"7777/tcp": "7777"}
+	m.AddTask(first)
+	m.SendWork()
+
+	second := newTaskEvent("second")
+	second.Task.PortBindings = map[string]string{"7777/tcp": "7777"}
+	m.AddTask(second)
+	m.SendWork()
+
+	if w.Queue.Len() != 1 {
+		t.Errorf("expected only the first task to reach the worker, got %d", w.Queue.Len())
+	}
+	if m.Penging.Len() != 1 {
+		t.Errorf("expected second task to be requeued, pending has %d", m.Penging.Len())
+	}
+
+	persisted, _ := m.TaskDb.Get(second.Task.ID.String())
+	if persisted.State != task.Pending {
+		t.Errorf("expected state %v, got %v", task.Pending, persisted.State)
+	}
+	if _, ok := m.TaskWorkerMap[second.Task.ID]; ok {
+		t.Errorf("expected second task not to be assigned to a worker")
+	}
+}
+
+func TestSendWorkHandlesWorkerPortConflict(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	// cubeの管理外のプロセスがポートを使っている
+	w.Ports.HostPortFree = func(port string) bool { return port != "7777/tcp" }
+
+	te := newTaskEvent("conflict")
+	te.Task.PortBindings = map[string]string{"7777/tcp": "7777"}
+	m.AddTask(te)
+	m.SendWork()
+
+	if w.Queue.Len() != 0 {
+		t.Errorf("expected worker to reject the task, got %d", w.Queue.Len())
+	}
+	if m.Penging.Len() != 1 {
+		t.Errorf("expected task to be requeued, pending has %d", m.Penging.Len())
+	}
+
+	m.refreshNodes()
+	if _, ok := m.WorkerNodes[0].PortsAllocated["7777/tcp"]; !ok {
+		t.Errorf("expected conflicting port to be recorded on the node")
+	}
+}
+
+func TestSendWorkWithEmptyQueue(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	m.SendWork()
+
+	if w.Queue.Len() != 0 {
+		t.Errorf("expected no work to be sent, got %d", w.Queue.Len())
+	}
+}
+
+func TestDoHealthChecksRestartsFailedTask(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	tk := putRunningTask(t, m, "")
+	tk.State = task.Failed
+
+	m.doHealthChecks()
+
+	persisted, _ := m.TaskDb.Get(tk.ID.String())
+	if persisted.State != task.Scheduled {
+		t.Errorf("expected state %v, got %v", task.Scheduled, persisted.State)
+	}
+	if persisted.RestartCount != 1 {
+		t.Errorf("expected restart count 1, got %d", persisted.RestartCount)
+	}
+	if w.Queue.Len() != 1 {
+		t.Errorf("expected task to be sent to worker, queue has %d", w.Queue.Len())
+	}
+}
+
+func TestDoHealthChecksRestartsUnhealthyTask(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	tk := putRunningTask(t, m, task.HealthUnhealthy)
+
+	m.doHealthChecks()
+
+	persisted, _ := m.TaskDb.Get(tk.ID.String())
+	if persisted.RestartCount != 1 {
+		t.Errorf("expected restart count 1, got %d", persisted.RestartCount)
+	}
+	if w.Queue.Len() != 1 {
+		t.Errorf("expected task to be sent to worker, queue has %d", w.Queue.Len())
+	}
+}
+
+func TestDoHealthChecksLeavesHealthyTask(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	for _, health := range []string{task.HealthHealthy, task.HealthStarting, ""} {
+		tk := putRunningTask(t, m, health)
+
+		m.doHealthChecks()
+
+		persisted, _ := m.TaskDb.Get(tk.ID.String())
+		if persisted.State != task.Running || persisted.RestartCount != 0 {
+			t.Errorf("expected %q task to be left alone, got state %v restarts %d", health, persisted.State, persisted.RestartCount)
+		}
+	}
+	if w.Queue.Len() != 0 {
+		t.Errorf("expected no work to be sent, got %d", w.Queue.Len())
+	}
+}
+
+func TestUpdateTasksCopiesHealthFromWorker(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	tk := putRunningTask(t, m, "")
+	reported := *tk
+	reported.Health = task.HealthUnhealthy
+	reported.HealthMessage = "connection refused"
+	reported.Ready = false
+	w.Db.Put(reported.ID.String(), &reported)
+	tk.Ready = true
+
+	m.updateTasks()
+
+	persisted, _ := m.TaskDb.Get(tk.ID.String())
+	if persisted.Health != task.HealthUnhealthy || persisted.HealthMessage != "connection refused" {
+		t.Errorf("expected health reported by the worker, got %q %q", persisted.Health, persisted.HealthMessage)
+	}
+	if persisted.Ready {
+		t.Errorf("expected task not to be ready")
+	}
+	if len(persisted.HealthHistory) != 1 || persisted.HealthHistory[0].Health != task.HealthUnhealthy {
+		t.Errorf("expected the change to be recorded, got %+v", persisted.HealthHistory)
+	}
+
+	m.updateTasks()
+	if len(persisted.HealthHistory) != 1 {
+		t.Errorf("expected an unchanged report not to be recorded, got %d records", len(persisted.HealthHistory))
+	}
+}
+
+func TestUpdateTasksResendsStopThatWasLost(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	te := newTaskEvent("lost-stop")
+	m.AddTask(te)
+	m.SendWork()
+	drainWorker(w)
+	m.updateTasks()
+
+	tk, _ := m.TaskDb.Get(te.Task.ID.String())
+	tk.State = task.Completed
+	m.StopTask(tk)
+	m.SendWork()
+	// ワーカーが停止を処理しないまま失う
+	w.Queue.Dequeue()
+
+	m.updateTasks()
+	if tk.State != task.Completed || m.Penging.Len() != 0 {
+		t.Fatalf("expected the task to stay completed while the stop is pending, got %v with %d events", tk.State, m.Penging.Len())
+	}
+
+	m.stopRequests[tk.ID] = time.Now().Add(-2 * stopRetryPeriod)
+	m.updateTasks()
+	if m.Penging.Len() != 1 {
+		t.Fatalf("expected the stop to be queued again, got %d events", m.Penging.Len())
+	}
+
+	m.SendWork()
+	drainWorker(w)
+	m.updateTasks()
+	if tk.State != task.Completed {
+		t.Errorf("expected the task to be completed, got %v", tk.State)
+	}
+	if _, ok := m.stopRequests[tk.ID]; ok {
+		t.Errorf("expected the stop request to be cleared")
+	}
+}
+
+func TestUpdateTasksAdoptsWorkerStateWithoutStop(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	te := newTaskEvent("no-stop")
+	m.AddTask(te)
+	m.SendWork()
+	drainWorker(w)
+
+	tk, _ := m.TaskDb.Get(te.Task.ID.String())
+	tk.State = task.Completed
+	m.updateTasks()
+
+	if tk.State != task.Running {
+		t.Errorf("expected the state reported by the worker, got %v", tk.State)
+	}
+}
+
+func TestDoHealthChecksGivesUpAfterRestartLimit(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	tk := putRunningTask(t, m, "")
+	tk.State = task.Failed
+	tk.RestartCount = 4
+
+	m.doHealthChecks()
+
+	if w.Queue.Len() != 0 {
+		t.Errorf("expected no restart, got %d queued", w.Queue.Len())
+	}
+}
+
+func TestDoHealthChecksWaitsForBackoff(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	tk := putRunningTask(t, m, "")
+	tk.State = task.Failed
+
+	m.doHealthChecks()
+
+	persisted, _ := m.TaskDb.Get(tk.ID.String())
+	if persisted.NextRetry.Before(time.Now()) {
+		t.Fatalf("expected next retry to be in the future, got %v", persisted.NextRetry)
+	}
+
+	persisted.State = task.Failed
+	m.doHealthChecks()
+
+	if w.Queue.Len() != 1 {
+		t.Errorf("expected only one restart before the backoff expires, queue has %d", w.Queue.Len())
+	}
+
+	persisted.NextRetry = time.Now().Add(-time.Second)
+	m.doHealthChecks()
+
+	if persisted, _ := m.TaskDb.Get(tk.ID.String()); persisted.RestartCount != 2 {
+		t.Errorf("expected restart count 2, got %d", persisted.RestartCount)
+	}
+}
+
+func TestDoHealthChecksHonorsNeverPolicy(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	tk := putRunningTask(t, m, "")
+	tk.RestartPolicy = task.RestartNever
+	tk.State = task.Failed
+
+	m.doHealthChecks()
+
+	if w.Queue.Len() != 0 {
+		t.Errorf("expected no restart, got %d queued", w.Queue.Len())
+	}
+}
+
+func TestDoHealthChecksHonorsMaxRetries(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	tk := putRunningTask(t, m, "")
+	tk.MaxRetries = 5
+	tk.RestartCount = 4
+	tk.State = task.Failed
+
+	m.doHealthChecks()
+
+	if w.Queue.Len() != 1 {
+		t.Errorf("expected task to be restarted, queue has %d", w.Queue.Len())
+	}
+}
+
+func TestDoHealthChecksRetriesJobUpToBackoffLimit(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	tk := putRunningTask(t, m, "")
+	tk.Mode = task.ModeJob
+	tk.BackoffLimit = 1
+	tk.State = task.Failed
+
+	m.doHealthChecks()
+
+	persisted, _ := m.TaskDb.Get(tk.ID.String())
+	if persisted.State != task.Scheduled {
+		t.Errorf("expected state %v, got %v", task.Scheduled, persisted.State)
+	}
+
+	persisted.State = task.Failed
+	persisted.NextRetry = time.Time{}
+	m.doHealthChecks()
+
+	persisted, _ = m.TaskDb.Get(tk.ID.String())
+	if persisted.State != task.Failed {
+		t.Errorf("expected state %v after backoff limit, got %v", task.Failed, persisted.State)
+	}
+	if w.Queue.Len() != 1 {
+		t.Errorf("expected job to be retried once, queue has %d", w.Queue.Len())
+	}
+}
+
+func TestDoHealthChecksLeavesCompletedJob(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	tk := putRunningTask(t, m, "")
+	tk.Mode = task.ModeJob
+	tk.BackoffLimit = 3
+	tk.State = task.Completed
+
+	m.doHealthChecks()
+
+	if persisted, _ := m.TaskDb.Get(tk.ID.String()); persisted.State != task.Completed {
+		t.Errorf("expected state %v, got %v", task.Completed, persisted.State)
+	}
+	if w.Queue.Len() != 0 {
+		t.Errorf("expected no work to be sent, queue has %d", w.Queue.Len())
+	}
+}
+
+func TestApiVersions(t *testing.T) {
+	m, _ := newTestCluster(t)
+	tk := &task.Task{ID: uuid.New(), Name: "versions", State: task.Running, Labels: map[string]string{"State": "Running"}}
+	m.TaskDb.Put(tk.ID.String(), tk)
+
+	api := Api{Manager: m}
+	api.initRouter()
+	srv := httptest.NewServer(api.Router)
+	t.Cleanup(srv.Close)
+
+	getTask := func(path string) map[string]any {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var tasks []map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&tasks); err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks) != 1 {
+			t.Fatalf("expected 1 task, got %d", len(tasks))
+		}
+		return tasks[0]
+	}
+
+	if got := getTask("/v1/tasks")["State"]; got != "Running" {
+		t.Errorf("expected /v1 to return the state name, got %v", got)
+	}
+	legacy := getTask("/tasks")
+	if got := legacy["State"]; got != float64(task.Running) {
+		t.Errorf("expected the unversioned API to return the state number, got %v", got)
+	}
+	// タスクのState以外は変えない
+	if labels, _ := legacy["Labels"].(map[string]any); labels["State"] != "Running" {
+		t.Errorf("expected the labels to be returned as is, got %v", legacy["Labels"])
+	}
+
+	for _, state := range []string{`1`, `"Scheduled"`} {
+		body := fmt.Sprintf(`{"ID": %q, "State": 2, "Task": {"ID": %q, "Name": "new", "Image": "a", "State": %s}}`, uuid.New(), uuid.New(), state)
+		resp, err := http.Post(srv.URL+"/v1/tasks", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("expected a task with state %s to be accepted, got %d", state, resp.StatusCode)
+		}
+	}
+}
+
+func TestDescribeTask(t *testing.T) {
+	m, _ := newTestCluster(t)
+
+	te := newTaskEvent("describe")
+	m.AddTask(te)
+	m.SendWork()
+
+	stop := te
+	stop.ID = uuid.New()
+	stop.State = task.Completed
+	stop.Timestamp = te.Timestamp.Add(time.Second)
+	m.AddTask(stop)
+	m.SendWork()
+
+	other := newTaskEvent("other")
+	m.AddTask(other)
+	m.SendWork()
+
+	api := Api{Manager: m}
+	api.initRouter()
+	srv := httptest.NewServer(api.Router)
+	t.Cleanup(srv.Close)
+
+	for _, key := range []string{te.Task.ID.String(), "describe"} {
+		resp, err := http.Get(fmt.Sprintf("%s/v1/tasks/%s", srv.URL, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", key, resp.StatusCode)
+		}
+
+		var d TaskDescription
+		if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		if d.Task.ID != te.Task.ID || d.Worker != m.Workers[0] {
+			t.Errorf("%s: unexpected task %s on %q", key, d.Task.ID, d.Worker)
+		}
+		if len(d.Events) != 2 || d.Events[0].ID != te.ID || d.Events[1].ID != stop.ID {
+			t.Errorf("%s: expected the start and stop events in order, got %v", key, d.Events)
+		}
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/v1/tasks/%s", srv.URL, uuid.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown task, got %d", resp.StatusCode)
+	}
+}
+
+func TestGetTaskLogsProxiesToWorker(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	f := w.Runtime.(*task.Fake)
+	f.Script("logs", task.FakeBehavior{Logs: "hello from the task\n"})
+	result := f.Run(task.Config{Name: "logs"})
+
+	tk := &task.Task{ID: uuid.New(), Name: "logs", State: task.Running, ContainerID: result.ContainerId}
+	w.Db.Put(tk.ID.String(), tk)
+	m.TaskWorkerMap[tk.ID] = m.Workers[0]
+
+	api := Api{Manager: m}
+	api.initRouter()
+	srv := httptest.NewServer(api.Router)
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(fmt.Sprintf("%s/tasks/%s/logs?tail=10", srv.URL, tk.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	if string(body) != "hello from the task\n" {
+		t.Errorf("unexpected logs %q", body)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/tasks/%s/logs", srv.URL, uuid.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown task, got %d", resp.StatusCode)
+	}
+}
+
+func TestExecTaskProxiesToWorker(t *testing.T) {
+	m, w := newTestCluster(t)
+
+	f := w.Runtime.(*task.Fake)
+	f.Script("exec", task.FakeBehavior{ExecOutput: "hello\n", ExecExitCode: 3})
+	result := f.Run(task.Config{Name: "exec"})
+
+	tk := &task.Task{ID: uuid.New(), Name: "exec", State: task.Running, ContainerID: result.ContainerId}
+	w.Db.Put(tk.ID.String(), tk)
+	m.TaskWorkerMap[tk.ID] = m.Workers[0]
+
+	api := Api{Manager: m}
+	api.initRouter()
+	srv := httptest.NewServer(api.Router)
+	t.Cleanup(srv.Close)
+
+	body := strings.NewReader(`{"Cmd": ["cat"], "Stdin": true}`)
+	resp, conn, err := util.PostUpgrade(context.Background(), fmt.Sprintf("%s/tasks/%s/exec", srv.URL, tk.ID), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn == nil {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected upgraded connection, got %d: %s", resp.StatusCode, b)
+	}
+	defer conn.Close()
+
+	task.WriteFrame(conn, task.FrameStdin, []byte("ping\n"))
+	task.WriteFrame(conn, task.FrameCloseStdin, nil)
+
+	var out bytes.Buffer
+	for {
+		typ, payload, err := task.ReadFrame(conn)
+		if err != nil {
+			t.Fatalf("error reading frame: %v", err)
+		}
+
+		if typ == task.FrameStdout {
+			out.Write(payload)
+			continue
+		}
+
+		if typ == task.FrameExit {
+			code, _ := task.ParseExitPayload(payload)
+			if code != 3 {
+				t.Errorf("expected exit code 3, got %d", code)
+			}
+			break
+		}
+	}
+
+	if out.String() != "hello\nping\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	fc, _ := f.Container(result.ContainerId)
+	if len(fc.Execs) != 1 || fc.Execs[0][0] != "cat" {
+		t.Errorf("expected cat to be executed, got %v", fc.Execs)
+	}
+}
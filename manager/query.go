@@ -0,0 +1,165 @@
+package manager
+
+import (
+	"cube/labels"
+	"cube/task"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SortStartTime  = "startTime"
+	SortFinishTime = "finishTime"
+)
+
+// TaskQuery はタスク一覧の絞り込み、並べ替え、ページ分割の条件
+type TaskQuery struct {
+	States     []task.State
+	Worker     string
+	Image      string
+	NamePrefix string
+	Selector   labels.Selector
+	// SortStartTimeかSortFinishTime。先頭に"-"を付けると新しい順になる。空の場合はIDの順
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+// ParseTaskQuery はGET /tasksのクエリパラメータを読む
+func ParseTaskQuery(v url.Values) (TaskQuery, error) {
+	q := TaskQuery{
+		Worker:     v.Get("worker"),
+		Image:      v.Get("image"),
+		NamePrefix: v.Get("name"),
+		Sort:       v.Get("sort"),
+		Cursor:     v.Get("cursor"),
+	}
+
+	selector, err := labels.Parse(v.Get("selector"))
+	if err != nil {
+		return q, err
+	}
+	q.Selector = selector
+
+	for _, states := range v["state"] {
+		for _, name := range strings.Split(states, ",") {
+			var s task.State
+			if err := s.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+				return q, err
+			}
+			q.States = append(q.States, s)
+		}
+	}
+
+	switch strings.TrimPrefix(q.Sort, "-") {
+	case "", SortStartTime, SortFinishTime:
+	default:
+		return q, fmt.Errorf("unknown sort %q, must be %s or %s", q.Sort, SortStartTime, SortFinishTime)
+	}
+
+	if l := v.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid limit %q", l)
+		}
+		q.Limit = n
+	}
+
+	return q, nil
+}
+
+func (q *TaskQuery) match(t *task.Task, worker string) bool {
+	if len(q.States) > 0 && !task.Contains(q.States, t.State) {
+		return false
+	}
+	if q.Worker != "" && q.Worker != worker {
+		return false
+	}
+	if q.Image != "" && q.Image != t.Image && q.Image != imageRepository(t.Image) {
+		return false
+	}
+
+	return strings.HasPrefix(t.Name, q.NamePrefix) && q.Selector.Matches(t.Labels)
+}
+
+// imageRepository はタグを除いたイメージ名を返す
+func imageRepository(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
+
+// QueryTasks はqに合うタスクを返す。nextは次のページのカーソルで、続きがない場合は空になる。
+// 並べ替えない場合はストアのカーソルでIDの順に読む
+func (m *Manager) QueryTasks(q TaskQuery) (tasks []*task.Task, next string, err error) {
+	filter := func(t *task.Task) bool {
+		return q.match(t, m.TaskWorkerMap[t.ID])
+	}
+
+	if q.Sort == "" {
+		return m.TaskDb.ListPage(q.Cursor, q.Limit, filter)
+	}
+
+	all, _, err := m.TaskDb.ListPage("", 0, filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	desc := strings.HasPrefix(q.Sort, "-")
+	sortTime := func(t *task.Task) time.Time {
+		if strings.TrimPrefix(q.Sort, "-") == SortFinishTime {
+			return t.FinishTime
+		}
+		return t.StartTime
+	}
+	// カーソルは"<時刻>/<ID>"の形式で、最後に返したタスクを表す
+	key := func(t *task.Task) string {
+		return fmt.Sprintf("%s/%s", sortTime(t).UTC().Format(time.RFC3339Nano), t.ID)
+	}
+	less := func(a, b *task.Task) bool {
+		ta, tb := sortTime(a), sortTime(b)
+		if !ta.Equal(tb) {
+			return ta.Before(tb) != desc
+		}
+		if desc {
+			return a.ID.String() > b.ID.String()
+		}
+		return a.ID.String() < b.ID.String()
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return less(all[i], all[j])
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		ts, id, ok := strings.Cut(q.Cursor, "/")
+		at, err := time.Parse(time.RFC3339Nano, ts)
+		if !ok || err != nil {
+			return nil, "", fmt.Errorf("invalid cursor %q", q.Cursor)
+		}
+		// カーソルのタスクが消えていても、その位置から続きを返す
+		start = sort.Search(len(all), func(i int) bool {
+			t := all[i]
+			if !sortTime(t).Equal(at) {
+				return sortTime(t).Before(at) == desc
+			}
+			if desc {
+				return t.ID.String() < id
+			}
+			return t.ID.String() > id
+		})
+	}
+
+	all = all[start:]
+	if q.Limit > 0 && len(all) > q.Limit {
+		all = all[:q.Limit]
+		next = key(all[len(all)-1])
+	}
+
+	return all, next, nil
+}
@@ -0,0 +1,168 @@
+package manager
+
+import (
+	"cube/task"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func putQueryTasks(m *Manager) []*task.Task {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	specs := []struct {
+		name  string
+		image string
+		state task.State
+	}{
+		{"web-1", "nginx:1.27", task.Running},
+		{"web-2", "nginx:1.28", task.Running},
+		{"api-1", "api:latest", task.Running},
+		{"job-1", "job:latest", task.Completed},
+		{"job-2", "job:latest", task.Failed},
+	}
+
+	var tasks []*task.Task
+	for i, s := range specs {
+		t := &task.Task{ID: uuid.New(), Name: s.name, Image: s.image, State: s.state, StartTime: base.Add(time.Duration(i) * time.Minute)}
+		m.TaskDb.Put(t.ID.String(), t)
+		tasks = append(tasks, t)
+	}
+	m.TaskWorkerMap[tasks[0].ID] = "w1"
+	m.TaskWorkerMap[tasks[2].ID] = "w1"
+	m.TaskWorkerMap[tasks[1].ID] = "w2"
+
+	return tasks
+}
+
+func names(tasks []*task.Task) string {
+	var s []string
+	for _, t := range tasks {
+		s = append(s, t.Name)
+	}
+	return fmt.Sprint(s)
+}
+
+func TestQueryTasksFilters(t *testing.T) {
+	m, _ := newTestCluster(t)
+	putQueryTasks(m)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"state=Running&worker=w1&sort=startTime", "[web-1 api-1]"},
+		{"state=Completed,failed&sort=startTime", "[job-1 job-2]"},
+		{"image=nginx&sort=startTime", "[web-1 web-2]"},
+		{"image=nginx:1.28", "[web-2]"},
+		{"name=job-&sort=-startTime", "[job-2 job-1]"},
+	}
+
+	for _, tt := range tests {
+		v, _ := url.ParseQuery(tt.query)
+		q, err := ParseTaskQuery(v)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		tasks, _, err := m.QueryTasks(q)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if got := names(tasks); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.query, tt.want, got)
+		}
+	}
+
+	for _, query := range []string{"state=Sleeping", "sort=name", "limit=-1"} {
+		v, _ := url.ParseQuery(query)
+		if _, err := ParseTaskQuery(v); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
+
+func TestQueryTasksPages(t *testing.T) {
+	m, _ := newTestCluster(t)
+	putQueryTasks(m)
+
+	for _, sort := range []string{"", "startTime", "-startTime"} {
+		var all []*task.Task
+		q := TaskQuery{Sort: sort, Limit: 2}
+		for pages := 0; ; pages++ {
+			tasks, next, err := m.QueryTasks(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			all = append(all, tasks...)
+			if next == "" {
+				break
+			}
+			if pages > 5 {
+				t.Fatalf("sort %q: pagination did not finish", sort)
+			}
+			q.Cursor = next
+		}
+
+		seen := map[uuid.UUID]bool{}
+		for _, tk := range all {
+			seen[tk.ID] = true
+		}
+		if len(all) != 5 || len(seen) != 5 {
+			t.Errorf("sort %q: expected every task once, got %s", sort, names(all))
+		}
+		if sort == "-startTime" && names(all) != "[job-2 job-1 api-1 web-2 web-1]" {
+			t.Errorf("expected newest first, got %s", names(all))
+		}
+	}
+}
+
+func TestGetTasksQueryApi(t *testing.T) {
+	m, _ := newTestCluster(t)
+	putQueryTasks(m)
+
+	api := Api{Manager: m}
+	api.initRouter()
+	srv := httptest.NewServer(api.Router)
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/v1/tasks?state=Running&sort=startTime&limit=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var tasks []*task.Task
+	json.NewDecoder(resp.Body).Decode(&tasks)
+	if names(tasks) != "[web-1 web-2]" {
+		t.Errorf("expected the first page, got %s", names(tasks))
+	}
+	next := resp.Header.Get("X-Next-Cursor")
+	if next == "" {
+		t.Fatal("expected a cursor for the next page")
+	}
+
+	resp, err = http.Get(srv.URL + "/v1/tasks?state=Running&sort=startTime&limit=2&cursor=" + url.QueryEscape(next))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	tasks = nil
+	json.NewDecoder(resp.Body).Decode(&tasks)
+	if names(tasks) != "[api-1]" || resp.Header.Get("X-Next-Cursor") != "" {
+		t.Errorf("expected the last page, got %s", names(tasks))
+	}
+
+	resp, err = http.Get(srv.URL + "/v1/tasks?state=Sleeping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown state, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,61 @@
+package manager
+
+import (
+	"cube/task"
+	"testing"
+)
+
+func TestRefreshNodesTracksAllocations(t *testing.T) {
+	m, _ := newTestCluster(t)
+	n := m.WorkerNodes[0]
+
+	running := putRunningTask(t, m, "")
+	running.Cpu, running.Memory, running.Disk = 1.5, 256<<20, 1<<30
+	m.TaskDb.Put(running.ID.String(), running)
+
+	finished := putRunningTask(t, m, "")
+	finished.State = task.Completed
+	finished.Cpu, finished.Memory = 2, 512<<20
+	m.TaskDb.Put(finished.ID.String(), finished)
+
+	m.refreshNodes()
+
+	if n.TaskCount != 1 || n.CpuAllocated != 1.5 || n.MemoryAllocated != 256*1024 || n.DiskAllocated != 1<<30 {
+		t.Errorf("unexpected allocations: tasks %d cpu %v memory %d disk %d", n.TaskCount, n.CpuAllocated, n.MemoryAllocated, n.DiskAllocated)
+	}
+}
+
+func TestSendWorkRecordsPendingReason(t *testing.T) {
+	m, _ := newTestCluster(t)
+	n := m.WorkerNodes[0]
+	n.Memory = 1024 * 1024
+
+	running := putRunningTask(t, m, "")
+	running.Memory = 768 << 20
+	m.TaskDb.Put(running.ID.String(), running)
+
+	te := newTaskEvent("big")
+	te.Task.Memory = 512 << 20
+	m.AddTask(te)
+	m.SendWork()
+
+	persisted, err := m.TaskDb.Get(te.Task.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if persisted.State != task.Pending {
+		t.Fatalf("expected the task to stay pending, got %v", persisted.State)
+	}
+	if want := "0/1 nodes are available: insufficient memory on 1 node"; persisted.PendingReason != want {
+		t.Errorf("expected reason %q, got %q", want, persisted.PendingReason)
+	}
+
+	running.State = task.Completed
+	m.TaskDb.Put(running.ID.String(), running)
+	m.SendWork()
+
+	persisted, _ = m.TaskDb.Get(te.Task.ID.String())
+	if persisted.State != task.Scheduled || persisted.PendingReason != "" {
+		t.Errorf("expected the task to be scheduled once memory is freed, got %v %q", persisted.State, persisted.PendingReason)
+	}
+}
@@ -0,0 +1,318 @@
+package manager
+
+import (
+	"cube/service"
+	"cube/task"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func (m *Manager) GetServices() []*service.Service {
+
+	services, err := m.ServiceDb.List()
+	if err != nil {
+		m.logln("error getting list of services: %v\n", err)
+		return nil
+	}
+
+	return services
+}
+
+// GetService はIDか名前でサービスを探す
+func (m *Manager) GetService(key string) (*service.Service, error) {
+	if id, err := uuid.Parse(key); err == nil {
+		return m.ServiceDb.Get(id.String())
+	}
+
+	for _, s := range m.GetServices() {
+		if s.Name == key {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("service %s not found", key)
+}
+
+func (m *Manager) ReconcileServices() {
+	for {
+		m.logln("Reconciling services")
+		m.mu.Lock()
+		m.reconcileServices()
+		m.mu.Unlock()
+		m.logln("Sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// reconcileServices は各サービスのタスク数をReplicasに合わせる。
+// 削除されたサービスのタスクは停止する
+func (m *Manager) reconcileServices() {
+	tasks := m.GetTasks()
+
+	byService := make(map[uuid.UUID][]*task.Task)
+	for _, t := range tasks {
+		if t.ServiceID == uuid.Nil {
+			continue
+		}
+		if t.State != task.Running {
+			delete(m.stopping, t.ID)
+		}
+		if m.stopping[t.ID] || !taskActive(t) {
+			continue
+		}
+		byService[t.ServiceID] = append(byService[t.ServiceID], t)
+	}
+
+	for _, s := range m.GetServices() {
+		m.reconcileService(s, byService[s.ID])
+		delete(byService, s.ID)
+	}
+
+	for id, orphans := range byService {
+		m.logln("Service %s no longer exists, stopping %d tasks", id, len(orphans))
+		m.scaleDown(orphans, len(orphans))
+	}
+}
+
+// reconcileService はサービスのタスク数をReplicasに合わせる。
+// 古いリビジョンのタスクが残っている場合はUpdateConfigに従って少しずつ入れ替える
+func (m *Manager) reconcileService(s *service.Service, active []*task.Task) {
+	changed := false
+
+	current, old := splitByRevision(s, active)
+	if s.Status.UpdateState == service.UpdateStateUpdating {
+		if failed := firstFailedTask(s.Revision, current); failed != nil {
+			m.failUpdate(s, failed)
+			changed = true
+			current, old = splitByRevision(s, active)
+		}
+	}
+
+	if s.Status.UpdateState != service.UpdateStatePaused {
+		current, old = m.rollTasks(s, current, old)
+	}
+
+	status := s.Status
+	status.Replicas, status.ReadyReplicas, status.UpdatedReplicas = 0, 0, 0
+	for _, t := range append(current, old...) {
+		if t.State != task.Running {
+			continue
+		}
+		status.Replicas++
+		if taskAvailable(t) {
+			status.ReadyReplicas++
+		}
+		if s.Matches(t.ServiceRevision) {
+			status.UpdatedReplicas++
+		}
+	}
+
+	if status.UpdateState == service.UpdateStateUpdating && len(old) == 0 && status.UpdatedReplicas == s.Replicas && status.ReadyReplicas == s.Replicas {
+		m.logln("Service %s has been updated to revision %d", s.Name, s.Revision)
+		status.UpdateState = service.UpdateStateCompleted
+		status.Message = ""
+	}
+
+	if changed || status != s.Status {
+		s.Status = status
+		m.ServiceDb.Put(s.ID.String(), s)
+	}
+}
+
+// rollTasks は現在のリビジョンのタスクを起動し、古いリビジョンのタスクを停止する。
+// 更新中はReplicas+MaxSurgeを超えて起動せず、準備ができたタスクがReplicas-MaxUnavailableを下回るようには停止しない
+func (m *Manager) rollTasks(s *service.Service, current, old []*task.Task) ([]*task.Task, []*task.Task) {
+	cfg := s.UpdateConfig
+
+	if missing := s.Replicas - len(current); missing > 0 {
+		n := missing
+		if len(old) > 0 {
+			n = min(missing, s.Replicas+cfg.Surge()-len(current)-len(old))
+		}
+		if n > 0 {
+			m.logln("Service %s has %d of %d replicas of revision %d, starting %d", s.Name, len(current), s.Replicas, s.Revision, n)
+		}
+		for range n {
+			m.startServiceTask(s)
+		}
+	}
+
+	if excess := len(current) - s.Replicas; excess > 0 {
+		m.logln("Service %s has %d of %d replicas, stopping %d", s.Name, len(current), s.Replicas, excess)
+		current = m.scaleDown(current, excess)
+	}
+
+	if len(old) == 0 {
+		return current, old
+	}
+
+	available := 0
+	for _, t := range append(current, old...) {
+		if taskAvailable(t) {
+			available++
+		}
+	}
+	canStop := available - (s.Replicas - cfg.Unavailable())
+
+	sort.SliceStable(old, func(i, j int) bool {
+		return stopPriority(old[i]) < stopPriority(old[j])
+	})
+
+	var remaining []*task.Task
+	for _, t := range old {
+		switch {
+		case t.State == task.Scheduled:
+			remaining = append(remaining, t)
+		case !taskAvailable(t):
+			// 準備ができていないタスクは止めても利用できるタスクは減らない
+			m.stopServiceTask(t)
+		case canStop > 0:
+			m.stopServiceTask(t)
+			canStop--
+		default:
+			remaining = append(remaining, t)
+		}
+	}
+
+	return current, remaining
+}
+
+// failUpdate は新しいリビジョンのタスクが失敗した時に、FailureActionに従ってロールバックするか更新を止める
+func (m *Manager) failUpdate(s *service.Service, failed *task.Task) {
+	reason := fmt.Sprintf("task %s of revision %d failed", failed.ID, s.Revision)
+	if failed.HealthMessage != "" {
+		reason = fmt.Sprintf("%s: %s", reason, failed.HealthMessage)
+	}
+
+	if s.UpdateConfig.OnFailure() == service.FailureActionRollback {
+		if err := s.Rollback(0); err == nil {
+			m.logln("Rolling back service %s to revision %d: %s", s.Name, s.Revision, reason)
+			s.Status.UpdateState = service.UpdateStateRolledBack
+			s.Status.Message = reason
+			return
+		}
+	}
+
+	m.logln("Pausing update of service %s: %s", s.Name, reason)
+	s.Status.UpdateState = service.UpdateStatePaused
+	s.Status.Message = reason
+}
+
+// splitByRevision はtasksを今の仕様のタスクとそれ以外に分ける。
+// 以前のリビジョンでも仕様が同じタスクは今の仕様のタスクとして扱う
+func splitByRevision(s *service.Service, tasks []*task.Task) (current, old []*task.Task) {
+	for _, t := range tasks {
+		if s.Matches(t.ServiceRevision) {
+			current = append(current, t)
+		} else {
+			old = append(old, t)
+		}
+	}
+	return current, old
+}
+
+// firstFailedTask はリビジョンrevisionで起動したタスクのうち、一度でも失敗したタスクを返す。
+// 以前のリビジョンから引き継いだタスクの失敗は更新の失敗とはみなさない
+func firstFailedTask(revision int, tasks []*task.Task) *task.Task {
+	for _, t := range tasks {
+		if t.ServiceRevision != revision {
+			continue
+		}
+		if t.State == task.Failed || t.Health == task.HealthUnhealthy || t.RestartCount > 0 {
+			return t
+		}
+	}
+	return nil
+}
+
+func (m *Manager) startServiceTask(s *service.Service) {
+	t := s.NewTask()
+	m.TaskDb.Put(t.ID.String(), &t)
+
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now(),
+		Task:      t,
+	})
+}
+
+// scaleDown はtasksのうちn個を停止し、残りを返す。
+// スケジュール中のタスクはワーカーで止められないので、次の調整まで残す
+func (m *Manager) scaleDown(tasks []*task.Task, n int) []*task.Task {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		pi, pj := stopPriority(tasks[i]), stopPriority(tasks[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return tasks[i].StartTime.After(tasks[j].StartTime)
+	})
+
+	var remaining []*task.Task
+	for _, t := range tasks {
+		if n == 0 || t.State == task.Scheduled {
+			remaining = append(remaining, t)
+			continue
+		}
+		m.stopServiceTask(t)
+		n--
+	}
+
+	return remaining
+}
+
+// stopPriority が小さいタスクから停止する
+func stopPriority(t *task.Task) int {
+	switch {
+	case t.State == task.Pending:
+		return 0
+	case t.State == task.Failed:
+		return 1
+	case !t.Ready:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func (m *Manager) stopServiceTask(t *task.Task) {
+	if t.State == task.Running {
+		m.stopping[t.ID] = true
+	} else {
+		// ワーカーで動いていないタスクは先に完了にする。
+		// ワーカーに割り当て済みの場合はコンテナが残らないように停止も送る
+		t.State = task.Completed
+		t.FinishTime = time.Now().UTC()
+		m.TaskDb.Put(t.ID.String(), t)
+		if _, ok := m.TaskWorkerMap[t.ID]; !ok {
+			return
+		}
+	}
+
+	m.StopTask(t)
+}
+
+// taskAvailable はタスクが利用できるかを返す。
+// ヘルスチェックのあるタスクは、ワーカーでプローブが一度成功するまで利用できるとはみなさない
+func taskAvailable(t *task.Task) bool {
+	if t.State != task.Running || !t.Ready {
+		return false
+	}
+	return t.Health != task.HealthStarting && t.Health != task.HealthUnhealthy
+}
+
+// taskActive はタスクがサービスのレプリカとして数えられるかを返す。
+// 再起動を諦めたタスクは数えず、代わりのタスクを起動する
+func taskActive(t *task.Task) bool {
+	switch t.State {
+	case task.Pending, task.Scheduled, task.Running:
+		return true
+	case task.Failed:
+		return t.Restart() != task.RestartNever && t.RestartCount < t.RetryLimit()
+	}
+
+	return false
+}
@@ -0,0 +1,548 @@
+package manager
+
+import (
+	"bytes"
+	"cube/service"
+	"cube/task"
+	"cube/worker"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func putService(t *testing.T, m *Manager, replicas int) *service.Service {
+	t.Helper()
+
+	s := &service.Service{
+		ID:       uuid.New(),
+		Name:     "web",
+		Image:    "example/echo:latest",
+		Replicas: replicas,
+	}
+	m.ServiceDb.Put(s.ID.String(), s)
+
+	return s
+}
+
+func serviceTasks(m *Manager, s *service.Service, state task.State) []*task.Task {
+	var tasks []*task.Task
+	for _, t := range m.GetTasks() {
+		if t.ServiceID == s.ID && t.State == state {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks
+}
+
+// runServiceTasks はサービスのタスクを全てワーカーで起動した状態にする
+func runServiceTasks(m *Manager, s *service.Service) {
+	for m.Penging.Len() > 0 {
+		m.SendWork()
+	}
+	for _, t := range serviceTasks(m, s, task.Scheduled) {
+		t.State = task.Running
+		t.Ready = true
+		t.StartTime = time.Now().UTC()
+	}
+}
+
+func TestReconcileServicesStartsReplicas(t *testing.T) {
+	m, _ := newTestCluster(t)
+	s := putService(t, m, 3)
+
+	m.reconcileServices()
+
+	pending := serviceTasks(m, s, task.Pending)
+	if len(pending) != 3 {
+		t.Fatalf("expected 3 pending tasks, got %d", len(pending))
+	}
+	if m.Penging.Len() != 3 {
+		t.Errorf("expected 3 tasks to be queued, got %d", m.Penging.Len())
+	}
+	if pending[0].Image != s.Image || pending[0].Name == pending[1].Name {
+		t.Errorf("expected tasks built from the service, got %+v", pending[0])
+	}
+
+	m.reconcileServices()
+
+	if got := len(serviceTasks(m, s, task.Pending)); got != 3 {
+		t.Errorf("expected reconcile to be idempotent, got %d pending tasks", got)
+	}
+}
+
+func TestReconcileServicesScalesDown(t *testing.T) {
+	m, _ := newTestCluster(t)
+	s := putService(t, m, 3)
+
+	m.reconcileServices()
+	runServiceTasks(m, s)
+
+	s.Replicas = 1
+	m.reconcileServices()
+
+	if m.Penging.Len() != 2 {
+		t.Fatalf("expected 2 stop events to be queued, got %d", m.Penging.Len())
+	}
+	for range m.Penging.Len() {
+		te := m.Penging.Dequeue().(task.TaskEvent)
+		if te.State != task.Completed || te.Task.ServiceID != s.ID {
+			t.Errorf("expected a stop event for the service, got %v", te.State)
+		}
+		m.Penging.Enqueue(te)
+	}
+
+	m.reconcileServices()
+
+	if m.Penging.Len() != 2 {
+		t.Errorf("expected tasks being stopped not to be stopped again, got %d events", m.Penging.Len())
+	}
+	if s.Status.Replicas != 1 || s.Status.ReadyReplicas != 1 {
+		t.Errorf("expected 1 running and ready replica, got %+v", s.Status)
+	}
+}
+
+func TestReconcileServicesStopsPendingTasksFirst(t *testing.T) {
+	m, _ := newTestCluster(t)
+	s := putService(t, m, 2)
+
+	m.reconcileServices()
+	runServiceTasks(m, s)
+
+	s.Replicas = 3
+	m.reconcileServices()
+	s.Replicas = 2
+	m.reconcileServices()
+
+	if got := len(serviceTasks(m, s, task.Completed)); got != 1 {
+		t.Fatalf("expected the pending task to be completed, got %d completed", got)
+	}
+	if got := len(serviceTasks(m, s, task.Running)); got != 2 {
+		t.Errorf("expected running tasks to be left alone, got %d running", got)
+	}
+
+	m.SendWork()
+	if got := len(serviceTasks(m, s, task.Scheduled)); got != 0 {
+		t.Errorf("expected the stopped task not to be scheduled, got %d scheduled", got)
+	}
+}
+
+func TestReconcileServicesReplacesTasksThatGaveUp(t *testing.T) {
+	m, _ := newTestCluster(t)
+	s := putService(t, m, 1)
+
+	m.reconcileServices()
+	runServiceTasks(m, s)
+
+	failed := serviceTasks(m, s, task.Running)[0]
+	failed.State = task.Failed
+	failed.RestartCount = task.DefaultMaxRetries
+
+	m.reconcileServices()
+
+	if got := len(serviceTasks(m, s, task.Pending)); got != 1 {
+		t.Errorf("expected a replacement task, got %d pending", got)
+	}
+}
+
+func TestReconcileServicesStopsTasksOfDeletedService(t *testing.T) {
+	m, _ := newTestCluster(t)
+	s := putService(t, m, 2)
+
+	m.reconcileServices()
+	runServiceTasks(m, s)
+
+	m.ServiceDb.Delete(s.ID.String())
+	m.reconcileServices()
+
+	if m.Penging.Len() != 2 {
+		t.Errorf("expected 2 stop events, got %d", m.Penging.Len())
+	}
+}
+
+// finishStops は停止イベントを処理し、ワーカーでタスクが停止したことにする
+func finishStops(m *Manager) {
+	for range m.Penging.Len() {
+		te := m.Penging.Dequeue().(task.TaskEvent)
+		if te.State != task.Completed {
+			m.Penging.Enqueue(te)
+			continue
+		}
+		if t, err := m.TaskDb.Get(te.Task.ID.String()); err == nil {
+			t.State = task.Completed
+		}
+	}
+}
+
+func newRollingService(t *testing.T, m *Manager, replicas int, cfg service.UpdateConfig) *service.Service {
+	t.Helper()
+
+	s := &service.Service{ID: uuid.New(), Name: "web"}
+	s.Apply(&service.Service{Image: "web:1", Replicas: replicas, UpdateConfig: cfg})
+	m.ServiceDb.Put(s.ID.String(), s)
+
+	m.reconcileServices()
+	runServiceTasks(m, s)
+
+	return s
+}
+
+func TestReconcileServicesRollsUpdate(t *testing.T) {
+	m, _ := newTestCluster(t)
+	s := newRollingService(t, m, 2, service.UpdateConfig{})
+
+	s.Apply(&service.Service{Image: "web:2", Replicas: 2})
+
+	for step := range 10 {
+		m.reconcileServices()
+
+		running := serviceTasks(m, s, task.Running)
+		ready := 0
+		for _, tk := range running {
+			if tk.Ready && !m.stopping[tk.ID] {
+				ready++
+			}
+		}
+		if ready < 2 {
+			t.Fatalf("step %d: expected at least 2 ready tasks, got %d", step, ready)
+		}
+		if total := len(running) + len(serviceTasks(m, s, task.Pending)); total > 3 {
+			t.Fatalf("step %d: expected at most 3 tasks with a surge of 1, got %d", step, total)
+		}
+
+		finishStops(m)
+		runServiceTasks(m, s)
+		if s.Status.UpdateState == service.UpdateStateCompleted {
+			break
+		}
+	}
+
+	if s.Status.UpdateState != service.UpdateStateCompleted {
+		t.Fatalf("expected update to complete, got %q", s.Status.UpdateState)
+	}
+	for _, tk := range serviceTasks(m, s, task.Running) {
+		if tk.Image != "web:2" {
+			t.Errorf("expected only web:2 tasks to be running, got %s", tk.Image)
+		}
+	}
+}
+
+func TestReconcileServicesRollsBackFailedUpdate(t *testing.T) {
+	m, _ := newTestCluster(t)
+	s := newRollingService(t, m, 2, service.UpdateConfig{})
+
+	s.Apply(&service.Service{Image: "web:broken", Replicas: 2})
+	m.reconcileServices()
+	runServiceTasks(m, s)
+
+	for _, tk := range serviceTasks(m, s, task.Running) {
+		if tk.Image == "web:broken" {
+			tk.State = task.Failed
+		}
+	}
+
+	m.reconcileServices()
+
+	if s.Status.UpdateState != service.UpdateStateRolledBack || s.Image != "web:1" {
+		t.Fatalf("expected rollback to web:1, got %q %s", s.Status.UpdateState, s.Image)
+	}
+	if s.Revision != 3 {
+		t.Errorf("expected rollback to be recorded as revision 3, got %d", s.Revision)
+	}
+	if got := len(serviceTasks(m, s, task.Failed)); got != 0 {
+		t.Errorf("expected the failed task to be stopped, got %d failed", got)
+	}
+	if got := len(serviceTasks(m, s, task.Running)); got != 2 {
+		t.Errorf("expected the old tasks to keep running, got %d", got)
+	}
+}
+
+func TestReconcileServicesWaitsForProbeBeforeStoppingOldTasks(t *testing.T) {
+	m, _ := newTestCluster(t)
+	s := newRollingService(t, m, 2, service.UpdateConfig{})
+	var original []*task.Task
+	original = append(original, serviceTasks(m, s, task.Running)...)
+
+	s.Apply(&service.Service{
+		Image:    "web:2",
+		Replicas: 2,
+		Template: task.Task{HealthCheck: &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/health"}}},
+	})
+
+	// Readyでもプローブが成功するまでは利用できるとみなさない
+	for step := range 3 {
+		m.reconcileServices()
+		runServiceTasks(m, s)
+		for _, tk := range serviceTasks(m, s, task.Running) {
+			if tk.Image == "web:2" {
+				tk.Health = task.HealthStarting
+			}
+		}
+		for _, tk := range original {
+			if tk.State != task.Running || m.stopping[tk.ID] {
+				t.Fatalf("step %d: expected task %s to keep running until the probe passes", step, tk.ID)
+			}
+		}
+	}
+	if s.Status.ReadyReplicas != 2 {
+		t.Errorf("expected only the old tasks to be ready, got %d", s.Status.ReadyReplicas)
+	}
+
+	var started *task.Task
+	for _, tk := range serviceTasks(m, s, task.Running) {
+		if tk.Image == "web:2" {
+			started = tk
+			started.Health = task.HealthUnhealthy
+			started.HealthMessage = "connection refused"
+		}
+	}
+	if started == nil {
+		t.Fatal("expected a task of the new revision to be started")
+	}
+
+	m.reconcileServices()
+
+	if s.Status.UpdateState != service.UpdateStateRolledBack || s.Image != "web:1" {
+		t.Fatalf("expected rollback to web:1, got %q %s", s.Status.UpdateState, s.Image)
+	}
+	if !m.stopping[started.ID] {
+		t.Errorf("expected the task of the failed revision to be stopped")
+	}
+	for _, tk := range original {
+		if tk.State != task.Running || m.stopping[tk.ID] {
+			t.Errorf("expected task %s to keep running after the rollback", tk.ID)
+		}
+	}
+}
+
+func TestReconcileServicesAdoptsTasksOfRolledBackSpec(t *testing.T) {
+	m, _ := newTestCluster(t)
+	s := newRollingService(t, m, 2, service.UpdateConfig{})
+	var original []*task.Task
+	original = append(original, serviceTasks(m, s, task.Running)...)
+
+	s.Apply(&service.Service{Image: "web:2", Replicas: 2})
+	m.reconcileServices()
+	runServiceTasks(m, s)
+
+	if err := s.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		m.reconcileServices()
+		finishStops(m)
+		runServiceTasks(m, s)
+	}
+
+	// web:1のタスクはそのまま使い、web:2のタスクだけを止める
+	for _, tk := range original {
+		if tk.State != task.Running || m.stopping[tk.ID] {
+			t.Errorf("expected task %s of the rolled back spec to keep running, got %v", tk.ID, tk.State)
+		}
+	}
+	running := serviceTasks(m, s, task.Running)
+	if len(running) != 2 {
+		t.Errorf("expected only the original tasks to run, got %d running", len(running))
+	}
+	if s.Status.UpdateState != service.UpdateStateCompleted || s.Status.UpdatedReplicas != 2 {
+		t.Errorf("expected the rollback to complete with the adopted tasks, got %+v", s.Status)
+	}
+}
+
+// drainWorker はワーカーのキューにあるタスクを起動または停止する
+func drainWorker(w *worker.Worker) {
+	for w.Queue.Len() > 0 {
+		t := w.Queue.Dequeue().(task.Task)
+		if t.State == task.Completed {
+			w.StopTask(t)
+		} else {
+			w.StartTask(t)
+		}
+	}
+}
+
+func TestReconcileServicesStopsFailedTasksOnWorker(t *testing.T) {
+	m, w := newTestCluster(t)
+	f := w.Runtime.(*task.Fake)
+	s := putService(t, m, 2)
+
+	m.reconcileServices()
+	runServiceTasks(m, s)
+	drainWorker(w)
+
+	failed := serviceTasks(m, s, task.Running)[0]
+	failed.State = task.Failed
+
+	s.Replicas = 1
+	m.reconcileServices()
+	if failed.State != task.Completed {
+		t.Fatalf("expected the failed task to be completed, got %v", failed.State)
+	}
+
+	m.SendWork()
+	// ワーカーが停止を処理する前の状態で完了を上書きしない
+	m.updateTasks()
+	if persisted, _ := m.TaskDb.Get(failed.ID.String()); persisted.State != task.Completed {
+		t.Errorf("expected the task to stay completed, got %v", persisted.State)
+	}
+
+	drainWorker(w)
+	if f.Count() != 1 {
+		t.Errorf("expected the container of the failed task to be removed, got %d containers", f.Count())
+	}
+}
+
+func TestReconcileServicesPausesFailedUpdate(t *testing.T) {
+	m, _ := newTestCluster(t)
+	s := newRollingService(t, m, 2, service.UpdateConfig{FailureAction: service.FailureActionPause})
+
+	s.Apply(&service.Service{Image: "web:broken", Replicas: 2, UpdateConfig: s.UpdateConfig})
+	m.reconcileServices()
+	runServiceTasks(m, s)
+
+	for _, tk := range serviceTasks(m, s, task.Running) {
+		if tk.Image == "web:broken" {
+			tk.Health = task.HealthUnhealthy
+		}
+	}
+
+	m.reconcileServices()
+	m.reconcileServices()
+
+	if s.Status.UpdateState != service.UpdateStatePaused || s.Image != "web:broken" {
+		t.Errorf("expected the update to be paused, got %q %s", s.Status.UpdateState, s.Image)
+	}
+	if m.Penging.Len() != 0 {
+		t.Errorf("expected nothing to be started or stopped while paused, got %d events", m.Penging.Len())
+	}
+}
+
+func TestServiceAPI(t *testing.T) {
+	m, _ := newTestCluster(t)
+
+	api := Api{Manager: m}
+	api.initRouter()
+	srv := httptest.NewServer(api.Router)
+	t.Cleanup(srv.Close)
+
+	do := func(method, path string, body any) *http.Response {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewBuffer(data))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	s := service.Service{Name: "web", Image: "example/echo:latest", Replicas: 2}
+	if resp := do("POST", "/services", s); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	if resp := do("POST", "/services", s); resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for a duplicate name, got %d", resp.StatusCode)
+	}
+	if resp := do("POST", "/services", service.Service{Name: "bad name", Replicas: -1}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid service, got %d", resp.StatusCode)
+	}
+
+	s.Replicas = 5
+	resp := do("PUT", "/services/web", s)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var updated service.Service
+	json.NewDecoder(resp.Body).Decode(&updated)
+	if updated.Replicas != 5 || updated.ID == uuid.Nil {
+		t.Errorf("expected 5 replicas, got %+v", updated)
+	}
+
+	resp = do("GET", fmt.Sprintf("/services/%s", updated.ID), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 when getting by id, got %d", resp.StatusCode)
+	}
+
+	var services []service.Service
+	json.NewDecoder(do("GET", "/services", nil).Body).Decode(&services)
+	if len(services) != 1 || services[0].Name != "web" {
+		t.Errorf("expected one service, got %+v", services)
+	}
+
+	s.Image = "example/echo:v2"
+	do("PUT", "/services/web", s)
+	resp = do("POST", "/services/web/rollback", map[string]int{"Revision": 1})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var rolledBack service.Service
+	json.NewDecoder(resp.Body).Decode(&rolledBack)
+	if rolledBack.Image != "example/echo:latest" || rolledBack.Revision != 3 {
+		t.Errorf("expected revision 3 with the original image, got %s as %d", rolledBack.Image, rolledBack.Revision)
+	}
+	if resp := do("POST", "/services/web/rollback", map[string]int{"Revision": 9}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown revision, got %d", resp.StatusCode)
+	}
+
+	if resp := do("DELETE", "/services/web", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", resp.StatusCode)
+	}
+	if resp := do("GET", "/services/web", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", resp.StatusCode)
+	}
+}
+
+func TestLoopsAndApiShareState(t *testing.T) {
+	m, _ := newTestCluster(t)
+
+	api := Api{Manager: m}
+	api.initRouter()
+
+	// ループとハンドラが同時にマップとストアを読み書きしても壊れないこと。-raceで確かめる
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			m.mu.Lock()
+			m.reconcileServices()
+			m.SendWork()
+			m.doHealthChecks()
+			m.mu.Unlock()
+			m.updateTasks()
+		}
+	}()
+
+	for i := range 20 {
+		s := service.Service{Name: "web", Image: "example/echo:latest", Replicas: i%3 + 1}
+		data, _ := json.Marshal(s)
+		method := "PUT"
+		path := "/services/web"
+		if i == 0 {
+			method, path = "POST", "/services"
+		}
+		taint := `{"Add": [{"Key": "maintenance", "Effect": "NoExecute"}]}`
+		if i%2 == 1 {
+			taint = `{"Remove": [{"Key": "maintenance"}]}`
+		}
+		for _, req := range []*http.Request{
+			httptest.NewRequest(method, path, bytes.NewReader(data)),
+			httptest.NewRequest("PATCH", "/nodes/"+m.Workers[0]+"/taints", strings.NewReader(taint)),
+			httptest.NewRequest("GET", "/tasks", nil),
+			httptest.NewRequest("GET", "/services", nil),
+			httptest.NewRequest("GET", "/nodes", nil),
+		} {
+			rec := httptest.NewRecorder()
+			api.Router.ServeHTTP(rec, req)
+			if rec.Code >= 300 {
+				t.Fatalf("%s %s: unexpected status %d", req.Method, req.URL, rec.Code)
+			}
+		}
+	}
+	<-done
+}
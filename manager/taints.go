@@ -0,0 +1,74 @@
+package manager
+
+import (
+	"cube/node"
+	"cube/taints"
+	"cube/task"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TaintNode はノードのテイントを変更し、NoExecuteのテイントを許容しないタスクを退避させて、退避させたタスクを返す
+func (m *Manager) TaintNode(n *node.Node, add, remove []taints.Taint) ([]*task.Task, error) {
+	updated, err := taints.Update(n.Taints, add, remove)
+	if err != nil {
+		return nil, err
+	}
+	n.Taints = updated
+
+	return m.evictTasks(n), nil
+}
+
+// evictTasks はノードで動いているタスクのうち、NoExecuteのテイントを許容しないものを退避させる。
+// スケジュール中のタスクはワーカーで止められないので、動き始めてから退避させる
+func (m *Manager) evictTasks(n *node.Node) []*task.Task {
+	var evicted []*task.Task
+	for _, t := range m.GetTasks() {
+		if m.TaskWorkerMap[t.ID] != n.Name || m.stopping[t.ID] || m.evicting[t.ID] || t.State != task.Running {
+			continue
+		}
+
+		untolerated := taints.Untolerated(n.Taints, t.Tolerations, taints.NoExecute)
+		if len(untolerated) == 0 {
+			continue
+		}
+
+		m.logln("Evicting task %s from %s, it does not tolerate %s", t.ID, n.Name, untolerated[0])
+		m.evictTask(t)
+		evicted = append(evicted, t)
+	}
+
+	return evicted
+}
+
+// evictTask はタスクを停止する。ワーカーが停止を報告したら、rescheduleTaskで同じIDのまま別のノードで動かす。
+// 停止を待つ間もサービスのレプリカとして数え、代わりのタスクは起動しない
+func (m *Manager) evictTask(t *task.Task) {
+	m.queueStop(t)
+	m.evicting[t.ID] = true
+}
+
+// rescheduleTask は退避させたタスクをワーカーへの割り当てから外し、Scheduledにしてpendingキューに戻す
+func (m *Manager) rescheduleTask(t *task.Task) {
+	delete(m.evicting, t.ID)
+	worker := m.TaskWorkerMap[t.ID]
+	m.forgetAssignment(worker, t.ID)
+	m.evictedFrom[t.ID] = worker
+
+	rescheduled := t.Spec()
+	rescheduled.ID = t.ID
+	rescheduled.ServiceID = t.ServiceID
+	rescheduled.ServiceRevision = t.ServiceRevision
+	rescheduled.State = task.Scheduled
+	*t = rescheduled
+	m.TaskDb.Put(t.ID.String(), t)
+
+	m.logln("Task %s has been stopped on %s, scheduling it on another node", t.ID, worker)
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now(),
+		Task:      rescheduled,
+	})
+}
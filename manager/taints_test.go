@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"cube/taints"
+	"cube/task"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTaintNodeEvictsTasks(t *testing.T) {
+	m, _ := newTestCluster(t)
+	n := m.WorkerNodes[0]
+
+	web := putRunningTask(t, m, "")
+	web.Name = "web"
+	web.ServiceRevision = 2
+	m.TaskDb.Put(web.ID.String(), web)
+
+	db := putRunningTask(t, m, "")
+	db.Name = "db"
+	db.Tolerations = []taints.Toleration{{Key: "maintenance", Operator: taints.OpExists}}
+	m.TaskDb.Put(db.ID.String(), db)
+
+	evicted, err := m.TaintNode(n, []taints.Taint{{Key: "dedicated", Effect: taints.NoSchedule}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 0 {
+		t.Errorf("expected NoSchedule not to evict tasks, got %s", names(evicted))
+	}
+
+	evicted, err = m.TaintNode(n, []taints.Taint{{Key: "maintenance", Effect: taints.NoExecute}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(evicted) != "[web]" {
+		t.Fatalf("expected web to be evicted, got %s", names(evicted))
+	}
+	if !m.evicting[web.ID] || m.stopping[web.ID] {
+		t.Error("expected the evicted task to wait for the stop and still count as a replica")
+	}
+
+	// 代わりのタスクは停止を確認してから起動する
+	if m.Penging.Len() != 1 {
+		t.Fatalf("expected only the stop event in the pending queue, got %d", m.Penging.Len())
+	}
+	if stop := m.Penging.Dequeue().(task.TaskEvent); stop.State != task.Completed || stop.Task.ID != web.ID {
+		t.Errorf("expected a stop event for web, got %+v", stop)
+	}
+
+	if evicted, _ := m.TaintNode(n, []taints.Taint{{Key: "maintenance", Effect: taints.NoExecute}}, nil); len(evicted) != 0 {
+		t.Errorf("expected tasks being stopped not to be evicted again, got %s", names(evicted))
+	}
+}
+
+func TestEvictedTaskIsRescheduledOnceStopped(t *testing.T) {
+	m, w := newTestCluster(t)
+	n := m.WorkerNodes[0]
+
+	te := newTaskEvent("web")
+	m.AddTask(te)
+	m.SendWork()
+	drainWorker(w)
+	m.updateTasks()
+
+	maintenance := []taints.Taint{{Key: "maintenance", Effect: taints.NoExecute}}
+	if evicted, _ := m.TaintNode(n, maintenance, nil); names(evicted) != "[web]" {
+		t.Fatalf("expected web to be evicted, got %s", names(evicted))
+	}
+
+	// 停止を待つ間は動いているまま
+	m.updateTasks()
+	tk, _ := m.TaskDb.Get(te.Task.ID.String())
+	if tk.State != task.Running || m.Penging.Len() != 1 {
+		t.Fatalf("expected the task to keep running until stopped, got %v with %d events", tk.State, m.Penging.Len())
+	}
+
+	m.SendWork()
+	drainWorker(w)
+	m.updateTasks()
+
+	tk, _ = m.TaskDb.Get(te.Task.ID.String())
+	if tk.State != task.Scheduled {
+		t.Errorf("expected the stopped task to be scheduled again, got %v", tk.State)
+	}
+	if _, ok := m.TaskWorkerMap[tk.ID]; ok {
+		t.Errorf("expected the task to be unassigned from %s", n.Name)
+	}
+	if m.Penging.Len() != 1 {
+		t.Fatalf("expected the task to be queued again, got %d events", m.Penging.Len())
+	}
+
+	// 退避させたワーカーが報告する停止したタスクで上書きしない
+	m.updateTasks()
+	if tk.State != task.Scheduled {
+		t.Errorf("expected the report of the old worker to be ignored, got %v", tk.State)
+	}
+
+	m.TaintNode(n, nil, maintenance)
+	m.SendWork()
+	drainWorker(w)
+	m.updateTasks()
+
+	if len(m.GetTasks()) != 1 {
+		t.Errorf("expected the task to keep its ID, got %d tasks", len(m.GetTasks()))
+	}
+	tk, _ = m.TaskDb.Get(te.Task.ID.String())
+	if tk.State != task.Running || m.TaskWorkerMap[tk.ID] != n.Name {
+		t.Errorf("expected the same task to run again, got %v on %q", tk.State, m.TaskWorkerMap[tk.ID])
+	}
+}
+
+func TestTaintNodeApi(t *testing.T) {
+	m, _ := newTestCluster(t)
+
+	api := Api{Manager: m}
+	api.initRouter()
+	srv := httptest.NewServer(api.Router)
+	t.Cleanup(srv.Close)
+
+	patch := func(body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPatch, srv.URL+"/v1/nodes/"+m.Workers[0]+"/taints", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := patch(`{"Add": [{"Key": "dedicated", "Value": "team-a", "Effect": "NoSchedule"}]}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var out TaintNodeResponse
+	json.NewDecoder(resp.Body).Decode(&out)
+	if len(out.Node.Taints) != 1 || out.Node.Taints[0].String() != "dedicated=team-a:NoSchedule" {
+		t.Errorf("unexpected taints %v", out.Node.Taints)
+	}
+
+	if resp := patch(`{"Add": [{"Key": "dedicated", "Effect": "Sometimes"}]}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown effect, got %d", resp.StatusCode)
+	}
+
+	patch(`{"Remove": [{"Key": "dedicated"}]}`)
+	if len(m.WorkerNodes[0].Taints) != 0 {
+		t.Errorf("expected the taint to be removed, got %v", m.WorkerNodes[0].Taints)
+	}
+}
@@ -0,0 +1,381 @@
+package manifest
+
+import (
+	"bytes"
+	"cube/service"
+	"cube/taints"
+	"cube/task"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	KindTask    = "Task"
+	KindService = "Service"
+)
+
+// Manifest はタスクかサービスの宣言的な定義。YAMLかJSONで書く
+type Manifest struct {
+	Kind     string `yaml:"kind"`
+	Name     string `yaml:"name"`
+	Image    string `yaml:"image"`
+	Replicas *int   `yaml:"replicas,omitempty"`
+
+	Command []string          `yaml:"command,omitempty"`
+	Env     map[string]string `yaml:"env,omitempty"`
+	// "80/tcp"のようにコンテナのポートだけを書くと公開のみ、"8080:80/tcp"のように書くとホストのポートに割り当てる
+	Ports     []string   `yaml:"ports,omitempty"`
+	Resources *Resources `yaml:"resources,omitempty"`
+
+	Labels       map[string]string `yaml:"labels,omitempty"`
+	NodeSelector string            `yaml:"nodeSelector,omitempty"`
+	Affinity     *Affinity         `yaml:"affinity,omitempty"`
+	Tolerations  []Toleration      `yaml:"tolerations,omitempty"`
+
+	Mode         string   `yaml:"mode,omitempty"`
+	BackoffLimit int      `yaml:"backoffLimit,omitempty"`
+	Restart      *Restart `yaml:"restart,omitempty"`
+
+	HealthCheck    *HealthCheck `yaml:"healthCheck,omitempty"`
+	ReadinessCheck *HealthCheck `yaml:"readinessCheck,omitempty"`
+	StartupCheck   *HealthCheck `yaml:"startupCheck,omitempty"`
+
+	Volumes      []Volume `yaml:"volumes,omitempty"`
+	VolumePolicy string   `yaml:"volumePolicy,omitempty"`
+
+	Update *Update `yaml:"update,omitempty"`
+}
+
+// Resources のmemoryとdiskは"256m"や"1g"のように単位を付けて書ける
+type Resources struct {
+	Cpu    float64 `yaml:"cpu,omitempty"`
+	Memory string  `yaml:"memory,omitempty"`
+	Disk   string  `yaml:"disk,omitempty"`
+}
+
+type Restart struct {
+	Policy     string `yaml:"policy,omitempty"`
+	MaxRetries int    `yaml:"maxRetries,omitempty"`
+}
+
+type HealthCheck struct {
+	HTTP *struct {
+		Path           string `yaml:"path"`
+		Port           string `yaml:"port,omitempty"`
+		ExpectedStatus int    `yaml:"expectedStatus,omitempty"`
+	} `yaml:"http,omitempty"`
+	TCP *struct {
+		Port string `yaml:"port"`
+	} `yaml:"tcp,omitempty"`
+	Exec *struct {
+		Command []string `yaml:"command"`
+	} `yaml:"exec,omitempty"`
+
+	InitialDelaySeconds int `yaml:"initialDelaySeconds,omitempty"`
+	IntervalSeconds     int `yaml:"intervalSeconds,omitempty"`
+	TimeoutSeconds      int `yaml:"timeoutSeconds,omitempty"`
+	SuccessThreshold    int `yaml:"successThreshold,omitempty"`
+	FailureThreshold    int `yaml:"failureThreshold,omitempty"`
+}
+
+type Affinity struct {
+	Node     []AffinityTerm `yaml:"node,omitempty"`
+	Task     []AffinityTerm `yaml:"task,omitempty"`
+	AntiTask []AffinityTerm `yaml:"antiTask,omitempty"`
+}
+
+type AffinityTerm struct {
+	Selector string `yaml:"selector"`
+	Required bool   `yaml:"required,omitempty"`
+	Weight   int    `yaml:"weight,omitempty"`
+}
+
+type Toleration struct {
+	Key      string `yaml:"key,omitempty"`
+	Operator string `yaml:"operator,omitempty"`
+	Value    string `yaml:"value,omitempty"`
+	Effect   string `yaml:"effect,omitempty"`
+}
+
+type Volume struct {
+	Type     string `yaml:"type,omitempty"`
+	Source   string `yaml:"source,omitempty"`
+	Target   string `yaml:"target"`
+	ReadOnly bool   `yaml:"readOnly,omitempty"`
+	Size     string `yaml:"size,omitempty"`
+}
+
+type Update struct {
+	MaxSurge       int    `yaml:"maxSurge,omitempty"`
+	MaxUnavailable int    `yaml:"maxUnavailable,omitempty"`
+	FailureAction  string `yaml:"failureAction,omitempty"`
+}
+
+// Parse はYAMLかJSONで書かれたマニフェストを読む。
+// YAMLは"---"で区切って複数書くことができ、JSONは配列で複数書くことができる
+func Parse(data []byte) ([]Manifest, error) {
+	var manifests []Manifest
+
+	d := yaml.NewDecoder(bytes.NewReader(data))
+	for i := 1; ; i++ {
+		var node yaml.Node
+		if err := d.Decode(&node); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+
+		var docs []Manifest
+		if len(node.Content) > 0 && node.Content[0].Kind == yaml.SequenceNode {
+			if err := decodeStrict(&node, &docs); err != nil {
+				return nil, fmt.Errorf("document %d: %v", i, err)
+			}
+		} else {
+			var m Manifest
+			if err := decodeStrict(&node, &m); err != nil {
+				return nil, fmt.Errorf("document %d: %v", i, err)
+			}
+			if m.Kind == "" && m.Name == "" {
+				// 空のドキュメント
+				continue
+			}
+			docs = append(docs, m)
+		}
+
+		manifests = append(manifests, docs...)
+	}
+
+	return manifests, nil
+}
+
+// decodeStrict は知らないフィールドがあればエラーにする
+func decodeStrict(node *yaml.Node, out any) error {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	d := yaml.NewDecoder(bytes.NewReader(data))
+	d.KnownFields(true)
+	if err := d.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Manifest) String() string {
+	return fmt.Sprintf("%s %s", strings.ToLower(m.Kind), m.Name)
+}
+
+// Task はマニフェストからタスクの仕様を作る
+func (m *Manifest) Task() (task.Task, error) {
+	if m.Kind != KindTask {
+		return task.Task{}, fmt.Errorf("%s is not a task", m)
+	}
+	if m.Replicas != nil {
+		return task.Task{}, fmt.Errorf("%s: replicas can only be set on a service", m)
+	}
+	if m.Update != nil {
+		return task.Task{}, fmt.Errorf("%s: update can only be set on a service", m)
+	}
+
+	t, err := m.template()
+	if err != nil {
+		return task.Task{}, err
+	}
+	t.Name = m.Name
+	t.Image = m.Image
+
+	if err := t.Validate(); err != nil {
+		return task.Task{}, fmt.Errorf("%s: %v", m, err)
+	}
+
+	return t, nil
+}
+
+// Service はマニフェストからサービスの仕様を作る。replicasを省略した場合は1とする
+func (m *Manifest) Service() (service.Service, error) {
+	if m.Kind != KindService {
+		return service.Service{}, fmt.Errorf("%s is not a service", m)
+	}
+
+	t, err := m.template()
+	if err != nil {
+		return service.Service{}, err
+	}
+
+	s := service.Service{
+		Name:     m.Name,
+		Image:    m.Image,
+		Replicas: 1,
+		Template: t,
+	}
+	if m.Replicas != nil {
+		s.Replicas = *m.Replicas
+	}
+	if m.Update != nil {
+		s.UpdateConfig = service.UpdateConfig{
+			MaxSurge:       m.Update.MaxSurge,
+			MaxUnavailable: m.Update.MaxUnavailable,
+			FailureAction:  m.Update.FailureAction,
+		}
+	}
+
+	if err := s.Validate(); err != nil {
+		return service.Service{}, fmt.Errorf("%s: %v", m, err)
+	}
+
+	return s, nil
+}
+
+// template はタスクとサービスに共通する仕様を作る
+func (m *Manifest) template() (task.Task, error) {
+	if m.Image == "" {
+		return task.Task{}, fmt.Errorf("%s: image is required", m)
+	}
+
+	t := task.Task{
+		Cmd:          m.Command,
+		Mode:         m.Mode,
+		BackoffLimit: m.BackoffLimit,
+		VolumePolicy: m.VolumePolicy,
+		NodeSelector: m.NodeSelector,
+	}
+	if len(m.Labels) > 0 {
+		t.Labels = m.Labels
+	}
+
+	keys := make([]string, 0, len(m.Env))
+	for k := range m.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		t.Env = append(t.Env, k+"="+m.Env[k])
+	}
+
+	for _, p := range m.Ports {
+		hostPort, port, ok := strings.Cut(p, ":")
+		if !ok {
+			hostPort, port = "", p
+		}
+		proto, num := nat.SplitProtoPort(port)
+		np, err := nat.NewPort(proto, num)
+		if err != nil {
+			return task.Task{}, fmt.Errorf("%s: invalid port %q: %v", m, p, err)
+		}
+		if t.ExposedPorts == nil {
+			t.ExposedPorts = nat.PortSet{}
+		}
+		t.ExposedPorts[np] = struct{}{}
+		if hostPort != "" {
+			if t.PortBindings == nil {
+				t.PortBindings = map[string]string{}
+			}
+			t.PortBindings[string(np)] = hostPort
+		}
+	}
+
+	if r := m.Resources; r != nil {
+		t.Cpu = r.Cpu
+		var err error
+		if t.Memory, err = parseBytes(r.Memory); err != nil {
+			return task.Task{}, fmt.Errorf("%s: invalid memory %q: %v", m, r.Memory, err)
+		}
+		if t.Disk, err = parseBytes(r.Disk); err != nil {
+			return task.Task{}, fmt.Errorf("%s: invalid disk %q: %v", m, r.Disk, err)
+		}
+	}
+
+	if m.Restart != nil {
+		t.RestartPolicy = m.Restart.Policy
+		t.MaxRetries = m.Restart.MaxRetries
+	}
+
+	t.Affinity = m.Affinity.affinity()
+	for _, tol := range m.Tolerations {
+		t.Tolerations = append(t.Tolerations, taints.Toleration(tol))
+	}
+
+	t.HealthCheck = m.HealthCheck.healthCheck()
+	t.ReadinessCheck = m.ReadinessCheck.healthCheck()
+	t.StartupCheck = m.StartupCheck.healthCheck()
+
+	for _, v := range m.Volumes {
+		size, err := parseBytes(v.Size)
+		if err != nil {
+			return task.Task{}, fmt.Errorf("%s: invalid volume size %q: %v", m, v.Size, err)
+		}
+		t.Volumes = append(t.Volumes, task.Volume{
+			Type:     v.Type,
+			Source:   v.Source,
+			Target:   v.Target,
+			ReadOnly: v.ReadOnly,
+			Size:     int64(size),
+		})
+	}
+
+	return t, nil
+}
+
+func (h *HealthCheck) healthCheck() *task.HealthCheck {
+	if h == nil {
+		return nil
+	}
+
+	hc := &task.HealthCheck{
+		InitialDelaySeconds: h.InitialDelaySeconds,
+		IntervalSeconds:     h.IntervalSeconds,
+		TimeoutSeconds:      h.TimeoutSeconds,
+		SuccessThreshold:    h.SuccessThreshold,
+		FailureThreshold:    h.FailureThreshold,
+	}
+	if h.HTTP != nil {
+		hc.HTTP = &task.HTTPProbe{Path: h.HTTP.Path, Port: h.HTTP.Port, ExpectedStatus: h.HTTP.ExpectedStatus}
+	}
+	if h.TCP != nil {
+		hc.TCP = &task.TCPProbe{Port: h.TCP.Port}
+	}
+	if h.Exec != nil {
+		hc.Exec = &task.ExecProbe{Cmd: h.Exec.Command}
+	}
+
+	return hc
+}
+
+func (a *Affinity) affinity() *task.Affinity {
+	if a == nil {
+		return nil
+	}
+
+	terms := func(ts []AffinityTerm) []task.AffinityTerm {
+		var out []task.AffinityTerm
+		for _, t := range ts {
+			out = append(out, task.AffinityTerm(t))
+		}
+		return out
+	}
+
+	return &task.Affinity{
+		Node:     terms(a.Node),
+		Task:     terms(a.Task),
+		AntiTask: terms(a.AntiTask),
+	}
+}
+
+func parseBytes(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := units.RAMInBytes(s)
+	return int(n), err
+}
@@ -0,0 +1,160 @@
+package manifest
+
+import (
+	"cube/task"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+)
+
+const webYAML = `
+kind: Service
+name: web
+image: nginx:1.27
+replicas: 3
+command: ["nginx", "-g", "daemon off;"]
+env:
+  B: "2"
+  A: "1"
+ports:
+  - 80/tcp
+  - 8080:443/tcp
+resources:
+  cpu: 0.5
+  memory: 256m
+healthCheck:
+  http:
+    path: /health
+    port: 80/tcp
+  failureThreshold: 5
+update:
+  maxSurge: 2
+labels:
+  app: web
+affinity:
+  node:
+    - selector: disk=ssd
+      required: true
+  antiTask:
+    - selector: app=web
+      weight: 50
+---
+kind: Task
+name: migrate
+image: migrate:latest
+mode: job
+backoffLimit: 2
+tolerations:
+  - key: dedicated
+    value: batch
+    effect: NoSchedule
+`
+
+func TestParseYAML(t *testing.T) {
+	manifests, err := Parse([]byte(webYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("expected 2 manifests, got %d", len(manifests))
+	}
+
+	s, err := manifests[0].Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Replicas != 3 || s.UpdateConfig.MaxSurge != 2 {
+		t.Errorf("unexpected service %+v", s)
+	}
+
+	tmpl := s.Template
+	if strings.Join(tmpl.Env, ",") != "A=1,B=2" {
+		t.Errorf("expected sorted env, got %v", tmpl.Env)
+	}
+	if _, ok := tmpl.ExposedPorts[nat.Port("80/tcp")]; !ok || len(tmpl.ExposedPorts) != 2 {
+		t.Errorf("expected 80/tcp and 443/tcp to be exposed, got %v", tmpl.ExposedPorts)
+	}
+	if tmpl.PortBindings["443/tcp"] != "8080" || len(tmpl.PortBindings) != 1 {
+		t.Errorf("expected 443/tcp to be bound to 8080, got %v", tmpl.PortBindings)
+	}
+	if tmpl.Memory != 256*1024*1024 || tmpl.Cpu != 0.5 {
+		t.Errorf("unexpected resources cpu %v memory %d", tmpl.Cpu, tmpl.Memory)
+	}
+	if hc := tmpl.HealthCheck; hc.HTTP == nil || hc.HTTP.Path != "/health" || hc.Failures() != 5 {
+		t.Errorf("unexpected health check %+v", hc)
+	}
+	if tmpl.Labels["app"] != "web" {
+		t.Errorf("expected label app=web, got %v", tmpl.Labels)
+	}
+	if a := tmpl.Affinity; a == nil || len(a.Node) != 1 || !a.Node[0].Required || len(a.AntiTask) != 1 || a.AntiTask[0].Weight != 50 {
+		t.Errorf("unexpected affinity %+v", a)
+	}
+
+	job, err := manifests[1].Task()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.IsJob() || job.BackoffLimit != 2 || job.Name != "migrate" {
+		t.Errorf("unexpected task %+v", job)
+	}
+	if len(job.Tolerations) != 1 || job.Tolerations[0].Value != "batch" {
+		t.Errorf("unexpected tolerations %+v", job.Tolerations)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	manifests, err := Parse([]byte(`[
+		{"kind": "Task", "name": "echo", "image": "echo", "restart": {"policy": "Never"}},
+		{"kind": "Service", "name": "api", "image": "api"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("expected 2 manifests, got %d", len(manifests))
+	}
+
+	tk, err := manifests[0].Task()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Restart() != task.RestartNever {
+		t.Errorf("expected restart policy never, got %s", tk.Restart())
+	}
+
+	s, err := manifests[1].Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Replicas != 1 {
+		t.Errorf("expected 1 replica by default, got %d", s.Replicas)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+	}{
+		{"unknown field", "kind: Task\nname: a\nimage: a\nimagee: b\n", "imagee"},
+		{"missing image", "kind: Task\nname: a\n", "image is required"},
+		{"replicas on task", "kind: Task\nname: a\nimage: a\nreplicas: 2\n", "replicas"},
+		{"invalid port", "kind: Service\nname: a\nimage: a\nports: [abc]\n", "invalid port"},
+		{"invalid memory", "kind: Service\nname: a\nimage: a\nresources: {memory: lots}\n", "invalid memory"},
+		{"invalid task", "kind: Task\nname: a\nimage: a\nrestart: {policy: sometimes}\n", "sometimes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifests, err := Parse([]byte(tt.manifest))
+			if err == nil {
+				_, err = Plan(manifests, nil, nil, false)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package manifest
+
+import (
+	"cube/service"
+	"cube/task"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change はマニフェストをマネージャに適用するために必要な操作の一つ
+type Change struct {
+	Action string
+	Kind   string
+	Name   string
+	// 更新、削除するタスクかサービスのID
+	ID uuid.UUID
+	// 変更されるフィールド。"image: a -> b"の形式
+	Fields []string
+
+	Task    *task.Task
+	Service *service.Service
+}
+
+func (c Change) String() string {
+	sign := map[string]string{ActionCreate: "+", ActionUpdate: "~", ActionDelete: "-"}[c.Action]
+	return fmt.Sprintf("%s %s %s", sign, strings.ToLower(c.Kind), c.Name)
+}
+
+// Plan はマニフェストと現在のタスク、サービスを比べ、必要な変更を返す。
+// pruneがtrueの場合はマニフェストにないタスクとサービスを削除する
+func Plan(manifests []Manifest, tasks []*task.Task, services []*service.Service, prune bool) ([]Change, error) {
+	var changes []Change
+
+	seen := map[string]bool{}
+	for _, m := range manifests {
+		key := m.Kind + "/" + m.Name
+		if seen[key] {
+			return nil, fmt.Errorf("%s is defined more than once", &m)
+		}
+		seen[key] = true
+
+		var c *Change
+		var err error
+		switch m.Kind {
+		case KindService:
+			c, err = planService(&m, services)
+		case KindTask:
+			c, err = planTask(&m, tasks)
+		default:
+			err = fmt.Errorf("%s: unknown kind %q", m.Name, m.Kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			changes = append(changes, *c)
+		}
+	}
+
+	if !prune {
+		return changes, nil
+	}
+
+	for _, s := range services {
+		if !seen[KindService+"/"+s.Name] {
+			changes = append(changes, Change{Action: ActionDelete, Kind: KindService, Name: s.Name, ID: s.ID})
+		}
+	}
+	for _, t := range tasks {
+		if t.ServiceID == uuid.Nil && taskActive(t) && !seen[KindTask+"/"+t.Name] {
+			changes = append(changes, Change{Action: ActionDelete, Kind: KindTask, Name: t.Name, ID: t.ID})
+		}
+	}
+
+	return changes, nil
+}
+
+func planService(m *Manifest, services []*service.Service) (*Change, error) {
+	desired, err := m.Service()
+	if err != nil {
+		return nil, err
+	}
+
+	var current *service.Service
+	for _, s := range services {
+		if s.Name == m.Name {
+			current = s
+			break
+		}
+	}
+
+	if current == nil {
+		return &Change{Action: ActionCreate, Kind: KindService, Name: m.Name, Fields: diffFields(nil, serviceSpec(&desired)), Service: &desired}, nil
+	}
+
+	fields := diffFields(serviceSpec(current), serviceSpec(&desired))
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	return &Change{Action: ActionUpdate, Kind: KindService, Name: m.Name, ID: current.ID, Fields: fields, Service: &desired}, nil
+}
+
+// planTask は同じ名前のタスクが動いていればそれと比べる。
+// タスクは更新できないので、仕様が変わった場合は停止して作り直す
+func planTask(m *Manifest, tasks []*task.Task) (*Change, error) {
+	desired, err := m.Task()
+	if err != nil {
+		return nil, err
+	}
+
+	var current *task.Task
+	for _, t := range tasks {
+		if t.ServiceID != uuid.Nil || t.Name != m.Name {
+			continue
+		}
+		if current == nil || (taskActive(t) && !taskActive(current)) || (taskActive(t) == taskActive(current) && t.StartTime.After(current.StartTime)) {
+			current = t
+		}
+	}
+
+	create := &Change{Action: ActionCreate, Kind: KindTask, Name: m.Name, Fields: diffFields(nil, &desired), Task: &desired}
+	if current == nil {
+		return create, nil
+	}
+
+	spec := current.Spec()
+	fields := diffFields(&spec, &desired)
+
+	if !taskActive(current) {
+		// 完了したジョブは仕様が変わらなければもう一度は動かさない
+		if current.IsJob() && current.State == task.Completed && len(fields) == 0 {
+			return nil, nil
+		}
+		return create, nil
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	return &Change{Action: ActionUpdate, Kind: KindTask, Name: m.Name, ID: current.ID, Fields: fields, Task: &desired}, nil
+}
+
+// serviceSpec はサービスのうちマニフェストで指定できる部分を返す
+func serviceSpec(s *service.Service) any {
+	return struct {
+		Image        string
+		Replicas     int
+		Template     task.Task
+		UpdateConfig service.UpdateConfig
+	}{s.Image, s.Replicas, s.Template.Spec(), s.UpdateConfig}
+}
+
+func taskActive(t *task.Task) bool {
+	switch t.State {
+	case task.Pending, task.Scheduled, task.Running:
+		return true
+	}
+	return false
+}
+
+// diffFields はfromとtoをフィールドごとに比べ、違うフィールドを"name: from -> to"の形式で返す。
+// fromがnilの場合は設定されているフィールドを"name: to"の形式で返す
+func diffFields(from, to any) []string {
+	a, b := map[string]string{}, map[string]string{}
+	if from != nil {
+		flatten("", toJSON(from), a)
+	}
+	flatten("", toJSON(to), b)
+	// 状態は仕様ではない
+	for _, k := range []string{"State", "Template.State"} {
+		delete(a, k)
+		delete(b, k)
+	}
+
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+
+	var fields []string
+	for k := range keys {
+		switch {
+		case from == nil:
+			fields = append(fields, fmt.Sprintf("%s: %s", k, b[k]))
+		case a[k] != b[k]:
+			fields = append(fields, fmt.Sprintf("%s: %s -> %s", k, orNone(a[k]), orNone(b[k])))
+		}
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+func toJSON(v any) any {
+	data, _ := json.Marshal(v)
+
+	var out any
+	json.Unmarshal(data, &out)
+	return out
+}
+
+// flatten はJSONの値を"Template.Env"のようなキーで平らにする。空の値は含めない
+func flatten(prefix string, v any, out map[string]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		// ExposedPortsのように値が空のマップはキーだけで意味がある
+		if len(v) == 0 && prefix != "" {
+			out[prefix] = "{}"
+		}
+		for k, child := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flatten(key, child, out)
+		}
+	case []any:
+		if len(v) > 0 {
+			data, _ := json.Marshal(v)
+			out[prefix] = string(data)
+		}
+	case string:
+		if v != "" && v != zeroTime && v != uuid.Nil.String() {
+			out[prefix] = v
+		}
+	case float64:
+		if v != 0 {
+			out[prefix] = fmt.Sprint(v)
+		}
+	case bool:
+		if v {
+			out[prefix] = "true"
+		}
+	}
+}
+
+var zeroTime = time.Time{}.Format(time.RFC3339)
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
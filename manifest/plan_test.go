@@ -0,0 +1,143 @@
+package manifest
+
+import (
+	"cube/service"
+	"cube/task"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func mustParse(t *testing.T, data string) []Manifest {
+	t.Helper()
+
+	manifests, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifests
+}
+
+// applied はマネージャに作られたようにIDと実行時の状態を設定する
+func applied(t *testing.T, c Change) (*task.Task, *service.Service) {
+	t.Helper()
+
+	if c.Task != nil {
+		tk := *c.Task
+		tk.ID = uuid.New()
+		tk.State = task.Running
+		tk.StartTime = time.Now()
+		tk.ContainerID = "abc"
+		return &tk, nil
+	}
+
+	s := &service.Service{ID: uuid.New(), Name: c.Service.Name}
+	s.Apply(c.Service)
+	return nil, s
+}
+
+func TestPlanCreatesThenNothing(t *testing.T) {
+	manifests := mustParse(t, webYAML)
+
+	changes, err := Plan(manifests, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Action != ActionCreate || changes[1].Action != ActionCreate {
+		t.Fatalf("expected 2 creates, got %v", changes)
+	}
+
+	tk, _ := applied(t, changes[1])
+	_, s := applied(t, changes[0])
+
+	changes, err = Plan(manifests, []*task.Task{tk}, []*service.Service{s}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes once applied, got %v %v", changes, changes[0].Fields)
+	}
+}
+
+func TestPlanUpdates(t *testing.T) {
+	changes, _ := Plan(mustParse(t, webYAML), nil, nil, false)
+	tk, _ := applied(t, changes[1])
+	_, s := applied(t, changes[0])
+
+	updated := strings.NewReplacer("nginx:1.27", "nginx:1.28", "backoffLimit: 2", "backoffLimit: 4").Replace(webYAML)
+	changes, err := Plan(mustParse(t, updated), []*task.Task{tk}, []*service.Service{s}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 updates, got %v", changes)
+	}
+
+	if c := changes[0]; c.Action != ActionUpdate || c.ID != s.ID || strings.Join(c.Fields, ",") != "Image: nginx:1.27 -> nginx:1.28" {
+		t.Errorf("unexpected service change %v %v", c, c.Fields)
+	}
+	if c := changes[1]; c.Action != ActionUpdate || c.ID != tk.ID || strings.Join(c.Fields, ",") != "BackoffLimit: 2 -> 4" {
+		t.Errorf("unexpected task change %v %v", c, c.Fields)
+	}
+}
+
+func TestPlanFinishedTasks(t *testing.T) {
+	changes, _ := Plan(mustParse(t, webYAML), nil, nil, false)
+	job, _ := applied(t, changes[1])
+	job.State = task.Completed
+
+	changes, _ = Plan(mustParse(t, webYAML), []*task.Task{job}, nil, false)
+	for _, c := range changes {
+		if c.Kind == KindTask {
+			t.Errorf("expected a completed job not to be run again, got %v", c)
+		}
+	}
+
+	job.State = task.Failed
+	changes, _ = Plan(mustParse(t, webYAML), []*task.Task{job}, nil, false)
+	if c := changes[len(changes)-1]; c.Kind != KindTask || c.Action != ActionCreate {
+		t.Errorf("expected a failed job to be created again, got %v", c)
+	}
+}
+
+func TestPlanPrune(t *testing.T) {
+	other := &task.Task{ID: uuid.New(), Name: "other", State: task.Running}
+	done := &task.Task{ID: uuid.New(), Name: "done", State: task.Completed}
+	replica := &task.Task{ID: uuid.New(), Name: "old-1", State: task.Running, ServiceID: uuid.New()}
+	old := &service.Service{ID: uuid.New(), Name: "old"}
+	tasks := []*task.Task{other, done, replica}
+
+	changes, _ := Plan(mustParse(t, webYAML), tasks, []*service.Service{old}, false)
+	for _, c := range changes {
+		if c.Action == ActionDelete {
+			t.Errorf("expected nothing to be deleted without prune, got %v", c)
+		}
+	}
+
+	changes, _ = Plan(mustParse(t, webYAML), tasks, []*service.Service{old}, true)
+	var deleted []string
+	for _, c := range changes {
+		if c.Action == ActionDelete {
+			deleted = append(deleted, c.String())
+		}
+	}
+	if strings.Join(deleted, ",") != "- service old,- task other" {
+		t.Errorf("expected the service and the running task to be deleted, got %v", deleted)
+	}
+}
+
+func TestPlanDuplicate(t *testing.T) {
+	_, err := Plan(mustParse(t, "kind: Task\nname: a\nimage: a\n---\nkind: Task\nname: a\nimage: b\n"), nil, nil, false)
+	if err == nil {
+		t.Errorf("expected an error for a duplicated task")
+	}
+}
+
+func TestPlanCreateFields(t *testing.T) {
+	changes, _ := Plan(mustParse(t, "kind: Task\nname: a\nimage: a\n"), nil, nil, false)
+	if got := strings.Join(changes[0].Fields, ","); got != "Image: a,Name: a" {
+		t.Errorf("expected only the fields set in the manifest, got %s", got)
+	}
+}
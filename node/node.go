@@ -1,11 +1,15 @@
 package node
 
-import "cube/worker"
+import (
+	"cube/taints"
+	"cube/worker"
+)
 
 type Node struct {
 	Name            string
 	Ip              string
 	Cores           int
+	CpuAllocated    float64
 	Memory          int
 	MemoryAllocated int
 	Disk            int
@@ -13,12 +17,18 @@ type Node struct {
 	Role            string
 	TaskCount       int
 	Stats           worker.Stats
+	PortsAllocated  map[string]string
+	Labels          map[string]string
+	Taints          []taints.Taint
+	// ノードに割り当てたタスクのIDごとのラベル。アフィニティの判定に使う
+	TaskLabels map[string]map[string]string `json:"-"`
 }
 
 func New(worker, address, role string) *Node {
 	return &Node{
-		Name: worker,
-		Ip:   address,
-		Role: role,
+		Name:           worker,
+		Ip:             address,
+		Role:           role,
+		PortsAllocated: make(map[string]string),
 	}
 }
@@ -36,6 +36,12 @@ func GetStats(n *Node) *worker.Stats {
 		return nil
 	}
 
+	if stats.MemStats == nil || stats.DiskStats == nil {
+		// ワーカーがまだ統計を集めていない
+		return nil
+	}
+
+	n.Cores = stats.Cores
 	n.Memory = int(stats.MemTotalKb())
 	n.Disk = int(stats.DiskTotal())
 
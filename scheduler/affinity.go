@@ -0,0 +1,98 @@
+package scheduler
+
+import (
+	"cube/labels"
+	"cube/node"
+	"cube/task"
+)
+
+// checkAffinity はノードがタスクの必須のアフィニティをすべて満たすかを確認する
+func checkAffinity(t task.Task, n *node.Node, nodes []*node.Node) bool {
+	a := t.Affinity
+	if a == nil {
+		return true
+	}
+
+	for _, term := range a.Node {
+		if term.Required && !matchesLabels(term, n.Labels) {
+			return false
+		}
+	}
+	for _, term := range a.Task {
+		if !term.Required || hasTask(term, t, n) {
+			continue
+		}
+		// 合うタスクがまだどこにもなく、タスク自身が合う場合はグループの最初の一つとして置く
+		if !anyTask(term, t, nodes) && matchesLabels(term, t.Labels) {
+			continue
+		}
+		return false
+	}
+	for _, term := range a.AntiTask {
+		if term.Required && hasTask(term, t, n) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// affinityPreference は満たしている優先のアフィニティの重みの割合を0から1で返す
+func affinityPreference(t task.Task, n *node.Node) float64 {
+	a := t.Affinity
+	if a == nil {
+		return 0
+	}
+
+	var total, matched int
+	add := func(terms []task.AffinityTerm, ok func(task.AffinityTerm) bool) {
+		for _, term := range terms {
+			if term.Required {
+				continue
+			}
+			total += term.Weight
+			if ok(term) {
+				matched += term.Weight
+			}
+		}
+	}
+	add(a.Node, func(term task.AffinityTerm) bool { return matchesLabels(term, n.Labels) })
+	add(a.Task, func(term task.AffinityTerm) bool { return hasTask(term, t, n) })
+	add(a.AntiTask, func(term task.AffinityTerm) bool { return !hasTask(term, t, n) })
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(matched) / float64(total)
+}
+
+func matchesLabels(term task.AffinityTerm, l map[string]string) bool {
+	selector, err := labels.Parse(term.Selector)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(l)
+}
+
+// hasTask はノードでセレクタに合う他のタスクが動いているかを確認する
+func hasTask(term task.AffinityTerm, t task.Task, n *node.Node) bool {
+	for id, l := range n.TaskLabels {
+		if id != t.ID.String() && matchesLabels(term, l) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func anyTask(term task.AffinityTerm, t task.Task, nodes []*node.Node) bool {
+	for _, n := range nodes {
+		if hasTask(term, t, n) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/task"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newNodes(labels ...map[string]string) []*node.Node {
+	var nodes []*node.Node
+	for i, l := range labels {
+		n := node.New(string(rune('a'+i)), "", "worker")
+		n.Labels = l
+		n.TaskLabels = make(map[string]map[string]string)
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func nodeNames(nodes []*node.Node) string {
+	var s string
+	for _, n := range nodes {
+		s += n.Name
+	}
+	return s
+}
+
+func TestSelectCandidateNodesHonorsAffinity(t *testing.T) {
+	nodes := newNodes(
+		map[string]string{"disk": "ssd"},
+		map[string]string{"disk": "hdd"},
+		map[string]string{"disk": "ssd"},
+	)
+	nodes[0].TaskLabels[uuid.NewString()] = map[string]string{"app": "web"}
+	nodes[1].TaskLabels[uuid.NewString()] = map[string]string{"app": "db"}
+
+	tests := []struct {
+		name     string
+		affinity task.Affinity
+		want     string
+	}{
+		{"required node", task.Affinity{Node: []task.AffinityTerm{{Selector: "disk=ssd", Required: true}}}, "ac"},
+		{"required task", task.Affinity{Task: []task.AffinityTerm{{Selector: "app=db", Required: true}}}, "b"},
+		{"required anti task", task.Affinity{AntiTask: []task.AffinityTerm{{Selector: "app=web", Required: true}}}, "bc"},
+		{"preferred only", task.Affinity{Node: []task.AffinityTerm{{Selector: "disk=nvme", Weight: 10}}}, "abc"},
+	}
+
+	for _, name := range []string{"roundrobin", "epvm"} {
+		s, _ := New(name)
+		for _, tt := range tests {
+			tk := task.Task{ID: uuid.New(), Affinity: &tt.affinity}
+			if got := nodeNames(s.SelectCandidateNodes(tk, nodes)); got != tt.want {
+				t.Errorf("%s %s: expected %s, got %s", name, tt.name, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestSelectCandidateNodesPlacesFirstTaskOfGroup(t *testing.T) {
+	nodes := newNodes(nil, nil)
+	affinity := &task.Affinity{Task: []task.AffinityTerm{{Selector: "app=cache", Required: true}}}
+
+	rr, _ := New("roundrobin")
+	first := task.Task{ID: uuid.New(), Labels: map[string]string{"app": "cache"}, Affinity: affinity}
+	if got := nodeNames(rr.SelectCandidateNodes(first, nodes)); got != "ab" {
+		t.Errorf("expected the first task of the group to be placed anywhere, got %s", got)
+	}
+
+	other := task.Task{ID: uuid.New(), Affinity: affinity}
+	if got := nodeNames(rr.SelectCandidateNodes(other, nodes)); got != "" {
+		t.Errorf("expected a task outside the group to wait, got %s", got)
+	}
+
+	nodes[1].TaskLabels[first.ID.String()] = first.Labels
+	if got := nodeNames(rr.SelectCandidateNodes(other, nodes)); got != "b" {
+		t.Errorf("expected the task to follow the group, got %s", got)
+	}
+}
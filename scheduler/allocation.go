@@ -0,0 +1,30 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/task"
+)
+
+// allocatedFraction はタスクを置いた後にノードの容量のうち割り当て済みになるCPUとメモリの割合の平均を返す。
+// /procの値ではなくタスクが要求したリソースで計算し、容量がわからないものは除く。
+// リソースが同じならタスクの数が多いほうをわずかに大きくする
+func allocatedFraction(t task.Task, n *node.Node) float64 {
+	var sum float64
+	var count int
+	if n.Cores > 0 {
+		sum += (n.CpuAllocated + t.Cpu) / float64(n.Cores)
+		count++
+	}
+	// ノードのメモリはKiB単位
+	if n.Memory > 0 {
+		sum += float64(n.MemoryAllocated+t.Memory/1024) / float64(n.Memory)
+		count++
+	}
+
+	fraction := float64(n.TaskCount) * 1e-6
+	if count > 0 {
+		fraction += sum / float64(count)
+	}
+
+	return fraction
+}
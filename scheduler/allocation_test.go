@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/task"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// newAllocatedNodes は4コア、メモリ4GiBで、CPUをそれぞれcpus割り当て済みのノードを返す
+func newAllocatedNodes(cpus ...float64) []*node.Node {
+	nodes := newNodes(make([]map[string]string, len(cpus))...)
+	for i, n := range nodes {
+		n.Cores = 4
+		n.Memory = 4 * 1024 * 1024
+		n.Disk = 100 * 1024 * 1024 * 1024
+		n.CpuAllocated = cpus[i]
+	}
+	return nodes
+}
+
+func TestBinPackAndSpread(t *testing.T) {
+	tk := task.Task{ID: uuid.New(), Cpu: 1, Memory: 512 * 1024 * 1024}
+
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{"binpack", "b"},
+		{"spread", "c"},
+	}
+
+	for _, tt := range tests {
+		f := mustNew(t, tt.profile)
+		// aはタスクが入りきらないので、bが最も割り当てが多い
+		nodes := newAllocatedNodes(3.5, 2, 1)
+		candidates := f.SelectCandidateNodes(tk, nodes)
+		if got := nodeNames(candidates); got != "bc" {
+			t.Fatalf("%s: expected candidates bc, got %s", tt.profile, got)
+		}
+
+		if got := f.Pick(f.Score(tk, candidates), candidates); got.Name != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.profile, tt.want, got.Name)
+		}
+	}
+}
+
+func TestSpreadByTaskCount(t *testing.T) {
+	// リソースを要求しないタスクはタスクの数で分散する
+	nodes := newAllocatedNodes(0, 0)
+	nodes[0].TaskCount = 2
+	tk := task.Task{ID: uuid.New()}
+
+	f := mustNew(t, "spread")
+	if got := f.Pick(f.Score(tk, nodes), nodes); got.Name != "b" {
+		t.Errorf("expected b, got %s", got.Name)
+	}
+}
+
+func TestAllocatedFraction(t *testing.T) {
+	n := newAllocatedNodes(1)[0]
+	n.MemoryAllocated = 1024 * 1024
+	tk := task.Task{Cpu: 1, Memory: 1 << 30}
+
+	// CPUは2/4、メモリは2GiB/4GiB
+	if got := allocatedFraction(tk, n); got != 0.5 {
+		t.Errorf("expected 0.5, got %v", got)
+	}
+
+	// 容量がわからないものは除く
+	n.Memory = 0
+	if got := allocatedFraction(tk, n); got != 0.5 {
+		t.Errorf("expected 0.5 without memory, got %v", got)
+	}
+}
+
+func mustNew(t *testing.T, name string) *Framework {
+	f, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
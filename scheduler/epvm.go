@@ -7,81 +7,33 @@ import (
 	"time"
 )
 
-var _ Scheduler = &Epvm{}
-
-type Epvm struct {
-	Name string
-}
-
-func (e *Epvm) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
-	var candidates []*node.Node
-	for _, node := range nodes {
-		if checkDisk(t, node.Disk-node.DiskAllocated) {
-			candidates = append(candidates, node)
-		}
-	}
-
-	return candidates
-}
-
-func checkDisk(t task.Task, diskAvailable int) bool {
-	return t.Disk <= diskAvailable
-}
-
 const (
 	LIEB = 1.53960071783900203869
 )
 
-func (e *Epvm) Score(t task.Task, nodes []*node.Node) map[string]float64 {
-	nodeScores := make(map[string]float64)
+// epvmCost はタスクをノードに置いたときに増えるメモリとCPUの負荷のコストを返す
+func epvmCost(t task.Task, node *node.Node) float64 {
 	maxJobs := 4.0
 
-	for _, node := range nodes {
-		cpuUsage := calculateCpuUsage(node)
-		cpuLoad := calculateLoad(cpuUsage, math.Pow(2, 0.8))
+	cpuUsage := calculateCpuUsage(node)
+	cpuLoad := calculateLoad(cpuUsage, math.Pow(2, 0.8))
 
-		memoryAllocated := float64(node.Stats.MemUsedKb()) + float64(node.MemoryAllocated)
-		memoryPercentAllocated := memoryAllocated / float64(node.Memory)
+	memoryAllocated := float64(node.Stats.MemUsedKb()) + float64(node.MemoryAllocated)
+	memoryPercentAllocated := memoryAllocated / float64(node.Memory)
 
-		newMemPercent := (calculateLoad(memoryAllocated+float64(t.Memory/1000), float64(node.Memory)))
+	newMemPercent := (calculateLoad(memoryAllocated+float64(t.Memory/1000), float64(node.Memory)))
 
-		memCost := math.Pow(LIEB, newMemPercent)
-		memCost += math.Pow(LIEB, float64(node.TaskCount+1)/maxJobs)
-		memCost -= math.Pow(LIEB, memoryPercentAllocated)
-		memCost -= math.Pow(LIEB, float64(node.TaskCount)/float64(maxJobs))
+	memCost := math.Pow(LIEB, newMemPercent)
+	memCost += math.Pow(LIEB, float64(node.TaskCount+1)/maxJobs)
+	memCost -= math.Pow(LIEB, memoryPercentAllocated)
+	memCost -= math.Pow(LIEB, float64(node.TaskCount)/float64(maxJobs))
 
-		cpuCost := math.Pow(LIEB, cpuLoad)
-		cpuCost += math.Pow(LIEB, float64(node.TaskCount+1)/maxJobs)
-		cpuCost -= math.Pow(LIEB, cpuLoad)
-		cpuCost -= math.Pow(LIEB, float64(node.TaskCount)/float64(maxJobs))
-
-		nodeScores[node.Name] = memCost + cpuCost
-	}
-
-	return nodeScores
-}
-
-func (e *Epvm) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
-
-	if len(candidates) == 0 {
-		return nil
-	}
-
-	if len(candidates) == 1 {
-		return candidates[0]
-	}
-
-	bestNode := candidates[0]
-	minCost := scores[bestNode.Name]
-
-	for _, node := range candidates[1:] {
-		if scores[node.Name] < minCost {
-			minCost = scores[node.Name]
-			bestNode = node
-		}
-	}
+	cpuCost := math.Pow(LIEB, cpuLoad)
+	cpuCost += math.Pow(LIEB, float64(node.TaskCount+1)/maxJobs)
+	cpuCost -= math.Pow(LIEB, cpuLoad)
+	cpuCost -= math.Pow(LIEB, float64(node.TaskCount)/float64(maxJobs))
 
-	return bestNode
+	return memCost + cpuCost
 }
 
 func calculateCpuUsage(n *node.Node) float64 {
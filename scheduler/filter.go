@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/task"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultFilters はフィルタを省略したプロファイルが使う組み込みのフィルタ
+var DefaultFilters = []string{"nodeSelector", "affinity", "taints", "resources", "ports"}
+
+func defaultFilters() []FilterPlugin {
+	var filters []FilterPlugin
+	for _, name := range DefaultFilters {
+		filters = append(filters, registry[name]().(FilterPlugin))
+	}
+
+	return filters
+}
+
+// feasibleNodes はすべてのフィルタを通ったノードと、通らなかったノードの数を理由ごとに返す
+func feasibleNodes(t task.Task, nodes []*node.Node, filters []FilterPlugin) ([]*node.Node, map[string]int) {
+	var candidates []*node.Node
+	reasons := make(map[string]int)
+
+	for _, n := range nodes {
+		reason := ""
+		for _, f := range filters {
+			if reason = f.Filter(t, n, nodes); reason != "" {
+				break
+			}
+		}
+		if reason != "" {
+			reasons[reason]++
+			continue
+		}
+		candidates = append(candidates, n)
+	}
+
+	return candidates, reasons
+}
+
+// checkResources はノードに割り当てていないCPU、メモリ、ディスクがタスクの要求に足りるかを確認する。
+// ワーカーから容量をまだ取得していないノードには、リソースを要求するタスクを置かない
+func checkResources(t task.Task, n *node.Node) string {
+	if (t.Cpu > 0 && n.Cores == 0) || (t.Memory > 0 && n.Memory == 0) || (t.Disk > 0 && n.Disk == 0) {
+		return "node capacity unknown"
+	}
+	if t.Cpu > 0 && n.CpuAllocated+t.Cpu > float64(n.Cores) {
+		return "insufficient cpu"
+	}
+	// ノードのメモリはKiB単位
+	if t.Memory > 0 && n.MemoryAllocated+t.Memory/1024 > n.Memory {
+		return "insufficient memory"
+	}
+	if t.Disk > 0 && n.DiskAllocated+t.Disk > n.Disk {
+		return "insufficient disk"
+	}
+
+	return ""
+}
+
+// formatReasons は理由を多い順に"insufficient memory on 3 nodes, untolerated taint on 1 node"の形式で並べる
+func formatReasons(reasons map[string]int) string {
+	keys := make([]string, 0, len(reasons))
+	for r := range reasons {
+		keys = append(keys, r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if reasons[keys[i]] != reasons[keys[j]] {
+			return reasons[keys[i]] > reasons[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	var parts []string
+	for _, r := range keys {
+		if reasons[r] == 1 {
+			parts = append(parts, fmt.Sprintf("%s on 1 node", r))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s on %d nodes", r, reasons[r]))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
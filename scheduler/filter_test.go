@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"cube/taints"
+	"cube/task"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSelectCandidateNodesChecksResources(t *testing.T) {
+	nodes := newNodes(nil, nil, nil, nil)
+	for _, n := range nodes {
+		n.Cores = 4
+		n.Memory = 4 * 1024 * 1024
+		n.Disk = 10 << 30
+	}
+	nodes[0].CpuAllocated = 3.5
+	nodes[1].MemoryAllocated = 3 * 1024 * 1024
+	nodes[2].DiskAllocated = 9 << 30
+	nodes[3].Cores, nodes[3].Memory, nodes[3].Disk = 0, 0, 0
+
+	tk := task.Task{ID: uuid.New(), Cpu: 1, Memory: 2 << 30, Disk: 2 << 30}
+	for _, name := range []string{"roundrobin", "epvm"} {
+		s, _ := New(name)
+		if got := s.SelectCandidateNodes(tk, nodes); got != nil {
+			t.Errorf("%s: expected no node to fit, got %s", name, nodeNames(got))
+		}
+	}
+	rr, _ := New("roundrobin")
+	want := "0/4 nodes are available: insufficient cpu on 1 node, insufficient disk on 1 node, insufficient memory on 1 node, node capacity unknown on 1 node"
+	if got := Unschedulable(rr, tk, nodes); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// 容量がわからないノードにはリソースを要求しないタスクだけを置く
+	small := task.Task{ID: uuid.New(), Cpu: 0.5, Memory: 512 << 20, Disk: 512 << 20}
+	if got := nodeNames(rr.SelectCandidateNodes(small, nodes)); got != "abc" {
+		t.Errorf("expected a small task to fit on the nodes with known capacity, got %s", got)
+	}
+	if got := nodeNames(rr.SelectCandidateNodes(task.Task{ID: uuid.New()}, nodes)); got != "abcd" {
+		t.Errorf("expected a task without requests to fit everywhere, got %s", got)
+	}
+}
+
+func TestUnschedulable(t *testing.T) {
+	nodes := newNodes(nil, nil, nil, map[string]string{"disk": "ssd"})
+	for _, n := range nodes {
+		n.Memory = 1024 * 1024
+	}
+	nodes[2].Taints = []taints.Taint{{Key: "dedicated", Effect: taints.NoSchedule}}
+
+	rr, _ := New("roundrobin")
+	tk := task.Task{ID: uuid.New(), Memory: 2 << 30}
+	want := "0/4 nodes are available: insufficient memory on 3 nodes, untolerated taint on 1 node"
+	if got := Unschedulable(rr, tk, nodes); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if got := Unschedulable(rr, tk, nil); got != "no nodes in the cluster" {
+		t.Errorf("unexpected reason %q", got)
+	}
+}
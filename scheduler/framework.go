@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/task"
+	"fmt"
+	"math"
+)
+
+// Plugin はFrameworkに組み込むスケジューラの部品。
+// FilterPlugin、ScorePlugin、BindPluginのいずれか一つ以上を実装する
+type Plugin interface {
+	Name() string
+}
+
+// FilterPlugin はタスクを置けないノードを除く。置けない理由を返し、置ける場合は空文字を返す
+type FilterPlugin interface {
+	Plugin
+	Filter(t task.Task, n *node.Node, nodes []*node.Node) string
+}
+
+// ScorePlugin は候補のノードに点数を付ける。点数は大きいほど望ましく、Frameworkが0から1に揃えてから重みを掛ける
+type ScorePlugin interface {
+	Plugin
+	Score(t task.Task, nodes []*node.Node) map[string]float64
+}
+
+// BindPlugin はタスクを置くノードが決まった後に呼ばれる
+type BindPlugin interface {
+	Plugin
+	Bind(t task.Task, n *node.Node)
+}
+
+// Binder はマネージャがタスクを置くノードを決めた後に呼ぶ
+type Binder interface {
+	Bind(t task.Task, n *node.Node)
+}
+
+// registry はプラグインの名前とプラグインを作る関数。プロファイルからはこの名前で参照する
+var registry = map[string]func() Plugin{}
+
+// Register はプラグインを名前で登録する。同じ名前のプラグインは置き換える
+func Register(name string, factory func() Plugin) {
+	registry[name] = factory
+}
+
+var _ Scheduler = &Framework{}
+var _ Binder = &Framework{}
+
+// Framework はプロファイルに従ってプラグインを組み合わせたスケジューラ
+type Framework struct {
+	Name    string
+	filters []FilterPlugin
+	scores  []weightedScore
+	binds   []BindPlugin
+}
+
+type weightedScore struct {
+	plugin ScorePlugin
+	weight float64
+}
+
+func (f *Framework) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	candidates, _ := feasibleNodes(t, nodes, f.filters)
+
+	return candidates
+}
+
+func (f *Framework) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, n := range nodes {
+		scores[n.Name] = 0
+	}
+
+	for _, s := range f.scores {
+		for name, v := range normalize(s.plugin.Score(t, nodes)) {
+			scores[name] += s.weight * v
+		}
+	}
+
+	return scores
+}
+
+// Pick は点数が最も高いノードを選ぶ。同じ点数なら先にあるノードを選ぶ
+func (f *Framework) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	var best *node.Node
+	bestScore := math.Inf(-1)
+	for _, n := range candidates {
+		if scores[n.Name] > bestScore {
+			best = n
+			bestScore = scores[n.Name]
+		}
+	}
+
+	return best
+}
+
+func (f *Framework) Bind(t task.Task, n *node.Node) {
+	for _, b := range f.binds {
+		b.Bind(t, n)
+	}
+}
+
+// normalize は点数を最小が0、最大が1になるように揃える。すべて同じ点数なら差を付けないように0にする
+func normalize(scores map[string]float64) map[string]float64 {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, v := range scores {
+		low = min(low, v)
+		high = max(high, v)
+	}
+
+	normalized := make(map[string]float64, len(scores))
+	for name, v := range scores {
+		if high > low {
+			normalized[name] = (v - low) / (high - low)
+		} else {
+			normalized[name] = 0
+		}
+	}
+
+	return normalized
+}
+
+// Unschedulable はタスクをどのノードにも置けない理由を"insufficient memory on 3 nodes"の形式で返す。
+// Frameworkはプロファイルのフィルタで、それ以外のスケジューラは組み込みのフィルタで確認する
+func Unschedulable(s Scheduler, t task.Task, nodes []*node.Node) string {
+	if len(nodes) == 0 {
+		return "no nodes in the cluster"
+	}
+
+	filters := defaultFilters()
+	if f, ok := s.(*Framework); ok {
+		filters = f.filters
+	}
+
+	_, reasons := feasibleNodes(t, nodes, filters)
+
+	return fmt.Sprintf("0/%d nodes are available: %s", len(nodes), formatReasons(reasons))
+}
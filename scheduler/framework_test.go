@@ -0,0 +1,155 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/task"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBuiltinProfiles(t *testing.T) {
+	for _, name := range Profiles() {
+		f, err := New(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if f.Name != name || len(f.filters) != len(DefaultFilters) {
+			t.Errorf("%s: unexpected framework %+v", name, f)
+		}
+	}
+
+	if _, err := New("fastest"); err == nil {
+		t.Error("expected an unknown scheduler to be rejected")
+	}
+}
+
+func TestBuiltinProfilesPreferAffinity(t *testing.T) {
+	for _, name := range Profiles() {
+		// epvmはワーカーから統計を取るので除く
+		if name == "epvm" {
+			continue
+		}
+		f, _ := New(name)
+		nodes := newAllocatedNodes(3, 2, 1)
+		tk := task.Task{ID: uuid.New(), Cpu: 1, Memory: 512 * 1024 * 1024}
+
+		// 方針だけで選ぶノードとは別のノードを優先させる
+		candidates := f.SelectCandidateNodes(tk, nodes)
+		picked := f.Pick(f.Score(tk, candidates), candidates)
+		preferred := candidates[0]
+		if preferred == picked {
+			preferred = candidates[1]
+		}
+		preferred.Labels = map[string]string{"zone": "a"}
+		tk.Affinity = &task.Affinity{Node: []task.AffinityTerm{{Selector: "zone=a", Weight: 1}}}
+
+		if got := f.Pick(f.Score(tk, candidates), candidates); got != preferred {
+			t.Errorf("%s: expected the preferred node %s, got %s", name, preferred.Name, got.Name)
+		}
+	}
+}
+
+func TestFrameworkRoundRobin(t *testing.T) {
+	f, _ := New("roundrobin")
+	nodes := newNodes(nil, nil, nil)
+	tk := task.Task{ID: uuid.New()}
+
+	var picked []string
+	for i := 0; i < 4; i++ {
+		candidates := f.SelectCandidateNodes(tk, nodes)
+		n := f.Pick(f.Score(tk, candidates), candidates)
+		f.Bind(tk, n)
+		picked = append(picked, n.Name)
+	}
+
+	if got := strings.Join(picked, ""); got != "abca" {
+		t.Errorf("expected the nodes to be picked in turn, got %s", got)
+	}
+}
+
+func TestFrameworkWeights(t *testing.T) {
+	nodes := newNodes(map[string]string{"zone": "a"}, nil)
+	tk := task.Task{ID: uuid.New(), Affinity: &task.Affinity{
+		Node: []task.AffinityTerm{{Selector: "zone=a", Weight: 50}},
+	}}
+
+	// roundRobinは前回の次のノードbを選ぼうとする
+	tests := []struct {
+		weight float64
+		want   *node.Node
+	}{
+		{10, nodes[0]},
+		{0.5, nodes[1]},
+		// 0は1にせず、アフィニティを無視する
+		{0, nodes[1]},
+	}
+
+	for _, tt := range tests {
+		f, err := Profile{Name: "test", Scores: []ScoreConfig{{Name: "affinity", Weight: &tt.weight}, {Name: "roundRobin"}}}.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Bind(tk, nodes[0])
+
+		if got := f.Pick(f.Score(tk, nodes), nodes); got != tt.want {
+			t.Errorf("affinity weight %v: expected %s, got %s", tt.weight, tt.want.Name, got.Name)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "profile.yaml")
+	os.WriteFile(path, []byte(`
+name: gpu
+filters: [nodeSelector, resources]
+scores:
+  - name: roundRobin
+`), 0644)
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "gpu" || len(f.filters) != 2 || len(f.scores) != 1 || f.scores[0].weight != 1 || len(f.binds) != 1 {
+		t.Errorf("unexpected framework %+v", f)
+	}
+
+	// プロファイルにないフィルタは確認しない
+	nodes := newNodes(nil)
+	nodes[0].Memory = 1024 * 1024
+	nodes[0].PortsAllocated["80/tcp"] = uuid.NewString()
+	tk := task.Task{ID: uuid.New(), Memory: 2 << 30, PortBindings: map[string]string{"80/tcp": "80"}}
+	if got := Unschedulable(f, tk, nodes); got != "0/1 nodes are available: insufficient memory on 1 node" {
+		t.Errorf("unexpected reason %q", got)
+	}
+
+	// パスに見える引数はファイルがなければ組み込みのプロファイルを探さない
+	for _, missing := range []string{filepath.Join(dir, "missing.yaml"), "missing.yml", "profiles/gpu"} {
+		if _, err := Load(missing); !os.IsNotExist(err) {
+			t.Errorf("%s: expected a not exist error, got %v", missing, err)
+		}
+	}
+	if _, err := Load("fastest"); err == nil || !strings.Contains(err.Error(), "unknown scheduler") {
+		t.Errorf("expected an unknown scheduler error, got %v", err)
+	}
+
+	for _, profile := range []string{
+		"name: bad\nfilters: [gpu]\n",
+		"name: bad\nfilters: [roundRobin]\n",
+		"name: bad\nscores:\n  - name: ports\n",
+		"name: bad\nscores:\n  - name: epvm\n    weight: -1\n",
+		"name: bad\ntimeout: 3\n",
+		"filters: [ports]\n",
+	} {
+		os.WriteFile(path, []byte(profile), 0644)
+		if _, err := LoadProfile(path); err == nil {
+			t.Errorf("expected an error for %q", profile)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package scheduler
+
+import (
+	"cube/labels"
+	"cube/node"
+	"cube/task"
+)
+
+// checkNodeSelector はノードのラベルがタスクのNodeSelectorに合うかを確認する
+func checkNodeSelector(t task.Task, n *node.Node) bool {
+	selector, err := labels.Parse(t.NodeSelector)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(n.Labels)
+}
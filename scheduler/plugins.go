@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/taints"
+	"cube/task"
+	"slices"
+)
+
+// 組み込みのプラグイン
+func init() {
+	Register("nodeSelector", newFilter("nodeSelector", func(t task.Task, n *node.Node, _ []*node.Node) string {
+		if !checkNodeSelector(t, n) {
+			return "node selector mismatch"
+		}
+		return ""
+	}))
+	Register("affinity", func() Plugin { return affinityPlugin{} })
+	Register("taints", func() Plugin { return taintsPlugin{} })
+	Register("resources", newFilter("resources", func(t task.Task, n *node.Node, _ []*node.Node) string {
+		return checkResources(t, n)
+	}))
+	Register("ports", newFilter("ports", func(t task.Task, n *node.Node, _ []*node.Node) string {
+		if !checkPorts(t, n) {
+			return "host port in use"
+		}
+		return ""
+	}))
+	Register("roundRobin", func() Plugin { return &roundRobinPlugin{} })
+	Register("epvm", func() Plugin { return epvmPlugin{} })
+	Register("mostAllocated", func() Plugin { return allocationPlugin{name: "mostAllocated", sign: 1} })
+	Register("leastAllocated", func() Plugin { return allocationPlugin{name: "leastAllocated", sign: -1} })
+}
+
+type filterFunc struct {
+	name   string
+	filter func(t task.Task, n *node.Node, nodes []*node.Node) string
+}
+
+func newFilter(name string, filter func(t task.Task, n *node.Node, nodes []*node.Node) string) func() Plugin {
+	return func() Plugin { return filterFunc{name: name, filter: filter} }
+}
+
+func (f filterFunc) Name() string { return f.name }
+
+func (f filterFunc) Filter(t task.Task, n *node.Node, nodes []*node.Node) string {
+	return f.filter(t, n, nodes)
+}
+
+// affinityPlugin は必須のアフィニティを満たさないノードを除き、優先のアフィニティを満たすノードに高い点数を付ける
+type affinityPlugin struct{}
+
+func (affinityPlugin) Name() string { return "affinity" }
+
+func (affinityPlugin) Filter(t task.Task, n *node.Node, nodes []*node.Node) string {
+	if !checkAffinity(t, n, nodes) {
+		return "affinity not satisfied"
+	}
+	return ""
+}
+
+func (affinityPlugin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, n := range nodes {
+		scores[n.Name] = affinityPreference(t, n)
+	}
+	return scores
+}
+
+// taintsPlugin は許容しないNoScheduleとNoExecuteのテイントがあるノードを除き、
+// 許容しないPreferNoScheduleのテイントが多いノードほど低い点数を付ける
+type taintsPlugin struct{}
+
+func (taintsPlugin) Name() string { return "taints" }
+
+func (taintsPlugin) Filter(t task.Task, n *node.Node, _ []*node.Node) string {
+	if !checkTaints(t, n) {
+		return "untolerated taint"
+	}
+	return ""
+}
+
+func (taintsPlugin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, n := range nodes {
+		scores[n.Name] = -float64(len(taints.Untolerated(n.Taints, t.Tolerations, taints.PreferNoSchedule)))
+	}
+	return scores
+}
+
+// roundRobinPlugin は前回選んだノードの次のノードに高い点数を付ける。ノードは名前の順に回る
+type roundRobinPlugin struct {
+	last string
+}
+
+func (p *roundRobinPlugin) Name() string { return "roundRobin" }
+
+func (p *roundRobinPlugin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	if len(nodes) == 0 {
+		return scores
+	}
+
+	names := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		names = append(names, n.Name)
+	}
+	slices.Sort(names)
+
+	next := names[0]
+	for _, name := range names {
+		if name > p.last {
+			next = name
+			break
+		}
+	}
+
+	for _, name := range names {
+		scores[name] = 0
+	}
+	scores[next] = 1
+
+	return scores
+}
+
+func (p *roundRobinPlugin) Bind(t task.Task, n *node.Node) {
+	p.last = n.Name
+}
+
+// epvmPlugin はE-PVMのアルゴリズムでメモリとCPUの負荷が増えないノードに高い点数を付ける
+type epvmPlugin struct{}
+
+func (epvmPlugin) Name() string { return "epvm" }
+
+func (epvmPlugin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, n := range nodes {
+		scores[n.Name] = -epvmCost(t, n)
+	}
+	return scores
+}
+
+// allocationPlugin は割り当て済みのCPUとメモリの割合で点数を付ける。
+// mostAllocatedは多いノードほど、leastAllocatedは少ないノードほど高い点数を付ける
+type allocationPlugin struct {
+	name string
+	sign float64
+}
+
+func (p allocationPlugin) Name() string { return p.name }
+
+func (p allocationPlugin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, n := range nodes {
+		scores[n.Name] = p.sign * allocatedFraction(t, n)
+	}
+	return scores
+}
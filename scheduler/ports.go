@@ -0,0 +1,18 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/task"
+)
+
+// checkPorts はタスクが要求するホストポートがノード上で他のタスクに使われていないかを確認する
+func checkPorts(t task.Task, n *node.Node) bool {
+	for _, port := range t.BoundHostPorts() {
+		owner, ok := n.PortsAllocated[port]
+		if ok && owner != t.ID.String() {
+			return false
+		}
+	}
+
+	return true
+}
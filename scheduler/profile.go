@@ -0,0 +1,192 @@
+package scheduler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile はFrameworkを組み立てる設定。YAMLのファイルから読む。
+// Filtersを省略するとDefaultFiltersを使う。Scoresの重みを省略すると1とし、0を指定したスコアは順位に影響しない
+type Profile struct {
+	Name    string        `yaml:"name"`
+	Filters []string      `yaml:"filters,omitempty"`
+	Scores  []ScoreConfig `yaml:"scores,omitempty"`
+}
+
+type ScoreConfig struct {
+	Name   string   `yaml:"name"`
+	Weight *float64 `yaml:"weight,omitempty"`
+}
+
+// 組み込みのプロファイルで優先のアフィニティとテイントに付ける重み。配置の方針の重みは1なので、
+// 方針よりも優先を満たすノードを選び、方針は優先が同じノードの間で順位を決める
+const preferenceWeight = 10
+
+// 組み込みのプロファイル。どれも優先のアフィニティとテイントも考慮する
+var profiles = map[string]Profile{
+	"roundrobin": builtin("roundrobin", "roundRobin"),
+	"epvm":       builtin("epvm", "epvm"),
+	"binpack":    builtin("binpack", "mostAllocated"),
+	"spread":     builtin("spread", "leastAllocated"),
+}
+
+func builtin(name string, strategy string) Profile {
+	strategyWeight, preferred := 1.0, float64(preferenceWeight)
+	return Profile{
+		Name: name,
+		Scores: []ScoreConfig{
+			{Name: strategy, Weight: &strategyWeight},
+			{Name: "affinity", Weight: &preferred},
+			{Name: "taints", Weight: &preferred},
+		},
+	}
+}
+
+// Profiles は組み込みのプロファイルの名前を返す
+func Profiles() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// New は組み込みのプロファイルからスケジューラを作る
+func New(name string) (*Framework, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scheduler %q, must be one of %v or a profile file", name, Profiles())
+	}
+
+	return p.Build()
+}
+
+// Load は組み込みのプロファイルの名前か、プロファイルのファイルのパスからスケジューラを作る
+func Load(nameOrPath string) (*Framework, error) {
+	if _, ok := profiles[nameOrPath]; ok {
+		return New(nameOrPath)
+	}
+	if _, err := os.Stat(nameOrPath); err != nil {
+		// パスに見える場合は名前の間違いではなくファイルがないことを伝える
+		if looksLikePath(nameOrPath) {
+			return nil, err
+		}
+		return New(nameOrPath)
+	}
+
+	return LoadProfile(nameOrPath)
+}
+
+func looksLikePath(s string) bool {
+	ext := filepath.Ext(s)
+	return strings.ContainsRune(s, '/') || strings.ContainsRune(s, filepath.Separator) || ext == ".yaml" || ext == ".yml"
+}
+
+// LoadProfile はプロファイルのファイルを読んでスケジューラを作る
+func LoadProfile(path string) (*Framework, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Profile
+	d := yaml.NewDecoder(bytes.NewReader(data))
+	d.KnownFields(true)
+	if err := d.Decode(&p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if p.Name == "" {
+		return nil, fmt.Errorf("%s: name is required", path)
+	}
+
+	f, err := p.Build()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return f, nil
+}
+
+// Build はプロファイルのプラグインを組み合わせたFrameworkを作る。
+// 同じ名前のプラグインは一つだけ作り、BindPluginを実装するものはノードを選んだ後に呼ぶ
+func (p Profile) Build() (*Framework, error) {
+	f := &Framework{Name: p.Name}
+
+	plugins := make(map[string]Plugin)
+	var order []string
+	get := func(name string) (Plugin, error) {
+		if pl, ok := plugins[name]; ok {
+			return pl, nil
+		}
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown plugin %q", name)
+		}
+		plugins[name] = factory()
+		order = append(order, name)
+		return plugins[name], nil
+	}
+
+	filters := p.Filters
+	if filters == nil {
+		filters = DefaultFilters
+	}
+
+	var errs []error
+	for _, name := range filters {
+		pl, err := get(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		filter, ok := pl.(FilterPlugin)
+		if !ok {
+			errs = append(errs, fmt.Errorf("plugin %q cannot be used as a filter", name))
+			continue
+		}
+		f.filters = append(f.filters, filter)
+	}
+
+	for _, s := range p.Scores {
+		weight := 1.0
+		if s.Weight != nil {
+			weight = *s.Weight
+		}
+		if weight < 0 {
+			errs = append(errs, fmt.Errorf("weight of %q must not be negative: %v", s.Name, weight))
+			continue
+		}
+		pl, err := get(s.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		score, ok := pl.(ScorePlugin)
+		if !ok {
+			errs = append(errs, fmt.Errorf("plugin %q cannot be used as a score", s.Name))
+			continue
+		}
+		f.scores = append(f.scores, weightedScore{plugin: score, weight: weight})
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	for _, name := range order {
+		if b, ok := plugins[name].(BindPlugin); ok {
+			f.binds = append(f.binds, b)
+		}
+	}
+
+	return f, nil
+}
@@ -0,0 +1,13 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/taints"
+	"cube/task"
+)
+
+// checkTaints はノードにタスクが許容しないNoScheduleかNoExecuteのテイントがないかを確認する
+func checkTaints(t task.Task, n *node.Node) bool {
+	return len(taints.Untolerated(n.Taints, t.Tolerations, taints.NoSchedule)) == 0 &&
+		len(taints.Untolerated(n.Taints, t.Tolerations, taints.NoExecute)) == 0
+}
@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/taints"
+	"cube/task"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSelectCandidateNodesHonorsTaints(t *testing.T) {
+	nodes := newNodes(nil, nil, nil)
+	nodes[0].Taints = []taints.Taint{{Key: "dedicated", Value: "team-a", Effect: taints.NoSchedule}}
+	nodes[1].Taints = []taints.Taint{{Key: "maintenance", Effect: taints.NoExecute}}
+
+	tests := []struct {
+		name        string
+		tolerations []taints.Toleration
+		want        string
+	}{
+		{"no tolerations", nil, "c"},
+		{"team a", []taints.Toleration{{Key: "dedicated", Value: "team-a"}}, "ac"},
+		{"everything", []taints.Toleration{{Operator: taints.OpExists}}, "abc"},
+	}
+
+	for _, name := range []string{"roundrobin", "epvm"} {
+		s, _ := New(name)
+		for _, tt := range tests {
+			tk := task.Task{ID: uuid.New(), Tolerations: tt.tolerations}
+			if got := nodeNames(s.SelectCandidateNodes(tk, nodes)); got != tt.want {
+				t.Errorf("%s %s: expected %s, got %s", name, tt.name, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestRoundRobinAvoidsPreferNoSchedule(t *testing.T) {
+	nodes := newNodes(nil, nil)
+	nodes[0].Taints = []taints.Taint{{Key: "spot", Effect: taints.PreferNoSchedule}}
+
+	r, _ := New("roundrobin")
+	pick := func(tk task.Task) *node.Node {
+		n := r.Pick(r.Score(tk, nodes), nodes)
+		r.Bind(tk, n)
+		return n
+	}
+
+	tk := task.Task{ID: uuid.New()}
+	for i := 0; i < len(nodes); i++ {
+		if got := pick(tk); got != nodes[1] {
+			t.Errorf("expected the untainted node b, got %s", got.Name)
+		}
+	}
+
+	tk.Tolerations = []taints.Toleration{{Key: "spot", Operator: taints.OpExists}}
+	picked := map[string]bool{}
+	for i := 0; i < len(nodes); i++ {
+		picked[pick(tk).Name] = true
+	}
+	if len(picked) != 2 {
+		t.Errorf("expected a tolerating task to use both nodes, got %v", picked)
+	}
+}
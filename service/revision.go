@@ -0,0 +1,195 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"cube/task"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	UpdateStateUpdating   = "updating"
+	UpdateStateCompleted  = "completed"
+	UpdateStateRolledBack = "rolled_back"
+	UpdateStatePaused     = "paused"
+)
+
+const (
+	FailureActionRollback = "rollback"
+	FailureActionPause    = "pause"
+)
+
+// 保持するリビジョンの数
+const maxHistory = 10
+
+// UpdateConfig はローリングアップデートの進め方。
+// MaxSurgeはReplicasを超えて起動してよいタスク数、MaxUnavailableは準備ができていなくてもよいタスク数
+type UpdateConfig struct {
+	MaxSurge       int
+	MaxUnavailable int
+	// 新しいリビジョンのタスクが失敗した時の動作。省略した場合はrollback
+	FailureAction string
+}
+
+func (u UpdateConfig) Surge() int {
+	// どちらも0だと更新が進まないので、1つずつ入れ替える
+	if u.MaxSurge == 0 && u.MaxUnavailable == 0 {
+		return 1
+	}
+	return u.MaxSurge
+}
+
+func (u UpdateConfig) Unavailable() int {
+	return u.MaxUnavailable
+}
+
+func (u UpdateConfig) OnFailure() string {
+	if u.FailureAction == "" {
+		return FailureActionRollback
+	}
+	return u.FailureAction
+}
+
+func (u UpdateConfig) validate() error {
+	var errs []error
+
+	if u.MaxSurge < 0 || u.MaxUnavailable < 0 {
+		errs = append(errs, errors.New("MaxSurge and MaxUnavailable must not be negative"))
+	}
+
+	switch u.FailureAction {
+	case "", FailureActionRollback, FailureActionPause:
+	default:
+		errs = append(errs, fmt.Errorf("unknown FailureAction %q", u.FailureAction))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Revision はある時点のサービスの仕様
+type Revision struct {
+	Number   int
+	Image    string
+	Template task.Task
+	// ImageとTemplateのハッシュ。同じ仕様のリビジョンで作ったタスクを見分けるのに使う
+	Hash      string
+	CreatedAt time.Time
+}
+
+func specHash(image string, template task.Task) string {
+	b, _ := json.Marshal(struct {
+		Image    string
+		Template task.Task
+	}{image, template})
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// hash はリビジョンのハッシュを返す。Hashを記録する前に作ったリビジョンはその場で計算する
+func (r *Revision) hash() string {
+	if r.Hash == "" {
+		return specHash(r.Image, r.Template)
+	}
+	return r.Hash
+}
+
+// Matches はリビジョンrevで作ったタスクが今のリビジョンと同じ仕様かを返す。
+// ロールバックで同じ仕様に戻した場合、そのタスクは入れ替えずにそのまま使う
+func (s *Service) Matches(rev int) bool {
+	if rev == s.Revision {
+		return true
+	}
+
+	var current, r *Revision
+	for i := range s.History {
+		switch s.History[i].Number {
+		case s.Revision:
+			current = &s.History[i]
+		case rev:
+			r = &s.History[i]
+		}
+	}
+
+	return current != nil && r != nil && r.hash() == current.hash()
+}
+
+// SameSpec はoがsと同じタスクを作るかを返す
+func (s *Service) SameSpec(o *Service) bool {
+	if s.Image != o.Image {
+		return false
+	}
+
+	a, _ := json.Marshal(s.Template)
+	b, _ := json.Marshal(o.Template)
+
+	return bytes.Equal(a, b)
+}
+
+// Apply はnextのReplicas、UpdateConfigと仕様をsに反映する。
+// 仕様が変わった場合は新しいリビジョンを作り、ローリングアップデートを始める
+func (s *Service) Apply(next *Service) {
+	s.Replicas = next.Replicas
+	s.UpdateConfig = next.UpdateConfig
+
+	if s.Revision != 0 && s.SameSpec(next) {
+		return
+	}
+
+	s.setSpec(next.Image, next.Template, UpdateStateUpdating, "")
+}
+
+// Rollback はリビジョンtoの仕様を新しいリビジョンとして適用する。toが0の場合は一つ前のリビジョンに戻す
+func (s *Service) Rollback(to int) error {
+	var target *Revision
+	for i := len(s.History) - 1; i >= 0; i-- {
+		r := &s.History[i]
+		if (to == 0 && r.Number < s.Revision) || r.Number == to {
+			target = r
+			break
+		}
+	}
+
+	if target == nil {
+		if to == 0 {
+			return fmt.Errorf("service %s has no previous revision", s.Name)
+		}
+		return fmt.Errorf("service %s has no revision %d", s.Name, to)
+	}
+
+	s.setSpec(target.Image, target.Template, UpdateStateUpdating, fmt.Sprintf("rolling back to revision %d", target.Number))
+
+	return nil
+}
+
+func (s *Service) setSpec(image string, template task.Task, state, message string) {
+	next := 1
+	for _, r := range s.History {
+		next = max(next, r.Number+1)
+	}
+
+	s.Image = image
+	s.Template = template
+	s.Revision = next
+	s.History = append(s.History, Revision{
+		Number:    next,
+		Image:     image,
+		Template:  template,
+		Hash:      specHash(image, template),
+		CreatedAt: time.Now().UTC(),
+	})
+	if len(s.History) > maxHistory {
+		s.History = s.History[len(s.History)-maxHistory:]
+	}
+
+	// 最初のリビジョンは更新ではない
+	if next == 1 {
+		state = ""
+	}
+	s.Status.UpdateState = state
+	s.Status.Message = message
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"cube/task"
+	"testing"
+)
+
+func TestApplyCreatesRevisionOnSpecChange(t *testing.T) {
+	s := Service{Name: "web"}
+	s.Apply(&Service{Image: "web:1", Replicas: 2})
+
+	if s.Revision != 1 || s.Status.UpdateState != "" {
+		t.Fatalf("expected first revision without an update, got %d %q", s.Revision, s.Status.UpdateState)
+	}
+
+	s.Apply(&Service{Image: "web:1", Replicas: 5})
+	if s.Revision != 1 || s.Replicas != 5 {
+		t.Errorf("expected scaling not to create a revision, got revision %d replicas %d", s.Revision, s.Replicas)
+	}
+
+	s.Apply(&Service{Image: "web:1", Replicas: 5, Template: task.Task{Env: []string{"FOO=bar"}}})
+	if s.Revision != 2 || s.Status.UpdateState != UpdateStateUpdating {
+		t.Errorf("expected a template change to start an update, got revision %d %q", s.Revision, s.Status.UpdateState)
+	}
+	if len(s.History) != 2 {
+		t.Errorf("expected 2 revisions in history, got %d", len(s.History))
+	}
+}
+
+func TestRollback(t *testing.T) {
+	s := Service{Name: "web"}
+	for _, image := range []string{"web:1", "web:2", "web:3"} {
+		s.Apply(&Service{Image: image, Replicas: 1})
+	}
+
+	if err := s.Rollback(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.Image != "web:2" || s.Revision != 4 {
+		t.Errorf("expected web:2 as revision 4, got %s as %d", s.Image, s.Revision)
+	}
+
+	if err := s.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if s.Image != "web:1" || s.Revision != 5 {
+		t.Errorf("expected web:1 as revision 5, got %s as %d", s.Image, s.Revision)
+	}
+
+	if err := s.Rollback(42); err == nil {
+		t.Errorf("expected an error for an unknown revision")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	s := Service{Name: "web"}
+	for _, image := range []string{"web:1", "web:2"} {
+		s.Apply(&Service{Image: image, Replicas: 1})
+	}
+	if err := s.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Matches(1) || !s.Matches(3) || s.Matches(2) {
+		t.Errorf("expected revisions 1 and 3 to match the current spec, got 1=%v 2=%v 3=%v", s.Matches(1), s.Matches(2), s.Matches(3))
+	}
+
+	// Hashを記録する前のリビジョンも仕様から比べる
+	s.History[0].Hash = ""
+	if !s.Matches(1) {
+		t.Errorf("expected a revision without a hash to match by its spec")
+	}
+}
+
+func TestHistoryIsTrimmed(t *testing.T) {
+	s := Service{Name: "web"}
+	for i := range maxHistory + 5 {
+		s.Apply(&Service{Image: "web", Template: task.Task{Cmd: []string{"serve", string(rune('a' + i))}}})
+	}
+
+	if len(s.History) != maxHistory {
+		t.Fatalf("expected %d revisions, got %d", maxHistory, len(s.History))
+	}
+	if s.History[len(s.History)-1].Number != s.Revision {
+		t.Errorf("expected the latest revision to be kept")
+	}
+}
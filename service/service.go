@@ -0,0 +1,81 @@
+package service
+
+import (
+	"cube/task"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Service はTemplateから作ったタスクをReplicas個動かし続けるためのリソース
+type Service struct {
+	ID       uuid.UUID
+	Name     string
+	Image    string
+	Replicas int
+	// タスクの仕様。ID、Name、Imageと実行時の状態はサービスが設定する
+	Template     task.Task
+	UpdateConfig UpdateConfig
+	// ImageかTemplateを変更するたびに増える
+	Revision  int
+	History   []Revision
+	Status    Status
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Status はマネージャが調整ループで記録するサービスの状態
+type Status struct {
+	Replicas        int
+	ReadyReplicas   int
+	UpdatedReplicas int
+	UpdateState     string
+	Message         string
+}
+
+// NewTask はTemplateからサービスのタスクを一つ作る
+func (s *Service) NewTask() task.Task {
+	t := s.Template.Spec()
+
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%s", s.Name, t.ID.String()[:8])
+	t.Image = s.Image
+	t.ServiceID = s.ID
+	t.ServiceRevision = s.Revision
+
+	return t
+}
+
+// タスク名はコンテナ名にも使うので、Dockerのコンテナ名として使える文字に限る
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func (s *Service) Validate() error {
+
+	var errs []error
+
+	if !validName.MatchString(s.Name) {
+		errs = append(errs, fmt.Errorf("Name must match %s: %q", validName, s.Name))
+	}
+
+	if s.Replicas < 0 {
+		errs = append(errs, fmt.Errorf("Replicas must not be negative: %d", s.Replicas))
+	}
+
+	if err := s.UpdateConfig.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if s.Template.IsJob() {
+		errs = append(errs, errors.New("jobs cannot be run as a service"))
+	}
+
+	t := s.NewTask()
+	if err := t.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"cube/task"
+	"testing"
+)
+
+func TestNewTask(t *testing.T) {
+	s := Service{
+		Name:  "web",
+		Image: "nginx",
+		Template: task.Task{
+			Env:         []string{"FOO=bar"},
+			State:       task.Running,
+			ContainerID: "stale",
+		},
+	}
+
+	a, b := s.NewTask(), s.NewTask()
+	if a.ID == b.ID || a.Name == b.Name {
+		t.Errorf("expected tasks with unique IDs and names, got %s and %s", a.Name, b.Name)
+	}
+	if a.Image != "nginx" || a.Env[0] != "FOO=bar" || a.ServiceID != s.ID {
+		t.Errorf("expected task built from the template, got %+v", a)
+	}
+	if a.State != task.Pending || a.ContainerID != "" {
+		t.Errorf("expected a fresh pending task, got %v %q", a.State, a.ContainerID)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		service Service
+		wantErr bool
+	}{
+		{"valid", Service{Name: "web", Image: "nginx", Replicas: 3}, false},
+		{"no name", Service{Image: "nginx"}, true},
+		{"invalid name", Service{Name: "web app", Image: "nginx"}, true},
+		{"negative replicas", Service{Name: "web", Image: "nginx", Replicas: -1}, true},
+		{"no image", Service{Name: "web"}, true},
+		{"job", Service{Name: "etl", Image: "etl", Template: task.Task{Mode: task.ModeJob}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.service.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
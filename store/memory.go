@@ -2,6 +2,7 @@ package store
 
 import (
 	"fmt"
+	"sort"
 )
 
 type InMemoryTaskStore[T any] struct {
@@ -42,3 +43,36 @@ func (i *InMemoryTaskStore[T]) Put(key string, value T) error {
 	i.Db[key] = value
 	return nil
 }
+
+func (i *InMemoryTaskStore[T]) Delete(key string) error {
+	if _, ok := i.Db[key]; !ok {
+		return fmt.Errorf("key %s not found", key)
+	}
+
+	delete(i.Db, key)
+	return nil
+}
+
+func (i *InMemoryTaskStore[T]) ListPage(after string, limit int, filter func(T) bool) (vs []T, next string, err error) {
+	keys := make([]string, 0, len(i.Db))
+	for k := range i.Db {
+		if k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := i.Db[k]
+		if filter != nil && !filter(v) {
+			continue
+		}
+		if limit > 0 && len(vs) == limit {
+			return vs, next, nil
+		}
+		vs = append(vs, v)
+		next = k
+	}
+
+	return vs, "", nil
+}
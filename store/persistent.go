@@ -133,3 +133,47 @@ func NewPersistentTaskStore[T any](file string, mode os.FileMode, bucket string)
 func (p *PersistentTaskStore[T]) Close() error {
 	return p.Db.Close()
 }
+
+func (p *PersistentTaskStore[T]) Delete(key string) error {
+
+	return p.Db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(p.Bucket))
+		if b.Get([]byte(key)) == nil {
+			return fmt.Errorf("key %s not found", key)
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
+
+func (p *PersistentTaskStore[T]) ListPage(after string, limit int, filter func(T) bool) (vs []T, next string, err error) {
+
+	err = p.Db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(p.Bucket)).Cursor()
+
+		k, v := c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, v = c.Next()
+		}
+		for ; k != nil; k, v = c.Next() {
+			var ret T
+			if err := json.Unmarshal(v, &ret); err != nil {
+				return err
+			}
+			if filter != nil && !filter(ret) {
+				continue
+			}
+			if limit > 0 && len(vs) == limit {
+				return nil
+			}
+
+			vs = append(vs, ret)
+			next = string(k)
+		}
+
+		next = ""
+		return nil
+	})
+
+	return
+}
@@ -5,4 +5,8 @@ type Store[T any] interface {
 	Get(key string) (T, error)
 	List() ([]T, error)
 	Count() (int, error)
+	Delete(key string) error
+	// ListPage はキーの順にafterより後ろにあり、filterに合う値をlimit個まで返す。
+	// limitが0の場合は残りをすべて返す。nextは続きを読むためのカーソルで、続きがない場合は空になる
+	ListPage(after string, limit int, filter func(T) bool) (vs []T, next string, err error)
 }
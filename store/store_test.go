@@ -0,0 +1,65 @@
+package store
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func newStores(t *testing.T) map[string]Store[int] {
+	t.Helper()
+
+	p, err := NewPersistentTaskStore[int](filepath.Join(t.TempDir(), "test.db"), 0600, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+
+	return map[string]Store[int]{
+		"memory":     NewInMemoryTaskStore[int](),
+		"persistent": p,
+	}
+}
+
+func TestListPage(t *testing.T) {
+	for name, s := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for i := range 10 {
+				s.Put("key"+strconv.Itoa(i), i)
+			}
+			even := func(v int) bool { return v%2 == 0 }
+
+			var got []int
+			cursor, pages := "", 0
+			for {
+				vs, next, err := s.ListPage(cursor, 2, even)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, vs...)
+				pages++
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+
+			if len(got) != 5 || got[0] != 0 || got[4] != 8 {
+				t.Errorf("expected the even values in key order, got %v", got)
+			}
+			if pages != 3 {
+				t.Errorf("expected 3 pages, got %d", pages)
+			}
+
+			all, next, _ := s.ListPage("", 0, nil)
+			if len(all) != 10 || next != "" {
+				t.Errorf("expected all values without a cursor, got %d and %q", len(all), next)
+			}
+
+			exact, next, _ := s.ListPage("", 5, even)
+			if len(exact) != 5 || next != "" {
+				t.Errorf("expected no cursor when the last page is full, got %q", next)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package taints
+
+import (
+	"cube/labels"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// 許容しないタスクを新しく置かない
+	NoSchedule = "NoSchedule"
+	// 許容しないタスクはできるだけ置かない
+	PreferNoSchedule = "PreferNoSchedule"
+	// 許容しないタスクを置かず、動いているタスクも別のノードに移す
+	NoExecute = "NoExecute"
+)
+
+var effects = []string{NoSchedule, PreferNoSchedule, NoExecute}
+
+const (
+	OpEqual  = "Equal"
+	OpExists = "Exists"
+)
+
+// Taint はノードに付けて、許容するタスク以外を遠ざける
+type Taint struct {
+	Key    string
+	Value  string `json:",omitempty"`
+	Effect string
+}
+
+func (t Taint) String() string {
+	if t.Value == "" {
+		return t.Key + ":" + t.Effect
+	}
+
+	return t.Key + "=" + t.Value + ":" + t.Effect
+}
+
+func (t Taint) Validate() error {
+	if err := labels.ValidateKey(t.Key); err != nil {
+		return err
+	}
+	if err := labels.ValidateValue(t.Value); err != nil {
+		return err
+	}
+	if !slices.Contains(effects, t.Effect) {
+		return fmt.Errorf("unknown taint effect %q", t.Effect)
+	}
+
+	return nil
+}
+
+// Parse は"key=value:NoSchedule"か"key:NoSchedule"の形式のテイントを読む
+func Parse(s string) (Taint, error) {
+	kv, effect, ok := strings.Cut(s, ":")
+	if !ok {
+		return Taint{}, fmt.Errorf("invalid taint %q, must be KEY[=VALUE]:EFFECT", s)
+	}
+
+	key, value, _ := strings.Cut(kv, "=")
+	t := Taint{Key: key, Value: value, Effect: effect}
+	if err := t.Validate(); err != nil {
+		return Taint{}, fmt.Errorf("invalid taint %q: %v", s, err)
+	}
+
+	return t, nil
+}
+
+// Update はaddを加え、removeに合うテイントを除いた一覧を返す。
+// キーと効果が同じテイントは置き換え、removeのEffectが空ならキーが同じものをすべて除く
+func Update(taints, add, remove []Taint) ([]Taint, error) {
+	var errs []error
+	for _, t := range add {
+		if err := t.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	var updated []Taint
+	for _, t := range taints {
+		removed := slices.ContainsFunc(remove, func(r Taint) bool {
+			return r.Key == t.Key && (r.Effect == "" || r.Effect == t.Effect)
+		})
+		replaced := slices.ContainsFunc(add, func(a Taint) bool {
+			return a.Key == t.Key && a.Effect == t.Effect
+		})
+		if !removed && !replaced {
+			updated = append(updated, t)
+		}
+	}
+
+	return append(updated, add...), nil
+}
+
+// Toleration はタスクが許容するテイント。
+// Keyが空でOperatorがExistsならすべてのテイントを、Effectが空ならすべての効果を許容する
+type Toleration struct {
+	Key      string `json:",omitempty"`
+	Operator string `json:",omitempty"`
+	Value    string `json:",omitempty"`
+	Effect   string `json:",omitempty"`
+}
+
+func (tol Toleration) Tolerates(t Taint) bool {
+	if tol.Effect != "" && tol.Effect != t.Effect {
+		return false
+	}
+	if tol.Key == "" {
+		return tol.Operator == OpExists
+	}
+	if tol.Key != t.Key {
+		return false
+	}
+	if tol.Operator == OpExists {
+		return true
+	}
+
+	return tol.Value == t.Value
+}
+
+func (tol Toleration) Validate() error {
+	switch tol.Operator {
+	case "", OpEqual:
+		if tol.Key == "" {
+			return errors.New("Key is required unless Operator is Exists")
+		}
+	case OpExists:
+		if tol.Value != "" {
+			return errors.New("Value must be empty when Operator is Exists")
+		}
+	default:
+		return fmt.Errorf("unknown toleration operator %q", tol.Operator)
+	}
+
+	if tol.Key != "" {
+		if err := labels.ValidateKey(tol.Key); err != nil {
+			return err
+		}
+	}
+	if tol.Effect != "" && !slices.Contains(effects, tol.Effect) {
+		return fmt.Errorf("unknown taint effect %q", tol.Effect)
+	}
+
+	return labels.ValidateValue(tol.Value)
+}
+
+// Untolerated はtaintsのうちeffectの効果を持ち、tolerationsのどれにも許容されないものを返す
+func Untolerated(taints []Taint, tolerations []Toleration, effect string) []Taint {
+	var untolerated []Taint
+	for _, t := range taints {
+		if t.Effect != effect {
+			continue
+		}
+		if !slices.ContainsFunc(tolerations, func(tol Toleration) bool { return tol.Tolerates(t) }) {
+			untolerated = append(untolerated, t)
+		}
+	}
+
+	return untolerated
+}
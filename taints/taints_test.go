@@ -0,0 +1,95 @@
+package taints
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Taint
+	}{
+		{"dedicated=team-a:NoSchedule", Taint{Key: "dedicated", Value: "team-a", Effect: NoSchedule}},
+		{"maintenance:NoExecute", Taint{Key: "maintenance", Effect: NoExecute}},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("%s: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.input, tt.want, got)
+		}
+		if got.String() != tt.input {
+			t.Errorf("expected %s, got %s", tt.input, got)
+		}
+	}
+
+	for _, input := range []string{"dedicated=team-a", "dedicated:Sometimes", ":NoSchedule"} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestTolerates(t *testing.T) {
+	taint := Taint{Key: "dedicated", Value: "team-a", Effect: NoSchedule}
+
+	tests := []struct {
+		name string
+		tol  Toleration
+		want bool
+	}{
+		{"equal", Toleration{Key: "dedicated", Value: "team-a"}, true},
+		{"other value", Toleration{Key: "dedicated", Value: "team-b"}, false},
+		{"exists", Toleration{Key: "dedicated", Operator: OpExists}, true},
+		{"same effect", Toleration{Key: "dedicated", Value: "team-a", Effect: NoSchedule}, true},
+		{"other effect", Toleration{Key: "dedicated", Value: "team-a", Effect: NoExecute}, false},
+		{"all taints", Toleration{Operator: OpExists}, true},
+		{"other key", Toleration{Key: "gpu", Operator: OpExists}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.tol.Tolerates(taint); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	current := []Taint{
+		{Key: "dedicated", Value: "team-a", Effect: NoSchedule},
+		{Key: "dedicated", Value: "team-a", Effect: NoExecute},
+		{Key: "gpu", Effect: PreferNoSchedule},
+	}
+
+	updated, err := Update(current,
+		[]Taint{{Key: "gpu", Value: "a100", Effect: PreferNoSchedule}},
+		[]Taint{{Key: "dedicated"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 1 || updated[0].String() != "gpu=a100:PreferNoSchedule" {
+		t.Errorf("unexpected taints %v", updated)
+	}
+
+	if _, err := Update(current, []Taint{{Key: "gpu", Effect: "Never"}}, nil); err == nil {
+		t.Error("expected an unknown effect to be rejected")
+	}
+}
+
+func TestUntolerated(t *testing.T) {
+	ts := []Taint{
+		{Key: "dedicated", Value: "team-a", Effect: NoSchedule},
+		{Key: "maintenance", Effect: NoExecute},
+	}
+	tols := []Toleration{{Key: "dedicated", Value: "team-a"}}
+
+	if got := Untolerated(ts, tols, NoSchedule); len(got) != 0 {
+		t.Errorf("expected the NoSchedule taint to be tolerated, got %v", got)
+	}
+	if got := Untolerated(ts, tols, NoExecute); len(got) != 1 || got[0].Key != "maintenance" {
+		t.Errorf("expected the NoExecute taint not to be tolerated, got %v", got)
+	}
+}
@@ -0,0 +1,59 @@
+package task
+
+import (
+	"cube/labels"
+	"errors"
+	"fmt"
+)
+
+// Affinity はタスクを置くノードをラベルで選ぶ条件
+type Affinity struct {
+	// ノードのラベルに対する条件
+	Node []AffinityTerm
+	// 同じノードで動いているタスクのラベルに対する条件。合うタスクがいるノードに置く
+	Task []AffinityTerm
+	// 合うタスクがいないノードに置く。サービスのレプリカを別々のノードに分けるときに使う
+	AntiTask []AffinityTerm
+}
+
+// AffinityTerm はRequiredなら必ず守り、そうでなければWeight(1から100)の重みで優先する
+type AffinityTerm struct {
+	Selector string
+	Required bool
+	Weight   int
+}
+
+func (a *Affinity) Validate() error {
+	if a == nil {
+		return nil
+	}
+
+	var errs []error
+	check := func(name string, terms []AffinityTerm) {
+		for i, term := range terms {
+			if err := term.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("Affinity.%s[%d]: %v", name, i, err))
+			}
+		}
+	}
+	check("Node", a.Node)
+	check("Task", a.Task)
+	check("AntiTask", a.AntiTask)
+
+	return errors.Join(errs...)
+}
+
+func (a AffinityTerm) validate() error {
+	s, err := labels.Parse(a.Selector)
+	if err != nil {
+		return err
+	}
+	if s.Empty() {
+		return errors.New("Selector is required")
+	}
+	if !a.Required && (a.Weight < 1 || a.Weight > 100) {
+		return fmt.Errorf("Weight must be between 1 and 100: %d", a.Weight)
+	}
+
+	return nil
+}
@@ -3,26 +3,55 @@ package task
 import "github.com/docker/go-connections/nat"
 
 type Config struct {
-	Name          string
-	AttachStdin   bool
-	AttachStdout  bool
-	AttachStderr  bool
-	ExposedPorts  nat.PortSet
-	Cmd           []string
-	Image         string
-	Cpu           float64
-	Memory        int64
-	Disk          int64
-	Env           []string
-	RestartPolicy string
+	Name         string
+	AttachStdin  bool
+	AttachStdout bool
+	AttachStderr bool
+	ExposedPorts nat.PortSet
+	PortBindings nat.PortMap
+	Cmd          []string
+	Image        string
+	Cpu          float64
+	Memory       int64
+	Disk         int64
+	Env          []string
+	Volumes      []Volume
+	VolumePolicy string
 }
 
 func NewConfig(t *Task) Config {
 
+	exposed := nat.PortSet{}
+	for p := range t.ExposedPorts {
+		exposed[p] = struct{}{}
+	}
+
+	var bindings nat.PortMap
+	if len(t.PortBindings) > 0 {
+		bindings = nat.PortMap{}
+		for p, hp := range t.PortBindings {
+			port := nat.Port(p)
+			if proto, num := nat.SplitProtoPort(p); num != "" {
+				port, _ = nat.NewPort(proto, num)
+			}
+			bindings[port] = append(bindings[port], nat.PortBinding{HostPort: hp})
+			// バインドするポートはExposeしておく必要がある
+			exposed[port] = struct{}{}
+		}
+	}
+
 	return Config{
 		Name:         t.Name,
+		ExposedPorts: exposed,
+		PortBindings: bindings,
+		Cmd:          t.Cmd,
 		Image:        t.Image,
-		ExposedPorts: t.ExposedPorts,
+		Cpu:          t.Cpu,
+		Memory:       int64(t.Memory),
+		Disk:         int64(t.Disk),
+		Env:          t.Env,
+		Volumes:      t.Volumes,
+		VolumePolicy: t.VolumePolicy,
 	}
 
 }
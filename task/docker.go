@@ -2,134 +2,333 @@ package task
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"log"
 	"math"
 	"os"
+	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 )
 
+var _ Runtime = &Docker{}
+
+const volumePolicyLabel = "cube.volume-policy"
+
 type Docker struct {
 	Client *client.Client
-	Config Config
 }
 
-func NewDocker(c Config) *Docker {
-
-	dc, _ := client.NewClientWithOpts(client.FromEnv)
+func NewDocker() (*Docker, error) {
 
-	return &Docker{Client: dc, Config: c}
-}
+	dc, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, err
+	}
 
-type DockerResult struct {
-	Error       error
-	Action      string
-	ContainerId string
-	Result      string
+	return &Docker{Client: dc}, nil
 }
 
-func (d *Docker) Run() DockerResult {
+func (d *Docker) Run(c Config) RuntimeResult {
 	ctx := context.Background()
 	reader, err := d.Client.ImagePull(
-		ctx, d.Config.Image, image.PullOptions{},
+		ctx, c.Image, image.PullOptions{},
 	)
 
 	if err != nil {
 		log.Printf("error pulling image %s", err)
-		return DockerResult{Error: err}
+		return RuntimeResult{Error: err}
 	}
 
 	io.Copy(os.Stdout, reader)
 
-	rp := container.RestartPolicy{
-		Name: container.RestartPolicyMode(d.Config.RestartPolicy),
-	}
-
 	r := container.Resources{
-		Memory:   d.Config.Memory,
-		NanoCPUs: int64(d.Config.Cpu * math.Pow(10, 9)),
+		Memory:   c.Memory,
+		NanoCPUs: int64(c.Cpu * math.Pow(10, 9)),
 	}
 
 	cc := container.Config{
-		Image:        d.Config.Image,
+		Image:        c.Image,
 		Tty:          false,
-		Env:          d.Config.Env,
-		ExposedPorts: d.Config.ExposedPorts,
+		Env:          c.Env,
+		Cmd:          c.Cmd,
+		ExposedPorts: c.ExposedPorts,
+		Labels:       map[string]string{volumePolicyLabel: c.VolumePolicy},
 	}
 
 	hc := container.HostConfig{
-		RestartPolicy:   rp,
+		// 再起動はマネージャーがリスタートポリシーとバックオフに従って行うので、Docker側では再起動しない
+		RestartPolicy:   container.RestartPolicy{Name: container.RestartPolicyDisabled},
 		Resources:       r,
-		PublishAllPorts: true,
+		PortBindings:    c.PortBindings,
+		PublishAllPorts: len(c.PortBindings) == 0,
+		Mounts:          dockerMounts(c.Volumes),
 	}
 
 	// TODO: コンテナ名が重複している場合はCreateを飛ばす（stopしてる時など）
-	resp, err := d.Client.ContainerCreate(ctx, &cc, &hc, nil, nil, d.Config.Name)
+	resp, err := d.Client.ContainerCreate(ctx, &cc, &hc, nil, nil, c.Name)
 	if err != nil {
 		log.Printf("creating container error %s", err)
-		return DockerResult{Error: err}
+		return RuntimeResult{Error: err}
 	}
 
 	err = d.Client.ContainerStart(ctx, resp.ID, container.StartOptions{})
 	if err != nil {
 		log.Printf("starting container error %s", err)
-		return DockerResult{Error: err}
+		return RuntimeResult{Error: err}
 	}
 
-	// d.Config.Runtime.ContainerID = resp.ID
-
-	out, err := d.Client.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true})
-	if err != nil {
-		log.Printf("getting logs for container error %s", err)
-		return DockerResult{Error: err}
-	}
-
-	stdcopy.StdCopy(os.Stdout, os.Stderr, out)
-	return DockerResult{ContainerId: resp.ID, Action: "start", Result: "success"}
+	return RuntimeResult{ContainerId: resp.ID, Action: "start", Result: "success"}
 
 }
 
-func (d *Docker) Stop(id string) DockerResult {
+func (d *Docker) Stop(id string) RuntimeResult {
 
 	log.Printf("stopping container %s", id)
 	ctx := context.Background()
-	err := d.Client.ContainerStop(ctx, id, container.StopOptions{})
+
+	// 削除後はボリュームの情報が取れなくなるので先に調べておく
+	info, err := d.Client.ContainerInspect(ctx, id)
+	if err != nil {
+		log.Printf("error inspecting container %s", err)
+		return RuntimeResult{Error: err}
+	}
+	var policy string
+	if info.Config != nil {
+		policy = info.Config.Labels[volumePolicyLabel]
+	}
+	deleteVolumes := policy == VolumePolicyDelete
+
+	err = d.Client.ContainerStop(ctx, id, container.StopOptions{})
 	if err != nil {
 		log.Printf("error stopping container %s", err)
-		return DockerResult{Error: err}
+		return RuntimeResult{Error: err}
 	}
 
 	err = d.Client.ContainerRemove(ctx, id, container.RemoveOptions{
-		RemoveVolumes: true,
+		RemoveVolumes: policy != VolumePolicyRetain,
 		RemoveLinks:   false,
 		Force:         false,
 	})
 	if err != nil {
 		log.Printf("error removing container %s", err)
-		return DockerResult{Error: err}
+		return RuntimeResult{Error: err}
+	}
+
+	if deleteVolumes {
+		for _, m := range info.Mounts {
+			if m.Type != mount.TypeVolume || m.Name == "" {
+				continue
+			}
+
+			if err := d.Client.VolumeRemove(ctx, m.Name, false); err != nil {
+				log.Printf("error removing volume %s: %s", m.Name, err)
+			}
+		}
 	}
 
-	return DockerResult{Action: "stop", Result: "success", Error: nil}
+	return RuntimeResult{Action: "stop", Result: "success", Error: nil}
 
 }
 
-func (d *Docker) Inspect(containerID string) DockerInspectResponse {
-	dc, _ := client.NewClientWithOpts(client.FromEnv)
+func (d *Docker) Remove(id string) RuntimeResult {
+
+	log.Printf("removing container %s", id)
+	ctx := context.Background()
+
+	err := d.Client.ContainerStop(ctx, id, container.StopOptions{})
+	if err != nil {
+		log.Printf("error stopping container %s", err)
+		return RuntimeResult{Error: err}
+	}
+
+	// 匿名ボリュームも残す。不要になったものはdocker volume pruneで消す
+	err = d.Client.ContainerRemove(ctx, id, container.RemoveOptions{})
+	if err != nil {
+		log.Printf("error removing container %s", err)
+		return RuntimeResult{Error: err}
+	}
+
+	return RuntimeResult{Action: "remove", Result: "success"}
+}
+
+func (d *Docker) Inspect(containerID string) InspectResult {
 	ctx := context.Background()
-	resp, err := dc.ContainerInspect(ctx, containerID)
+	resp, err := d.Client.ContainerInspect(ctx, containerID)
 	if err != nil {
 		log.Printf("Error inspecting container: %s\n", err)
-		return DockerInspectResponse{Error: err}
+		return InspectResult{Error: err}
+	}
+
+	result := InspectResult{}
+	if resp.State != nil {
+		result.Status = string(resp.State.Status)
+		result.ExitCode = resp.State.ExitCode
+		result.FinishedAt, _ = time.Parse(time.RFC3339Nano, resp.State.FinishedAt)
+	}
+	if resp.NetworkSettings != nil {
+		result.Ports = resp.NetworkSettings.Ports
+	}
+
+	return result
+}
+
+// Logs はコンテナのログを返す。返したReadCloserを閉じるとDockerからの読み込みも止める
+func (d *Docker) Logs(containerID string, opts LogsOptions) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out, err := d.Client.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: opts.Stdout,
+		ShowStderr: opts.Stderr,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
 	}
 
-	return DockerInspectResponse{Container: &resp}
+	// コンテナはTTYなしで作成しているので、stdout/stderrが多重化されている
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, out)
+		out.Close()
+		pw.CloseWithError(err)
+	}()
+
+	return &cancelReader{PipeReader: pr, cancel: cancel}, nil
+}
+
+// cancelReader は閉じたときにDockerへのリクエストも取り消す
+type cancelReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Close() error {
+	c.cancel()
+	return c.PipeReader.Close()
+}
+
+func (d *Docker) Stats(containerID string) StatsResult {
+	ctx := context.Background()
+	resp, err := d.Client.ContainerStatsOneShot(ctx, containerID)
+	if err != nil {
+		return StatsResult{Error: err}
+	}
+	defer resp.Body.Close()
+
+	var stats container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return StatsResult{Error: err}
+	}
+
+	return StatsResult{
+		CpuUsage:    stats.CPUStats.CPUUsage.TotalUsage,
+		MemoryUsage: stats.MemoryStats.Usage,
+		MemoryLimit: stats.MemoryStats.Limit,
+	}
+}
+
+func dockerMounts(volumes []Volume) []mount.Mount {
+	var mounts []mount.Mount
+	for _, v := range volumes {
+		m := mount.Mount{
+			Type:     mount.Type(v.Type),
+			Source:   v.Source,
+			Target:   v.Target,
+			ReadOnly: v.ReadOnly,
+		}
+
+		if v.Type == VolumeTypeTmpfs && v.Size > 0 {
+			m.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: v.Size}
+		}
+
+		mounts = append(mounts, m)
+	}
+
+	return mounts
+}
+
+func (d *Docker) Exec(containerID string, opts ExecOptions) (*ExecSession, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	created, err := d.Client.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          opts.Cmd,
+		Tty:          opts.Tty,
+		AttachStdin:  opts.Stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	attach, err := d.Client.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{Tty: opts.Tty})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s := &ExecSession{
+		Stdin: &hijackedStdin{attach},
+		resize: func(height, width uint) error {
+			return d.Client.ContainerExecResize(ctx, created.ID, container.ResizeOptions{Height: height, Width: width})
+		},
+		wait: func() (int, error) {
+			defer attach.Close()
+			// 出力が閉じてもexecの終了が反映されるまで少し遅れることがある
+			for {
+				resp, err := d.Client.ContainerExecInspect(ctx, created.ID)
+				if err != nil {
+					return -1, err
+				}
+				if !resp.Running {
+					return resp.ExitCode, nil
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		},
+		// 接続を閉じるとTtyの場合はコマンドにSIGHUPが送られる
+		close: func() error {
+			cancel()
+			attach.Close()
+			return nil
+		},
+	}
+
+	if opts.Tty {
+		s.Stdout = attach.Reader
+		return s, nil
+	}
+
+	outR, outW := io.Pipe()
+	errR, errW := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(outW, errW, attach.Reader)
+		outW.CloseWithError(err)
+		errW.CloseWithError(err)
+	}()
+	s.Stdout = outR
+	s.Stderr = errR
+
+	return s, nil
+}
+
+type hijackedStdin struct {
+	resp types.HijackedResponse
+}
+
+func (h *hijackedStdin) Write(p []byte) (int, error) {
+	return h.resp.Conn.Write(p)
 }
 
-type DockerInspectResponse struct {
-	Error     error
-	Container *container.InspectResponse
+func (h *hijackedStdin) Close() error {
+	return h.resp.CloseWrite()
 }
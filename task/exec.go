@@ -0,0 +1,606 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+var _ Runtime = &Exec{}
+
+const (
+	cgroupV2Root   = "/sys/fs/cgroup"
+	cpuPeriod      = 100000
+	execStopPeriod = 10 * time.Second
+)
+
+// Exec はタスクのコマンドをコンテナを使わずにホストのプロセスとして起動する。
+// IDはプロセスのPIDを文字列にしたもの
+type Exec struct {
+	mu         sync.Mutex
+	LogDir     string
+	CgroupRoot string
+	// CgroupRootの下のcgroupで使えるコントローラ
+	Controllers map[string]bool
+	procs       map[string]*process
+}
+
+type process struct {
+	cmd *exec.Cmd
+	// ログファイルとcgroupの名前。再起動では同じタスクを続けて動かすので、起動ごとに作る
+	key        string
+	env        []string
+	cgroup     string
+	ports      nat.PortMap
+	done       chan struct{}
+	exitCode   int
+	finishedAt time.Time
+}
+
+func NewExec() (*Exec, error) {
+
+	logDir := filepath.Join(os.TempDir(), "cube-exec")
+	if err := os.MkdirAll(logDir, 0700); err != nil {
+		return nil, err
+	}
+
+	cgroupRoot, controllers := setupCgroup()
+
+	return &Exec{
+		LogDir:      logDir,
+		CgroupRoot:  cgroupRoot,
+		Controllers: controllers,
+		procs:       make(map[string]*process),
+	}, nil
+}
+
+// setupCgroup はcgroup v2が使える場合にcube用のcgroupを作成し、そのパスと有効にできたコントローラを返す。
+// 使えない場合は空文字を返す。制限を指定しないタスクはどちらの場合も動かせる
+func setupCgroup() (string, map[string]bool) {
+
+	if _, err := os.Stat(filepath.Join(cgroupV2Root, "cgroup.controllers")); err != nil {
+		log.Printf("cgroup v2 is not available, tasks with resource limits will not run")
+		return "", nil
+	}
+
+	root := filepath.Join(cgroupV2Root, "cube")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		log.Printf("error creating cgroup %s, tasks with resource limits will not run: %v", root, err)
+		return "", nil
+	}
+
+	// root以外で動かす場合や名前空間の中ではルートのcgroupに書き込めないことがあるので、
+	// 親で有効になっているコントローラだけを使う
+	for _, cg := range []string{cgroupV2Root, root} {
+		for _, controller := range []string{"cpu", "memory"} {
+			if err := os.WriteFile(filepath.Join(cg, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+				log.Printf("error enabling cgroup controller %s in %s: %v", controller, cg, err)
+			}
+		}
+	}
+
+	controllers := make(map[string]bool)
+	if b, err := os.ReadFile(filepath.Join(root, "cgroup.subtree_control")); err == nil {
+		for _, c := range strings.Fields(string(b)) {
+			controllers[c] = true
+		}
+	}
+
+	return root, controllers
+}
+
+// checkLimits はタスクが指定したリソース制限をかけられるかを確かめる
+func (e *Exec) checkLimits(c Config) error {
+	for _, limit := range []struct {
+		controller string
+		set        bool
+	}{{"cpu", c.Cpu > 0}, {"memory", c.Memory > 0}} {
+		if limit.set && !e.Controllers[limit.controller] {
+			return fmt.Errorf("exec runtime cannot limit %s: cgroup %s controller is not available", limit.controller, limit.controller)
+		}
+	}
+
+	return nil
+}
+
+func (e *Exec) Run(c Config) RuntimeResult {
+
+	argv := c.Cmd
+	if len(argv) == 0 && c.Image != "" {
+		argv = []string{c.Image}
+	}
+	if len(argv) == 0 {
+		return RuntimeResult{Error: errors.New("no command to run")}
+	}
+
+	if len(c.Volumes) > 0 {
+		return RuntimeResult{Error: errors.New("exec runtime does not support volumes")}
+	}
+
+	for p, bindings := range c.PortBindings {
+		for _, b := range bindings {
+			if b.HostPort != p.Port() {
+				return RuntimeResult{Error: fmt.Errorf("exec runtime cannot map port %s to host port %s", p, b.HostPort)}
+			}
+		}
+	}
+
+	if err := e.checkLimits(c); err != nil {
+		return RuntimeResult{Error: err}
+	}
+
+	key := fmt.Sprintf("%s-%s", c.Name, uuid.NewString())
+	stdout, err := os.Create(e.logFile(key, "stdout"))
+	if err != nil {
+		return RuntimeResult{Error: err}
+	}
+	stderr, err := os.Create(e.logFile(key, "stderr"))
+	if err != nil {
+		stdout.Close()
+		return RuntimeResult{Error: err}
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = c.Env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	p := &process{
+		cmd:   cmd,
+		key:   key,
+		env:   c.Env,
+		ports: execPorts(c.ExposedPorts),
+		done:  make(chan struct{}),
+	}
+
+	if e.CgroupRoot != "" {
+		cg, err := e.createCgroup(key, c)
+		var fd *os.File
+		if err == nil {
+			fd, err = os.Open(cg)
+		}
+		switch {
+		case err == nil:
+			defer fd.Close()
+			cmd.SysProcAttr.UseCgroupFD = true
+			cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+			p.cgroup = cg
+		case c.Cpu > 0 || c.Memory > 0:
+			// 制限をかけられないまま動かさない
+			log.Printf("error creating cgroup for %s: %v", c.Name, err)
+			stdout.Close()
+			stderr.Close()
+			if cg != "" {
+				os.Remove(cg)
+			}
+			return RuntimeResult{Error: err}
+		default:
+			log.Printf("error creating cgroup for %s, running without it: %v", c.Name, err)
+			if cg != "" {
+				os.Remove(cg)
+			}
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		log.Printf("error starting process %s", err)
+		stdout.Close()
+		stderr.Close()
+		if p.cgroup != "" {
+			os.Remove(p.cgroup)
+		}
+		return RuntimeResult{Error: err}
+	}
+
+	go func() {
+		cmd.Wait()
+		stdout.Close()
+		stderr.Close()
+
+		e.mu.Lock()
+		p.exitCode = exitCode(cmd.ProcessState)
+		p.finishedAt = time.Now().UTC()
+		e.mu.Unlock()
+		close(p.done)
+	}()
+
+	id := strconv.Itoa(cmd.Process.Pid)
+	e.mu.Lock()
+	e.procs[id] = p
+	e.mu.Unlock()
+
+	return RuntimeResult{ContainerId: id, Pid: cmd.Process.Pid, Action: "start", Result: "success"}
+}
+
+func (e *Exec) createCgroup(key string, c Config) (string, error) {
+
+	cg := filepath.Join(e.CgroupRoot, key)
+	if err := os.MkdirAll(cg, 0755); err != nil {
+		return "", err
+	}
+
+	if c.Cpu > 0 {
+		quota := int64(c.Cpu * cpuPeriod)
+		if err := os.WriteFile(filepath.Join(cg, "cpu.max"), []byte(fmt.Sprintf("%d %d", quota, cpuPeriod)), 0644); err != nil {
+			return cg, err
+		}
+	}
+
+	if c.Memory > 0 {
+		if err := os.WriteFile(filepath.Join(cg, "memory.max"), []byte(strconv.FormatInt(c.Memory, 10)), 0644); err != nil {
+			return cg, err
+		}
+	}
+
+	return cg, nil
+}
+
+func (e *Exec) Stop(id string) RuntimeResult {
+
+	log.Printf("stopping process %s", id)
+	e.mu.Lock()
+	p, ok := e.procs[id]
+	e.mu.Unlock()
+	if !ok {
+		return RuntimeResult{Error: fmt.Errorf("no such process: %s", id)}
+	}
+
+	// プロセスグループごとシグナルを送る
+	pgid := -p.cmd.Process.Pid
+	select {
+	case <-p.done:
+	default:
+		syscall.Kill(pgid, syscall.SIGTERM)
+		select {
+		case <-p.done:
+		case <-time.After(execStopPeriod):
+			syscall.Kill(pgid, syscall.SIGKILL)
+			<-p.done
+		}
+	}
+
+	if p.cgroup != "" {
+		if err := os.Remove(p.cgroup); err != nil {
+			log.Printf("error removing cgroup %s: %v", p.cgroup, err)
+		}
+	}
+	os.Remove(e.logFile(p.key, "stdout"))
+	os.Remove(e.logFile(p.key, "stderr"))
+
+	e.mu.Lock()
+	delete(e.procs, id)
+	e.mu.Unlock()
+
+	return RuntimeResult{Action: "stop", Result: "success"}
+}
+
+// Remove はStopと同じ。ボリュームは扱わない
+func (e *Exec) Remove(id string) RuntimeResult {
+	return e.Stop(id)
+}
+
+func (e *Exec) Inspect(id string) InspectResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	p, ok := e.procs[id]
+	if !ok {
+		return InspectResult{Error: fmt.Errorf("no such process: %s", id)}
+	}
+
+	select {
+	case <-p.done:
+		return InspectResult{Status: StatusExited, ExitCode: p.exitCode, FinishedAt: p.finishedAt, Ports: p.ports}
+	default:
+		return InspectResult{Status: StatusRunning, Ports: p.ports}
+	}
+}
+
+// Logs はプロセスの出力を返す。ファイルに時刻は残していないのでSinceは無視する
+func (e *Exec) Logs(id string, opts LogsOptions) (io.ReadCloser, error) {
+	e.mu.Lock()
+	p, ok := e.procs[id]
+	e.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no such process: %s", id)
+	}
+
+	var files []*os.File
+	for _, stream := range []struct {
+		name string
+		show bool
+	}{{"stdout", opts.Stdout}, {"stderr", opts.Stderr}} {
+		if !stream.show {
+			continue
+		}
+
+		f, err := os.Open(e.logFile(p.key, stream.name))
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+
+		if err := seekTail(f, opts.Tail); err != nil {
+			closeFiles(files, f)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	pr, pw := io.Pipe()
+	lr := &logReader{PipeReader: pr, stop: make(chan struct{})}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, f := range files {
+		wg.Add(1)
+		go func(f *os.File) {
+			defer wg.Done()
+			defer f.Close()
+			lr.copyLines(f, pw, &mu, opts.Follow, p.done)
+		}(f)
+	}
+
+	go func() {
+		wg.Wait()
+		pw.Close()
+	}()
+
+	return lr, nil
+}
+
+type logReader struct {
+	*io.PipeReader
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (l *logReader) Close() error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	return l.PipeReader.Close()
+}
+
+// copyLines はfの内容を行単位でwへ書き出す。followの場合はプロセスが終了するまで追いかける
+func (l *logReader) copyLines(f *os.File, w io.Writer, mu *sync.Mutex, follow bool, done chan struct{}) {
+
+	r := bufio.NewReader(f)
+	var partial string
+	for {
+		line, err := r.ReadString('\n')
+		partial += line
+		if err == nil {
+			mu.Lock()
+			_, werr := io.WriteString(w, partial)
+			mu.Unlock()
+			if werr != nil {
+				return
+			}
+			partial = ""
+			continue
+		}
+
+		finished := false
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+
+		if !follow || finished {
+			// 終了後に書かれた分を読み切ってから抜ける
+			rest, _ := io.ReadAll(r)
+			partial += string(rest)
+			if partial != "" {
+				mu.Lock()
+				io.WriteString(w, partial)
+				mu.Unlock()
+			}
+			return
+		}
+
+		select {
+		case <-l.stop:
+			return
+		case <-done:
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// seekTail はfの読み込み位置を末尾からtail行目の先頭に移動する
+func seekTail(f *os.File, tail string) error {
+	if tail == "" || tail == "all" {
+		return nil
+	}
+
+	n, err := strconv.Atoi(tail)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid tail %q", tail)
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	offset := len(data)
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := 0; i < n; i++ {
+		idx := bytes.LastIndexByte(data[:end], '\n')
+		offset = idx + 1
+		if idx < 0 {
+			break
+		}
+		end = idx
+	}
+
+	_, err = f.Seek(int64(offset), io.SeekStart)
+	return err
+}
+
+func (e *Exec) Stats(id string) StatsResult {
+	e.mu.Lock()
+	p, ok := e.procs[id]
+	e.mu.Unlock()
+	if !ok {
+		return StatsResult{Error: fmt.Errorf("no such process: %s", id)}
+	}
+
+	if p.cgroup != "" {
+		return cgroupStats(p.cgroup)
+	}
+
+	stat, err := linux.ReadProcessStat(fmt.Sprintf("/proc/%d/stat", p.cmd.Process.Pid))
+	if err != nil {
+		return StatsResult{Error: err}
+	}
+
+	// USER_HZは100として扱う
+	return StatsResult{
+		CpuUsage:    (stat.Utime + stat.Stime) * uint64(10*time.Millisecond),
+		MemoryUsage: uint64(stat.Rss) * uint64(os.Getpagesize()),
+	}
+}
+
+// Exec はタスクと同じ環境変数、同じcgroupでコマンドをホスト上に起動する。ptyは扱えないのでTtyは使えない
+func (e *Exec) Exec(id string, opts ExecOptions) (*ExecSession, error) {
+	e.mu.Lock()
+	p, ok := e.procs[id]
+	e.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no such process: %s", id)
+	}
+
+	if opts.Tty {
+		return nil, errors.New("exec runtime does not support tty")
+	}
+	if len(opts.Cmd) == 0 {
+		return nil, errors.New("no command to run")
+	}
+
+	cmd := exec.Command(opts.Cmd[0], opts.Cmd[1:]...)
+	cmd.Env = p.env
+
+	if p.cgroup != "" {
+		fd, err := os.Open(p.cgroup)
+		if err == nil {
+			defer fd.Close()
+			cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(fd.Fd())}
+		}
+	}
+
+	s := &ExecSession{}
+	if opts.Stdin {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		s.Stdin = stdin
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	s.Stdout = stdout
+	s.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	s.wait = func() (int, error) {
+		cmd.Wait()
+		return exitCode(cmd.ProcessState), nil
+	}
+	s.close = func() error {
+		// 終了済みのプロセスにはエラーが返るだけなので無視する
+		cmd.Process.Kill()
+		return nil
+	}
+
+	return s, nil
+}
+
+func (e *Exec) logFile(key, stream string) string {
+	return filepath.Join(e.LogDir, fmt.Sprintf("%s.%s", key, stream))
+}
+
+func cgroupStats(cg string) StatsResult {
+
+	var result StatsResult
+	if b, err := os.ReadFile(filepath.Join(cg, "memory.current")); err == nil {
+		result.MemoryUsage, _ = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	}
+	if b, err := os.ReadFile(filepath.Join(cg, "memory.max")); err == nil {
+		result.MemoryLimit, _ = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	}
+
+	b, err := os.ReadFile(filepath.Join(cg, "cpu.stat"))
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 2 && f[0] == "usage_usec" {
+			usec, _ := strconv.ParseUint(f[1], 10, 64)
+			result.CpuUsage = usec * uint64(time.Microsecond)
+		}
+	}
+
+	return result
+}
+
+func execPorts(exposed nat.PortSet) nat.PortMap {
+	// ホストのプロセスはポートをそのまま使う
+	ports := nat.PortMap{}
+	for p := range exposed {
+		ports[p] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: p.Port()}}
+	}
+
+	return ports
+}
+
+func exitCode(ps *os.ProcessState) int {
+	if ps == nil {
+		return -1
+	}
+
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+
+	return ps.ExitCode()
+}
+
+func closeFiles(files []*os.File, more ...*os.File) {
+	files = append(files, more...)
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,257 @@
+package task
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestExec(t *testing.T) *Exec {
+	t.Helper()
+
+	return &Exec{LogDir: t.TempDir(), procs: make(map[string]*process)}
+}
+
+func runShell(t *testing.T, e *Exec, script string) string {
+	t.Helper()
+
+	result := e.Run(Config{Name: "sh", Cmd: []string{"/bin/sh", "-c", script}, Env: []string{"PATH=/usr/bin:/bin", "GREETING=hello"}})
+	if result.Error != nil {
+		t.Fatalf("error running %q: %v", script, result.Error)
+	}
+	t.Cleanup(func() { e.Stop(result.ContainerId) })
+
+	return result.ContainerId
+}
+
+func waitExited(t *testing.T, e *Exec, id string) InspectResult {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if r := e.Inspect(id); r.Error != nil || r.Status == StatusExited {
+			return r
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("process %s did not exit", id)
+	return InspectResult{}
+}
+
+func readLogs(t *testing.T, e *Exec, id string, opts LogsOptions) string {
+	t.Helper()
+
+	rc, err := e.Logs(id, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestExecRunReportsExitCode(t *testing.T) {
+	e := newTestExec(t)
+
+	id := runShell(t, e, "sleep 0.2; exit 3")
+	if r := e.Inspect(id); r.Status != StatusRunning {
+		t.Errorf("expected %s, got %+v", StatusRunning, r)
+	}
+
+	r := waitExited(t, e, id)
+	if r.Error != nil || r.ExitCode != 3 || r.FinishedAt.IsZero() {
+		t.Errorf("expected exit code 3 with a finish time, got %+v", r)
+	}
+}
+
+func TestExecLogsAfterExit(t *testing.T) {
+	e := newTestExec(t)
+
+	id := runShell(t, e, "echo one; echo two; echo three; echo oops >&2; printf partial")
+	waitExited(t, e, id)
+
+	if got := readLogs(t, e, id, LogsOptions{Stdout: true}); got != "one\ntwo\nthree\npartial" {
+		t.Errorf("unexpected stdout %q", got)
+	}
+	if got := readLogs(t, e, id, LogsOptions{Stderr: true}); got != "oops\n" {
+		t.Errorf("unexpected stderr %q", got)
+	}
+	if got := readLogs(t, e, id, LogsOptions{Stdout: true, Tail: "2"}); got != "three\npartial" {
+		t.Errorf("unexpected tail %q", got)
+	}
+	if _, err := e.Logs(id, LogsOptions{Stdout: true, Tail: "last"}); err == nil {
+		t.Error("expected an error for an invalid tail")
+	}
+}
+
+func TestExecLogsFollow(t *testing.T) {
+	e := newTestExec(t)
+
+	id := runShell(t, e, "echo one; echo two; sleep 0.5; echo three")
+	deadline := time.Now().Add(5 * time.Second)
+	for readLogs(t, e, id, LogsOptions{Stdout: true}) != "one\ntwo\n" {
+		if time.Now().After(deadline) {
+			t.Fatal("process did not write its first lines")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// followはプロセスが終了するまで読み続ける
+	if got := readLogs(t, e, id, LogsOptions{Stdout: true, Follow: true, Tail: "1"}); got != "two\nthree\n" {
+		t.Errorf("unexpected logs %q", got)
+	}
+}
+
+func TestExecLogsFollowStopsWhenClosed(t *testing.T) {
+	e := newTestExec(t)
+
+	id := runShell(t, e, "echo one; sleep 10")
+	rc, err := e.Logs(id, LogsOptions{Stdout: true, Follow: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		io.ReadAll(rc)
+		close(done)
+	}()
+	rc.Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the reader to return once closed")
+	}
+}
+
+func TestExecStop(t *testing.T) {
+	e := newTestExec(t)
+
+	running := runShell(t, e, "sleep 10")
+	exited := runShell(t, e, "exit 0")
+	waitExited(t, e, exited)
+
+	start := time.Now()
+	for _, id := range []string{running, exited} {
+		if result := e.Stop(id); result.Error != nil {
+			t.Errorf("%s: unexpected error: %v", id, result.Error)
+		}
+		if r := e.Inspect(id); r.Error == nil {
+			t.Errorf("%s: expected the process to be forgotten, got %+v", id, r)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= execStopPeriod {
+		t.Errorf("expected SIGTERM to stop the process, took %v", elapsed)
+	}
+
+	if files, _ := os.ReadDir(e.LogDir); len(files) != 0 {
+		t.Errorf("expected the log files to be removed, got %d", len(files))
+	}
+	if result := e.Stop(running); result.Error == nil {
+		t.Error("expected an error stopping an unknown process")
+	}
+}
+
+func TestExecExec(t *testing.T) {
+	e := newTestExec(t)
+	id := runShell(t, e, "sleep 10")
+
+	// タスクの環境変数で実行する
+	s, err := e.Exec(id, ExecOptions{Cmd: []string{"/bin/sh", "-c", "echo $GREETING; echo err >&2; exit 4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, _ := io.ReadAll(s.Stdout)
+	stderr, _ := io.ReadAll(s.Stderr)
+	code, err := s.Wait()
+	if err != nil || code != 4 {
+		t.Errorf("expected exit code 4, got %d %v", code, err)
+	}
+	if string(stdout) != "hello\n" || string(stderr) != "err\n" {
+		t.Errorf("unexpected output %q %q", stdout, stderr)
+	}
+
+	s, err = e.Exec(id, ExecOptions{Cmd: []string{"cat"}, Stdin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(s.Stdin, "ping\n")
+	s.Stdin.Close()
+	if out, _ := io.ReadAll(s.Stdout); string(out) != "ping\n" {
+		t.Errorf("expected stdin to be echoed, got %q", out)
+	}
+	s.Wait()
+
+	// 閉じると動いているコマンドを止める
+	s, err = e.Exec(id, ExecOptions{Cmd: []string{"sleep", "10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if code, _ := s.Wait(); code != 128+9 {
+		t.Errorf("expected the command to be killed, got exit code %d", code)
+	}
+
+	if _, err := e.Exec(id, ExecOptions{Cmd: []string{"sh"}, Tty: true}); err == nil {
+		t.Error("expected an error for tty")
+	}
+}
+
+func TestExecRejectsLimitsWithoutCgroup(t *testing.T) {
+	e := newTestExec(t)
+
+	for _, c := range []Config{
+		{Name: "cpu", Cmd: []string{"true"}, Cpu: 0.5},
+		{Name: "memory", Cmd: []string{"true"}, Memory: 64 << 20},
+	} {
+		if result := e.Run(c); result.Error == nil || !strings.Contains(result.Error.Error(), "controller is not available") {
+			t.Errorf("%s: expected an error, got %v", c.Name, result.Error)
+		}
+	}
+	if files, _ := os.ReadDir(e.LogDir); len(files) != 0 {
+		t.Errorf("expected no log files, got %d", len(files))
+	}
+
+	// 制限を指定しないタスクは動かせる
+	id := runShell(t, e, "exit 0")
+	waitExited(t, e, id)
+}
+
+func TestExecCgroupLimits(t *testing.T) {
+	root, controllers := setupCgroup()
+	if root == "" || !controllers["cpu"] || !controllers["memory"] {
+		t.Skip("cgroup v2 with the cpu and memory controllers is not available")
+	}
+
+	e := newTestExec(t)
+	e.CgroupRoot = root
+	e.Controllers = controllers
+
+	result := e.Run(Config{Name: "limited", Cmd: []string{"sleep", "10"}, Cpu: 0.5, Memory: 64 << 20})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	e.mu.Lock()
+	cg := e.procs[result.ContainerId].cgroup
+	e.mu.Unlock()
+	if b, _ := os.ReadFile(filepath.Join(cg, "cpu.max")); strings.TrimSpace(string(b)) != "50000 100000" {
+		t.Errorf("unexpected cpu.max %q", b)
+	}
+	if stats := e.Stats(result.ContainerId); stats.MemoryLimit != 64<<20 {
+		t.Errorf("expected memory limit %d, got %+v", 64<<20, stats)
+	}
+
+	e.Stop(result.ContainerId)
+	if _, err := os.Stat(cg); !os.IsNotExist(err) {
+		t.Errorf("expected cgroup %s to be removed, got %v", cg, err)
+	}
+}
@@ -0,0 +1,145 @@
+package task
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type ExecOptions struct {
+	Cmd   []string
+	Tty   bool
+	Stdin bool
+}
+
+// ExecSession は実行中のタスク内で起動したコマンドの入出力をまとめたもの。
+// Ttyの場合、出力はStdoutだけに流れる
+type ExecSession struct {
+	Stdin  io.WriteCloser
+	Stdout io.Reader
+	Stderr io.Reader
+	resize func(height, width uint) error
+	wait   func() (int, error)
+	close  func() error
+	once   sync.Once
+}
+
+func (s *ExecSession) Resize(height, width uint) error {
+	if s.resize == nil {
+		return errors.New("resize is not supported")
+	}
+
+	return s.resize(height, width)
+}
+
+// Wait はコマンドの終了を待って終了コードを返す
+func (s *ExecSession) Wait() (int, error) {
+	return s.wait()
+}
+
+// Close はセッションを閉じて、まだ動いているコマンドを止める。クライアントとの接続が切れたときに使う。
+// 何度呼んでもよい
+func (s *ExecSession) Close() error {
+	var err error
+	s.once.Do(func() {
+		if s.close != nil {
+			err = s.close()
+		}
+	})
+
+	return err
+}
+
+// execストリームのフレームの種類
+const (
+	FrameStdin byte = iota
+	FrameStdout
+	FrameStderr
+	FrameResize
+	FrameCloseStdin
+	FrameExit
+)
+
+const maxFrameSize = 1 << 20
+
+// WriteFrame は [種類, 0, 0, 0, 長さ(4byte big endian)] のヘッダに続けてpayloadを書き込む
+func WriteFrame(w io.Writer, typ byte, payload []byte) error {
+	header := make([]byte, 8)
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func ReadFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[4:])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame too large: %d bytes", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], payload, nil
+}
+
+func ResizePayload(height, width uint) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b[0:], uint16(height))
+	binary.BigEndian.PutUint16(b[2:], uint16(width))
+	return b
+}
+
+func ParseResizePayload(b []byte) (uint, uint, error) {
+	if len(b) != 4 {
+		return 0, 0, fmt.Errorf("invalid resize payload of %d bytes", len(b))
+	}
+
+	return uint(binary.BigEndian.Uint16(b[0:])), uint(binary.BigEndian.Uint16(b[2:])), nil
+}
+
+func ExitPayload(code int) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(int32(code)))
+	return b
+}
+
+func ParseExitPayload(b []byte) (int, error) {
+	if len(b) != 4 {
+		return 0, fmt.Errorf("invalid exit payload of %d bytes", len(b))
+	}
+
+	return int(int32(binary.BigEndian.Uint32(b))), nil
+}
+
+// FrameWriter は書き込まれた内容を指定した種類のフレームとして書き出す。
+// 同じMutexを共有することで複数のFrameWriterから同じ接続に書き込める
+type FrameWriter struct {
+	W    io.Writer
+	Type byte
+	Mu   *sync.Mutex
+}
+
+func (f *FrameWriter) Write(p []byte) (int, error) {
+	f.Mu.Lock()
+	defer f.Mu.Unlock()
+
+	if err := WriteFrame(f.W, f.Type, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
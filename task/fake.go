@@ -0,0 +1,267 @@
+package task
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+var _ Runtime = &Fake{}
+
+// FakeBehavior はFakeランタイム上で名前が一致するタスクの振る舞いを指定する
+type FakeBehavior struct {
+	RunError   error
+	StartDelay time.Duration
+	ExitAfter  time.Duration
+	ExitCode   int
+	HostPorts  nat.PortMap
+	Logs       string
+	Stats      StatsResult
+	// Execで実行したコマンドの出力と終了コード。標準入力はそのまま出力に返す
+	ExecOutput   string
+	ExecExitCode int
+	// trueの場合、Execのコマンドはセッションを閉じるまで終わらない
+	ExecBlock bool
+}
+
+type FakeContainer struct {
+	ID         string
+	Config     Config
+	Status     string
+	ExitCode   int
+	Ports      nat.PortMap
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Execs      [][]string
+	// 閉じていないExecのセッションの数
+	OpenExecs int
+	behavior  FakeBehavior
+}
+
+// Fake はテスト用のインメモリRuntime。Dockerやネットワークには一切触れない
+type Fake struct {
+	mu         sync.Mutex
+	NextPort   int
+	seq        int
+	behaviors  map[string]FakeBehavior
+	containers map[string]*FakeContainer
+	// Stopでボリュームのポリシーを適用したコンテナ
+	volumeCleanups []string
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		NextPort:   32768,
+		behaviors:  make(map[string]FakeBehavior),
+		containers: make(map[string]*FakeContainer),
+	}
+}
+
+func (f *Fake) Script(name string, b FakeBehavior) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.behaviors[name] = b
+}
+
+func (f *Fake) Run(c Config) RuntimeResult {
+	f.mu.Lock()
+	b := f.behaviors[c.Name]
+	f.mu.Unlock()
+
+	if b.StartDelay > 0 {
+		time.Sleep(b.StartDelay)
+	}
+
+	if b.RunError != nil {
+		return RuntimeResult{Error: b.RunError}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	fc := &FakeContainer{
+		ID:        fmt.Sprintf("fake-%d", f.seq),
+		Config:    c,
+		Status:    StatusRunning,
+		Ports:     b.HostPorts,
+		StartedAt: time.Now().UTC(),
+		behavior:  b,
+	}
+
+	if fc.Ports == nil {
+		fc.Ports = nat.PortMap{}
+		for p := range c.ExposedPorts {
+			if bindings, ok := c.PortBindings[p]; ok {
+				fc.Ports[p] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: bindings[0].HostPort}}
+				continue
+			}
+			fc.Ports[p] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: strconv.Itoa(f.NextPort)}}
+			f.NextPort++
+		}
+	}
+
+	f.containers[fc.ID] = fc
+
+	return RuntimeResult{ContainerId: fc.ID, Action: "start", Result: "success"}
+}
+
+func (f *Fake) Stop(id string) RuntimeResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.containers[id]; !ok {
+		return RuntimeResult{Error: fmt.Errorf("no such container: %s", id)}
+	}
+
+	delete(f.containers, id)
+	f.volumeCleanups = append(f.volumeCleanups, id)
+
+	return RuntimeResult{Action: "stop", Result: "success"}
+}
+
+func (f *Fake) Remove(id string) RuntimeResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.containers[id]; !ok {
+		return RuntimeResult{Error: fmt.Errorf("no such container: %s", id)}
+	}
+
+	delete(f.containers, id)
+
+	return RuntimeResult{Action: "remove", Result: "success"}
+}
+
+func (f *Fake) Inspect(id string) InspectResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return InspectResult{Error: fmt.Errorf("no such container: %s", id)}
+	}
+
+	if fc.Status == StatusRunning && fc.behavior.ExitAfter > 0 && time.Since(fc.StartedAt) >= fc.behavior.ExitAfter {
+		fc.Status = StatusExited
+		fc.ExitCode = fc.behavior.ExitCode
+		fc.FinishedAt = fc.StartedAt.Add(fc.behavior.ExitAfter)
+	}
+
+	return InspectResult{Status: fc.Status, ExitCode: fc.ExitCode, FinishedAt: fc.FinishedAt, Ports: fc.Ports}
+}
+
+func (f *Fake) Logs(id string, opts LogsOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("no such container: %s", id)
+	}
+
+	return io.NopCloser(strings.NewReader(fc.behavior.Logs)), nil
+}
+
+func (f *Fake) Stats(id string) StatsResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return StatsResult{Error: fmt.Errorf("no such container: %s", id)}
+	}
+
+	return fc.behavior.Stats
+}
+
+func (f *Fake) Exec(id string, opts ExecOptions) (*ExecSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("no such container: %s", id)
+	}
+	fc.Execs = append(fc.Execs, opts.Cmd)
+	fc.OpenExecs++
+
+	s := &ExecSession{
+		Stderr: strings.NewReader(""),
+		resize: func(height, width uint) error { return nil },
+		wait:   func() (int, error) { return fc.behavior.ExecExitCode, nil },
+	}
+
+	out := strings.NewReader(fc.behavior.ExecOutput)
+	closeOutput := func() error { return nil }
+	switch {
+	case fc.behavior.ExecBlock:
+		pr, pw := io.Pipe()
+		s.Stdout = io.MultiReader(out, pr)
+		closeOutput = pw.Close
+	case opts.Stdin:
+		pr, pw := io.Pipe()
+		s.Stdin = pw
+		s.Stdout = io.MultiReader(out, pr)
+	default:
+		s.Stdout = out
+	}
+	s.close = func() error {
+		f.mu.Lock()
+		fc.OpenExecs--
+		f.mu.Unlock()
+		return closeOutput()
+	}
+
+	return s, nil
+}
+
+// Crash はコンテナを指定した終了コードで即座に終了させる
+func (f *Fake) Crash(id string, exitCode int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return fmt.Errorf("no such container: %s", id)
+	}
+
+	fc.Status = StatusExited
+	fc.ExitCode = exitCode
+	fc.FinishedAt = time.Now().UTC()
+
+	return nil
+}
+
+func (f *Fake) Container(id string) (FakeContainer, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return FakeContainer{}, false
+	}
+
+	return *fc, true
+}
+
+// VolumeCleanups はStopでボリュームのポリシーを適用したコンテナのIDを返す
+func (f *Fake) VolumeCleanups() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.volumeCleanups...)
+}
+
+func (f *Fake) Count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.containers)
+}
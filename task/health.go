@@ -0,0 +1,202 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	defaultProbeInterval    = 10 * time.Second
+	defaultProbeTimeout     = time.Second
+	defaultSuccessThreshold = 1
+	defaultFailureThreshold = 3
+)
+
+// ワーカーがヘルスチェックの結果をTask.Healthに記録する
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// マネージャがTask.HealthHistoryに残す記録の数
+const maxHealthHistory = 10
+
+// HealthRecord はワーカーから報告されたヘルスチェックの結果の変化
+type HealthRecord struct {
+	Time    time.Time
+	Health  string
+	Ready   bool
+	Message string
+}
+
+// RecordHealth はHealth、Ready、HealthMessageが最後の記録から変わっていればHealthHistoryに追加する
+func (t *Task) RecordHealth(now time.Time) {
+	r := HealthRecord{Time: now, Health: t.Health, Ready: t.Ready, Message: t.HealthMessage}
+	if n := len(t.HealthHistory); n > 0 {
+		last := t.HealthHistory[n-1]
+		if last.Health == r.Health && last.Ready == r.Ready && last.Message == r.Message {
+			return
+		}
+	} else if r.Health == "" && !r.Ready && r.Message == "" {
+		return
+	}
+
+	t.HealthHistory = append(t.HealthHistory, r)
+	if len(t.HealthHistory) > maxHealthHistory {
+		t.HealthHistory = t.HealthHistory[len(t.HealthHistory)-maxHealthHistory:]
+	}
+}
+
+// HealthCheck はタスクが正常に動いているかを確認する方法。HTTP、TCP、Execのいずれか一つを指定する。
+// Task.HealthCheckが失敗すると再起動し、Task.ReadinessCheckが失敗するとReadyをfalseにする。
+// Task.StartupCheckが成功するまでは他の確認を行わない
+type HealthCheck struct {
+	HTTP *HTTPProbe
+	TCP  *TCPProbe
+	Exec *ExecProbe
+
+	InitialDelaySeconds int
+	IntervalSeconds     int
+	TimeoutSeconds      int
+	SuccessThreshold    int
+	FailureThreshold    int
+}
+
+// HTTPProbe はPathにGETしてExpectedStatusが返るかを確認する。
+// Portはコンテナ側のポートで、省略した場合は公開しているポートのどれかを使う
+type HTTPProbe struct {
+	Path           string
+	Port           string
+	ExpectedStatus int
+}
+
+type TCPProbe struct {
+	Port string
+}
+
+// ExecProbe はコンテナ内でCmdを実行し、終了コードが0かを確認する
+type ExecProbe struct {
+	Cmd []string
+}
+
+// UnmarshalJSON は以前のようにパスの文字列だけを指定した場合はHTTPProbeとして扱う
+func (h *HealthCheck) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*h = HealthCheck{}
+		if path != "" {
+			h.HTTP = &HTTPProbe{Path: path}
+		}
+		return nil
+	}
+
+	type plain HealthCheck
+	return json.Unmarshal(data, (*plain)(h))
+}
+
+func (h *HealthCheck) Configured() bool {
+	return h != nil && (h.HTTP != nil || h.TCP != nil || h.Exec != nil)
+}
+
+func (h *HealthCheck) InitialDelay() time.Duration {
+	return time.Duration(h.InitialDelaySeconds) * time.Second
+}
+
+func (h *HealthCheck) Interval() time.Duration {
+	if h.IntervalSeconds > 0 {
+		return time.Duration(h.IntervalSeconds) * time.Second
+	}
+	return defaultProbeInterval
+}
+
+func (h *HealthCheck) Timeout() time.Duration {
+	if h.TimeoutSeconds > 0 {
+		return time.Duration(h.TimeoutSeconds) * time.Second
+	}
+	return defaultProbeTimeout
+}
+
+func (h *HealthCheck) Successes() int {
+	if h.SuccessThreshold > 0 {
+		return h.SuccessThreshold
+	}
+	return defaultSuccessThreshold
+}
+
+func (h *HealthCheck) Failures() int {
+	if h.FailureThreshold > 0 {
+		return h.FailureThreshold
+	}
+	return defaultFailureThreshold
+}
+
+func (p *HTTPProbe) Status() int {
+	if p.ExpectedStatus > 0 {
+		return p.ExpectedStatus
+	}
+	return http.StatusOK
+}
+
+func (h *HealthCheck) validate() error {
+	var errs []error
+
+	probes := 0
+	if h.HTTP != nil {
+		probes++
+		if h.HTTP.Path != "" && !strings.HasPrefix(h.HTTP.Path, "/") {
+			errs = append(errs, fmt.Errorf("HealthCheck HTTP Path must start with /: %q", h.HTTP.Path))
+		}
+		if h.HTTP.ExpectedStatus != 0 && (h.HTTP.ExpectedStatus < 100 || h.HTTP.ExpectedStatus > 599) {
+			errs = append(errs, fmt.Errorf("invalid HealthCheck HTTP ExpectedStatus %d", h.HTTP.ExpectedStatus))
+		}
+		if err := validateProbePort(h.HTTP.Port); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if h.TCP != nil {
+		probes++
+		if h.TCP.Port == "" {
+			errs = append(errs, errors.New("HealthCheck TCP Port is required"))
+		} else if err := validateProbePort(h.TCP.Port); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if h.Exec != nil {
+		probes++
+		if len(h.Exec.Cmd) == 0 {
+			errs = append(errs, errors.New("HealthCheck Exec Cmd is required"))
+		}
+	}
+	if probes > 1 {
+		errs = append(errs, errors.New("HealthCheck must specify only one of HTTP, TCP or Exec"))
+	}
+
+	if h.InitialDelaySeconds < 0 || h.IntervalSeconds < 0 || h.TimeoutSeconds < 0 {
+		errs = append(errs, errors.New("HealthCheck durations must not be negative"))
+	}
+	if h.SuccessThreshold < 0 || h.FailureThreshold < 0 {
+		errs = append(errs, errors.New("HealthCheck thresholds must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateProbePort(port string) error {
+	if port == "" {
+		return nil
+	}
+
+	proto, num := nat.SplitProtoPort(port)
+	if _, err := nat.NewPort(proto, num); err != nil || num == "" {
+		return fmt.Errorf("invalid HealthCheck Port %q", port)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package task
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckUnmarshalJSON(t *testing.T) {
+	var legacy Task
+	if err := json.Unmarshal([]byte(`{"HealthCheck": "/health"}`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.HealthCheck.HTTP == nil || legacy.HealthCheck.HTTP.Path != "/health" {
+		t.Errorf("expected an HTTP probe for /health, got %+v", legacy.HealthCheck)
+	}
+
+	var structured Task
+	if err := json.Unmarshal([]byte(`{"HealthCheck": {"TCP": {"Port": "5432"}, "FailureThreshold": 5}}`), &structured); err != nil {
+		t.Fatal(err)
+	}
+	if structured.HealthCheck.TCP == nil || structured.HealthCheck.TCP.Port != "5432" {
+		t.Errorf("expected a TCP probe for 5432, got %+v", structured.HealthCheck)
+	}
+	if structured.HealthCheck.Failures() != 5 {
+		t.Errorf("expected failure threshold 5, got %d", structured.HealthCheck.Failures())
+	}
+
+	var empty Task
+	if err := json.Unmarshal([]byte(`{"HealthCheck": ""}`), &empty); err != nil {
+		t.Fatal(err)
+	}
+	if empty.HealthCheck.Configured() {
+		t.Errorf("expected no probe, got %+v", empty.HealthCheck)
+	}
+}
+
+func TestRecordHealth(t *testing.T) {
+	tk := Task{}
+	now := time.Now()
+
+	tk.RecordHealth(now)
+	if len(tk.HealthHistory) != 0 {
+		t.Fatalf("expected a task without health not to be recorded, got %d", len(tk.HealthHistory))
+	}
+
+	tk.Health = HealthStarting
+	tk.RecordHealth(now)
+	tk.RecordHealth(now)
+	if len(tk.HealthHistory) != 1 {
+		t.Fatalf("expected an unchanged health to be recorded once, got %d", len(tk.HealthHistory))
+	}
+
+	for i := range maxHealthHistory + 5 {
+		tk.Ready = i%2 == 0
+		tk.RecordHealth(now.Add(time.Duration(i) * time.Second))
+	}
+	if len(tk.HealthHistory) != maxHealthHistory {
+		t.Errorf("expected %d records, got %d", maxHealthHistory, len(tk.HealthHistory))
+	}
+	if last := tk.HealthHistory[maxHealthHistory-1]; last.Ready != tk.Ready {
+		t.Errorf("expected the latest change to be kept")
+	}
+}
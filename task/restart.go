@@ -0,0 +1,81 @@
+package task
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	RestartAlways    = "Always"
+	RestartOnFailure = "OnFailure"
+	RestartNever     = "Never"
+)
+
+const (
+	// MaxRetriesを指定しなかった場合の再起動回数の上限
+	DefaultMaxRetries = 3
+
+	restartBackoffBase = 10 * time.Second
+	restartBackoffMax  = 5 * time.Minute
+)
+
+// 以前はDockerのリスタートポリシーをそのまま指定していたので、その値も受け付ける。
+// 保存済みのタスクに残っている値もここで読み替えるので移行は要らない。
+// 以前に作ったコンテナにはDockerのポリシーが残っているため、マネージャーの再起動と重ならないよう作り直すこと
+var restartPolicies = map[string]string{
+	"":               "",
+	RestartAlways:    RestartAlways,
+	RestartOnFailure: RestartOnFailure,
+	RestartNever:     RestartNever,
+	"always":         RestartAlways,
+	"unless-stopped": RestartAlways,
+	"on-failure":     RestartOnFailure,
+	"no":             RestartNever,
+}
+
+// Restart は正規化したリスタートポリシーを返す。未指定の場合、サービスはAlways、ジョブはOnFailure
+func (t *Task) Restart() string {
+	if p := restartPolicies[t.RestartPolicy]; p != "" {
+		return p
+	}
+
+	if t.IsJob() {
+		return RestartOnFailure
+	}
+
+	return RestartAlways
+}
+
+// RetryLimit は再起動する回数の上限を返す。ジョブはBackoffLimitに従う
+func (t *Task) RetryLimit() int {
+	if t.IsJob() {
+		return t.BackoffLimit
+	}
+
+	if t.MaxRetries > 0 {
+		return t.MaxRetries
+	}
+
+	return DefaultMaxRetries
+}
+
+// ExitState はコンテナが終了コードexitCodeで終了した時のタスクの状態を返す
+func (t *Task) ExitState(exitCode int) State {
+	if exitCode == 0 && (t.IsJob() || t.Restart() != RestartAlways) {
+		return Completed
+	}
+
+	return Failed
+}
+
+// RestartBackoff はrestarts回目の再起動の後、次の再起動まで待つ時間を返す
+func RestartBackoff(restarts int) time.Duration {
+	d := restartBackoffBase
+	for i := 1; i < restarts && d < restartBackoffMax; i++ {
+		d *= 2
+	}
+	d = min(d, restartBackoffMax)
+
+	// 同時に失敗したタスクが一斉に再起動しないように最大で半分までずらす
+	return d/2 + rand.N(d/2+1)
+}
@@ -0,0 +1,50 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	tests := []struct {
+		restarts int
+		max      time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{10, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		for range 100 {
+			d := RestartBackoff(tt.restarts)
+			if d < tt.max/2 || d > tt.max {
+				t.Fatalf("RestartBackoff(%d) = %v, want between %v and %v", tt.restarts, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestRestart(t *testing.T) {
+	tests := []struct {
+		name string
+		task Task
+		want string
+	}{
+		{"service default", Task{}, RestartAlways},
+		{"job default", Task{Mode: ModeJob}, RestartOnFailure},
+		{"legacy docker value", Task{RestartPolicy: "on-failure"}, RestartOnFailure},
+		{"legacy unless-stopped", Task{RestartPolicy: "unless-stopped"}, RestartAlways},
+		{"legacy no", Task{RestartPolicy: "no", Mode: ModeJob}, RestartNever},
+		{"never", Task{RestartPolicy: RestartNever}, RestartNever},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.task.Restart(); got != tt.want {
+				t.Errorf("Restart() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package task
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+type Runtime interface {
+	Run(c Config) RuntimeResult
+	Stop(id string) RuntimeResult
+	// Remove はStopと同じくコンテナを止めて削除するが、ボリュームはポリシーに関わらず残す。再起動の前に使う
+	Remove(id string) RuntimeResult
+	Inspect(id string) InspectResult
+	Logs(id string, opts LogsOptions) (io.ReadCloser, error)
+	Stats(id string) StatsResult
+	Exec(id string, opts ExecOptions) (*ExecSession, error)
+}
+
+const (
+	StatusCreated = "created"
+	StatusRunning = "running"
+	StatusExited  = "exited"
+)
+
+type RuntimeResult struct {
+	Error       error
+	Action      string
+	ContainerId string
+	Pid         int
+	Result      string
+}
+
+type InspectResult struct {
+	Error      error
+	Status     string
+	ExitCode   int
+	FinishedAt time.Time
+	Ports      nat.PortMap
+}
+
+type LogsOptions struct {
+	Follow bool
+	Tail   string
+	Since  string
+	Stdout bool
+	Stderr bool
+}
+
+type StatsResult struct {
+	Error       error
+	CpuUsage    uint64
+	MemoryUsage uint64
+	MemoryLimit uint64
+}
+
+func NewRuntime(name string) (Runtime, error) {
+	switch name {
+	case "docker":
+		return NewDocker()
+	case "exec":
+		return NewExec()
+	default:
+		return nil, fmt.Errorf("unknown runtime %s", name)
+	}
+}
@@ -1,5 +1,12 @@
 package task
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type State int
 
 const (
@@ -29,10 +36,62 @@ func (s State) String() string {
 	return str
 }
 
+// ParseState は"Running"のような状態の名前を状態に変換する。大文字と小文字は区別しない
+func ParseState(name string) (State, error) {
+	for s := Pending; s <= Failed; s++ {
+		if strings.EqualFold(s.String(), name) {
+			return s, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown state %q", name)
+}
+
+// MarshalText は状態を名前で書き出す。JSONでも数値ではなく名前になる
+func (s State) MarshalText() ([]byte, error) {
+	if name := s.String(); name != "" {
+		return []byte(name), nil
+	}
+
+	return []byte(strconv.Itoa(int(s))), nil
+}
+
+// UnmarshalText は名前と、以前の形式の数値のどちらも受け付ける
+func (s *State) UnmarshalText(text []byte) error {
+	if n, err := strconv.Atoi(string(text)); err == nil {
+		*s = State(n)
+		return nil
+	}
+
+	state, err := ParseState(string(text))
+	if err != nil {
+		return err
+	}
+	*s = state
+
+	return nil
+}
+
+// UnmarshalJSON は以前のクライアントが送る"State": 2のような数値も受け付ける
+func (s *State) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*s = State(n)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("state must be a name or a number: %s", data)
+	}
+
+	return s.UnmarshalText([]byte(name))
+}
+
 var stateTransitionMap = map[State][]State{
 	Pending:   {Scheduled},
 	Scheduled: {Scheduled, Running, Failed},
-	Running:   {Running, Completed, Failed},
+	Running:   {Scheduled, Running, Completed, Failed},
 	Completed: {Completed},
 	Failed:    {Scheduled},
 }
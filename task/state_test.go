@@ -0,0 +1,60 @@
+package task
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStateJSON(t *testing.T) {
+	data, err := json.Marshal(TaskEvent{State: Running, Task: Task{State: Failed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		State any
+		Task  struct{ State any }
+	}
+	json.Unmarshal(data, &raw)
+	if raw.State != "Running" || raw.Task.State != "Failed" {
+		t.Errorf("expected states to be written by name, got %v and %v", raw.State, raw.Task.State)
+	}
+
+	var te TaskEvent
+	if err := json.Unmarshal(data, &te); err != nil {
+		t.Fatal(err)
+	}
+	if te.State != Running || te.Task.State != Failed {
+		t.Errorf("expected states to round trip, got %v and %v", te.State, te.Task.State)
+	}
+}
+
+func TestStateUnmarshal(t *testing.T) {
+	tests := []struct {
+		input string
+		want  State
+	}{
+		{`2`, Running},
+		{`"Completed"`, Completed},
+		{`"scheduled"`, Scheduled},
+		{`"4"`, Failed},
+	}
+
+	for _, tt := range tests {
+		var s State
+		if err := json.Unmarshal([]byte(tt.input), &s); err != nil {
+			t.Errorf("%s: %v", tt.input, err)
+			continue
+		}
+		if s != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.input, tt.want, s)
+		}
+	}
+
+	for _, input := range []string{`"Sleeping"`, `true`} {
+		var s State
+		if err := json.Unmarshal([]byte(input), &s); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package task
 
 import (
+	"cube/taints"
 	"time"
 
 	"github.com/docker/go-connections/nat"
@@ -8,22 +9,85 @@ import (
 )
 
 type Task struct {
-	ID            uuid.UUID
-	ContainerID   string
-	Name          string
-	State         State
-	Image         string
-	Memory        int
-	Disk          int
-	ExposedPorts  nat.PortSet
-	HostPorts     nat.PortMap
-	PortBindings  map[string]string
-	RestartPolicy string
-	StartTime     time.Time
-	FinishTime    time.Time
-	HealthCheck   string
-	RestartCount  int
-	ScheduledOn   string
+	ID              uuid.UUID
+	ContainerID     string
+	Pid             int
+	Name            string
+	State           State
+	Image           string
+	Cmd             []string
+	Env             []string
+	Cpu             float64
+	Memory          int
+	Disk            int
+	ExposedPorts    nat.PortSet
+	HostPorts       nat.PortMap
+	PortBindings    map[string]string
+	Volumes         []Volume
+	VolumePolicy    string
+	RestartPolicy   string
+	StartTime       time.Time
+	FinishTime      time.Time
+	ExitCode        int
+	HealthCheck     *HealthCheck
+	ReadinessCheck  *HealthCheck
+	StartupCheck    *HealthCheck
+	Ready           bool
+	Health          string
+	HealthMessage   string
+	HealthHistory   []HealthRecord
+	RestartCount    int
+	MaxRetries      int
+	NextRetry       time.Time
+	ScheduledOn     string
+	ServiceID       uuid.UUID
+	ServiceRevision int
+	Mode            string
+	BackoffLimit    int
+	Labels          map[string]string
+	// タスクを動かすノードのラベルのセレクタ。"disk=ssd"のように書く
+	NodeSelector string
+	Affinity     *Affinity
+	Tolerations  []taints.Toleration
+	// どのノードにも置けずに待っている理由
+	PendingReason string
+}
+
+const (
+	// 終了しても失敗として再起動される、常駐するタスク
+	ModeService = "service"
+	// 終了コード0で終了したら完了とするタスク
+	ModeJob = "job"
+)
+
+func (t *Task) IsJob() bool {
+	return t.Mode == ModeJob
+}
+
+// Spec はタスクの仕様だけを残したコピーを返す。IDと実行時の状態は空にする
+func (t *Task) Spec() Task {
+	s := *t
+
+	s.ID = uuid.Nil
+	s.ContainerID = ""
+	s.Pid = 0
+	s.State = Pending
+	s.HostPorts = nil
+	s.StartTime = time.Time{}
+	s.FinishTime = time.Time{}
+	s.ExitCode = 0
+	s.Ready = false
+	s.Health = ""
+	s.HealthMessage = ""
+	s.HealthHistory = nil
+	s.RestartCount = 0
+	s.NextRetry = time.Time{}
+	s.ScheduledOn = ""
+	s.PendingReason = ""
+	s.ServiceID = uuid.Nil
+	s.ServiceRevision = 0
+
+	return s
 }
 
 type TaskEvent struct {
@@ -32,3 +96,14 @@ type TaskEvent struct {
 	Timestamp time.Time
 	Task      Task
 }
+
+// BoundHostPorts は PortBindings で要求しているホスト側のポートを "7777/tcp" の形式で返す
+func (t *Task) BoundHostPorts() []string {
+	var ports []string
+	for p, hp := range t.PortBindings {
+		proto, _ := nat.SplitProtoPort(p)
+		ports = append(ports, hp+"/"+proto)
+	}
+
+	return ports
+}
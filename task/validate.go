@@ -0,0 +1,168 @@
+package task
+
+import (
+	"cube/labels"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// Dockerが受け付けるメモリ制限の最小値
+const minMemory = 6 * 1024 * 1024
+
+var modes = []string{"", ModeService, ModeJob}
+
+var volumePolicies = []string{"", VolumePolicyRetain, VolumePolicyDelete}
+
+func (t *Task) Validate() error {
+
+	var errs []error
+
+	if t.Image == "" && len(t.Cmd) == 0 {
+		errs = append(errs, errors.New("either Image or Cmd is required"))
+	}
+
+	if t.Cpu < 0 {
+		errs = append(errs, fmt.Errorf("Cpu must not be negative: %v", t.Cpu))
+	}
+
+	if t.Memory < 0 {
+		errs = append(errs, fmt.Errorf("Memory must not be negative: %d", t.Memory))
+	} else if t.Memory > 0 && t.Memory < minMemory {
+		errs = append(errs, fmt.Errorf("Memory must be at least %d bytes: %d", minMemory, t.Memory))
+	}
+
+	if t.Disk < 0 {
+		errs = append(errs, fmt.Errorf("Disk must not be negative: %d", t.Disk))
+	}
+
+	if _, ok := restartPolicies[t.RestartPolicy]; !ok {
+		errs = append(errs, fmt.Errorf("unknown RestartPolicy %q", t.RestartPolicy))
+	} else if t.IsJob() && t.Restart() == RestartAlways {
+		errs = append(errs, errors.New("RestartPolicy Always cannot be used for jobs"))
+	}
+
+	if t.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("MaxRetries must not be negative: %d", t.MaxRetries))
+	}
+
+	if err := labels.Validate(t.Labels); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := labels.Parse(t.NodeSelector); err != nil {
+		errs = append(errs, fmt.Errorf("NodeSelector: %v", err))
+	}
+
+	if err := t.Affinity.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i, tol := range t.Tolerations {
+		if err := tol.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("Tolerations[%d]: %v", i, err))
+		}
+	}
+
+	for _, e := range t.Env {
+		k, _, ok := strings.Cut(e, "=")
+		if !ok || k == "" {
+			errs = append(errs, fmt.Errorf("Env must be in KEY=VALUE form: %q", e))
+		}
+	}
+
+	for p := range t.ExposedPorts {
+		if _, err := nat.ParsePort(p.Port()); err != nil {
+			errs = append(errs, fmt.Errorf("invalid exposed port %q", p))
+		}
+	}
+
+	for p, hp := range t.PortBindings {
+		proto, port := nat.SplitProtoPort(p)
+		if _, err := nat.NewPort(proto, port); err != nil {
+			errs = append(errs, fmt.Errorf("invalid port binding %q: %v", p, err))
+		}
+
+		n, err := strconv.Atoi(hp)
+		if err != nil || n < 1 || n > 65535 {
+			errs = append(errs, fmt.Errorf("invalid host port %q for %s", hp, p))
+		}
+	}
+
+	for _, v := range t.Volumes {
+		if err := v.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	probes := []struct {
+		name string
+		hc   *HealthCheck
+	}{
+		{"HealthCheck", t.HealthCheck},
+		{"ReadinessCheck", t.ReadinessCheck},
+		{"StartupCheck", t.StartupCheck},
+	}
+	for _, p := range probes {
+		if p.hc == nil {
+			continue
+		}
+		if err := p.hc.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.name, err))
+		}
+	}
+
+	if !containsString(modes, t.Mode) {
+		errs = append(errs, fmt.Errorf("unknown Mode %q", t.Mode))
+	}
+
+	if t.BackoffLimit < 0 {
+		errs = append(errs, fmt.Errorf("BackoffLimit must not be negative: %d", t.BackoffLimit))
+	}
+
+	if !containsString(volumePolicies, t.VolumePolicy) {
+		errs = append(errs, fmt.Errorf("unknown VolumePolicy %q", t.VolumePolicy))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (v Volume) validate() error {
+
+	if !path.IsAbs(v.Target) {
+		return fmt.Errorf("volume Target must be an absolute path: %q", v.Target)
+	}
+
+	switch v.Type {
+	case VolumeTypeVolume:
+	case VolumeTypeBind:
+		if !path.IsAbs(v.Source) {
+			return fmt.Errorf("bind mount Source must be an absolute path: %q", v.Source)
+		}
+	case VolumeTypeTmpfs:
+		if v.Source != "" {
+			return fmt.Errorf("tmpfs mount %s must not have a Source", v.Target)
+		}
+		if v.Size < 0 {
+			return fmt.Errorf("tmpfs Size must not be negative: %d", v.Size)
+		}
+	default:
+		return fmt.Errorf("unknown volume Type %q", v.Type)
+	}
+
+	return nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
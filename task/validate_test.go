@@ -0,0 +1,84 @@
+package task
+
+import (
+	"cube/taints"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    Task
+		wantErr bool
+	}{
+		{"image only", Task{Image: "alpine"}, false},
+		{"cmd only", Task{Cmd: []string{"/bin/true"}}, false},
+		{"full spec", Task{
+			Image:         "alpine",
+			Cmd:           []string{"echo", "hello"},
+			Env:           []string{"FOO=bar", "EMPTY="},
+			Cpu:           0.5,
+			Memory:        64 * 1024 * 1024,
+			Disk:          1024,
+			RestartPolicy: "on-failure",
+			ExposedPorts:  nat.PortSet{"7777/tcp": struct{}{}},
+			PortBindings:  map[string]string{"7777/tcp": "7777"},
+		}, false},
+		{"no image or cmd", Task{}, true},
+		{"negative cpu", Task{Image: "alpine", Cpu: -1}, true},
+		{"negative memory", Task{Image: "alpine", Memory: -1}, true},
+		{"memory below minimum", Task{Image: "alpine", Memory: 1024}, true},
+		{"negative disk", Task{Image: "alpine", Disk: -1}, true},
+		{"unknown restart policy", Task{Image: "alpine", RestartPolicy: "sometimes"}, true},
+		{"env without value", Task{Image: "alpine", Env: []string{"FOO"}}, true},
+		{"env without key", Task{Image: "alpine", Env: []string{"=bar"}}, true},
+		{"invalid exposed port", Task{Image: "alpine", ExposedPorts: nat.PortSet{"http/tcp": struct{}{}}}, true},
+		{"invalid host port", Task{Image: "alpine", PortBindings: map[string]string{"7777/tcp": "70000"}}, true},
+		{"volumes", Task{Image: "alpine", VolumePolicy: VolumePolicyDelete, Volumes: []Volume{
+			{Type: VolumeTypeVolume, Source: "data", Target: "/data"},
+			{Type: VolumeTypeBind, Source: "/srv/config", Target: "/etc/app", ReadOnly: true},
+			{Type: VolumeTypeTmpfs, Target: "/tmp", Size: 64 * 1024 * 1024},
+		}}, false},
+		{"relative volume target", Task{Image: "alpine", Volumes: []Volume{{Type: VolumeTypeVolume, Target: "data"}}}, true},
+		{"relative bind source", Task{Image: "alpine", Volumes: []Volume{{Type: VolumeTypeBind, Source: "srv", Target: "/srv"}}}, true},
+		{"tmpfs with source", Task{Image: "alpine", Volumes: []Volume{{Type: VolumeTypeTmpfs, Source: "/tmp", Target: "/tmp"}}}, true},
+		{"unknown volume type", Task{Image: "alpine", Volumes: []Volume{{Type: "nfs", Target: "/data"}}}, true},
+		{"unknown volume policy", Task{Image: "alpine", VolumePolicy: "Recycle"}, true},
+		{"job", Task{Image: "alpine", Mode: ModeJob, BackoffLimit: 3}, false},
+		{"unknown mode", Task{Image: "alpine", Mode: "cron"}, true},
+		{"negative backoff limit", Task{Image: "alpine", Mode: ModeJob, BackoffLimit: -1}, true},
+		{"restart policy", Task{Image: "alpine", RestartPolicy: RestartOnFailure, MaxRetries: 5}, false},
+		{"job with always", Task{Image: "alpine", Mode: ModeJob, RestartPolicy: RestartAlways}, true},
+		{"negative max retries", Task{Image: "alpine", MaxRetries: -1}, true},
+		{"labels", Task{Image: "alpine", Labels: map[string]string{"env": "prod"}, NodeSelector: "disk in (ssd,nvme)"}, false},
+		{"invalid label", Task{Image: "alpine", Labels: map[string]string{"env": "a b"}}, true},
+		{"invalid node selector", Task{Image: "alpine", NodeSelector: "disk in ssd"}, true},
+		{"affinity", Task{Image: "alpine", Affinity: &Affinity{
+			Node:     []AffinityTerm{{Selector: "disk=ssd", Required: true}},
+			AntiTask: []AffinityTerm{{Selector: "app=web", Weight: 100}},
+		}}, false},
+		{"affinity without selector", Task{Image: "alpine", Affinity: &Affinity{Node: []AffinityTerm{{Required: true}}}}, true},
+		{"tolerations", Task{Image: "alpine", Tolerations: []taints.Toleration{{Key: "dedicated", Value: "team-a", Effect: taints.NoSchedule}, {Operator: taints.OpExists}}}, false},
+		{"toleration without key", Task{Image: "alpine", Tolerations: []taints.Toleration{{Value: "team-a"}}}, true},
+		{"toleration with unknown effect", Task{Image: "alpine", Tolerations: []taints.Toleration{{Key: "a", Effect: "Never"}}}, true},
+		{"preferred affinity without weight", Task{Image: "alpine", Affinity: &Affinity{Task: []AffinityTerm{{Selector: "app=db"}}}}, true},
+		{"tcp health check", Task{Image: "alpine", HealthCheck: &HealthCheck{TCP: &TCPProbe{Port: "5432"}, FailureThreshold: 3}}, false},
+		{"health check without port", Task{Image: "alpine", HealthCheck: &HealthCheck{TCP: &TCPProbe{}}}, true},
+		{"multiple health checks", Task{Image: "alpine", HealthCheck: &HealthCheck{
+			HTTP: &HTTPProbe{Path: "/health"},
+			Exec: &ExecProbe{Cmd: []string{"true"}},
+		}}, true},
+		{"health check path", Task{Image: "alpine", HealthCheck: &HealthCheck{HTTP: &HTTPProbe{Path: "health"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.task.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package task
+
+const (
+	VolumeTypeVolume = "volume"
+	VolumeTypeBind   = "bind"
+	VolumeTypeTmpfs  = "tmpfs"
+)
+
+// コンテナ削除時のボリュームの扱い。
+// 指定しない場合は匿名ボリュームだけを削除する。Retainはすべて残し、Deleteは名前付きボリュームも削除する
+const (
+	VolumePolicyRetain = "Retain"
+	VolumePolicyDelete = "Delete"
+)
+
+type Volume struct {
+	Type     string
+	Source   string
+	Target   string
+	ReadOnly bool
+	Size     int64
+}
@@ -0,0 +1,173 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"cube/task"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// LegacyStates は以前のクライアントのために、レスポンスのJSONに含まれる状態の名前を数値に戻す。
+// ストリーミングや接続の切り替えを行うレスポンスはそのまま返す
+func LegacyStates(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw := &legacyWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+		lw.finish()
+	})
+}
+
+type legacyWriter struct {
+	http.ResponseWriter
+	buf         bytes.Buffer
+	status      int
+	passthrough bool
+}
+
+func (l *legacyWriter) WriteHeader(code int) {
+	if l.passthrough {
+		l.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if l.status == 0 {
+		l.status = code
+	}
+}
+
+func (l *legacyWriter) Write(b []byte) (int, error) {
+	if l.passthrough {
+		return l.ResponseWriter.Write(b)
+	}
+	if l.status == 0 {
+		l.status = http.StatusOK
+	}
+	return l.buf.Write(b)
+}
+
+// Flush はそれまでの内容を書き出し、以降は変換せずにそのまま返す
+func (l *legacyWriter) Flush() {
+	l.writeThrough(l.buf.Bytes())
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (l *legacyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := l.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection does not support hijacking")
+	}
+	l.passthrough = true
+	return hj.Hijack()
+}
+
+func (l *legacyWriter) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
+
+func (l *legacyWriter) finish() {
+	body := l.buf.Bytes()
+	if ct := l.Header().Get("Content-Type"); !l.passthrough && (ct == "" || strings.HasPrefix(ct, "application/json")) {
+		if converted, err := legacyJSON(body); err == nil {
+			body = converted
+		}
+	}
+	l.writeThrough(body)
+}
+
+func (l *legacyWriter) writeThrough(body []byte) {
+	if l.passthrough {
+		return
+	}
+	l.passthrough = true
+
+	if l.status != 0 {
+		l.ResponseWriter.WriteHeader(l.status)
+	}
+	if len(body) > 0 {
+		l.ResponseWriter.Write(body)
+	}
+	l.buf.Reset()
+}
+
+func legacyJSON(data []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("response has more than one JSON value")
+	}
+
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(legacyStates(v))
+	return buf.Bytes(), err
+}
+
+// タスクとイベントはすべてのフィールドを出力するので、キーが型のフィールドと一致するオブジェクトをタスクかイベントとみなす
+var legacyStateTypes = []map[string]bool{
+	jsonFields(reflect.TypeOf(task.Task{})),
+	jsonFields(reflect.TypeOf(task.TaskEvent{})),
+}
+
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+	for i := range t.NumField() {
+		if f := t.Field(i); f.IsExported() {
+			fields[f.Name] = true
+		}
+	}
+	return fields
+}
+
+func hasLegacyState(v map[string]any) bool {
+	for _, fields := range legacyStateTypes {
+		if len(v) != len(fields) {
+			continue
+		}
+		match := true
+		for k := range v {
+			if !fields[k] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyStates はタスクとイベントのStateの値が状態の名前であれば数値に置き換える。
+// ラベルなど他のオブジェクトの"State"はそのまま残す
+func legacyStates(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		convert := hasLegacyState(v)
+		for k, child := range v {
+			if name, ok := child.(string); ok && convert && k == "State" {
+				if s, err := task.ParseState(name); err == nil {
+					v[k] = int(s)
+					continue
+				}
+			}
+			v[k] = legacyStates(child)
+		}
+	case []any:
+		for i := range v {
+			v[i] = legacyStates(v[i])
+		}
+	}
+
+	return v
+}
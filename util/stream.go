@@ -0,0 +1,31 @@
+package util
+
+import (
+	"io"
+	"net/http"
+)
+
+// StreamResponse はrの内容を読めた分だけ順次クライアントへ送る
+func StreamResponse(w http.ResponseWriter, r io.Reader) error {
+
+	f, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if f != nil {
+				f.Flush()
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IsUpgrade はrが生のTCPストリームへの切り替えを要求しているかを返す
+func IsUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "tcp") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// Hijack はクライアントとの接続を取り出し、101 Switching Protocolsを返す
+func Hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection does not support hijacking")
+	}
+
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, buf, nil
+}
+
+// PostUpgrade はbodyをPOSTして接続の切り替えを要求する。
+// 切り替わった場合は双方向のストリームを返し、そうでない場合はレスポンスをそのまま返す
+func PostUpgrade(ctx context.Context, url string, body io.Reader) (*http.Response, io.ReadWriteCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return resp, nil, nil
+	}
+
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, nil, errors.New("response body is not writable")
+	}
+
+	return resp, rwc, nil
+}
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"cube/util"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -17,19 +18,35 @@ type Api struct {
 
 func (a *Api) initRouter() {
 	a.Router = chi.NewRouter()
-	a.Router.Route("/tasks", func(r chi.Router) {
+	a.Router.Route("/v1", a.routes)
+	// バージョンのないパスは以前のクライアントのために状態を数値で返す
+	a.Router.Group(func(r chi.Router) {
+		r.Use(util.LegacyStates)
+		a.routes(r)
+	})
+}
+
+func (a *Api) routes(r chi.Router) {
+	r.Route("/tasks", func(r chi.Router) {
 		r.Post("/", a.StartTaskHanndler)
 		r.Get("/", a.GetTaskHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Post("/exec", a.ExecTaskHandler)
 		})
 	})
 
-	a.Router.Route("/stats", func(r chi.Router) {
+	r.Route("/stats", func(r chi.Router) {
 		r.Get("/", a.GetStatsHandler)
 	})
 }
 
+func (a *Api) Handler() http.Handler {
+	a.initRouter()
+	return a.Router
+}
+
 func (a *Api) Start() {
 	a.initRouter()
 	http.ListenAndServe(fmt.Sprintf("%s:%d", a.Address, a.Port), a.Router)
@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"cube/task"
+	"io"
+	"log"
+	"sync"
+)
+
+// serveExec はexecストリームのフレームとsessionの入出力を中継する。
+// コマンドの出力が終わったら終了コードを送って戻る。接続が切れた場合はセッションを閉じてコマンドを止める
+func serveExec(conn io.ReadWriter, session *task.ExecSession) {
+	defer session.Close()
+
+	go func() {
+		for {
+			typ, payload, err := task.ReadFrame(conn)
+			if err != nil {
+				session.Close()
+				return
+			}
+
+			switch typ {
+			case task.FrameStdin:
+				if session.Stdin != nil {
+					session.Stdin.Write(payload)
+				}
+			case task.FrameCloseStdin:
+				if session.Stdin != nil {
+					session.Stdin.Close()
+				}
+			case task.FrameResize:
+				height, width, err := task.ParseResizePayload(payload)
+				if err == nil {
+					err = session.Resize(height, width)
+				}
+				if err != nil {
+					log.Printf("Error resizing exec session: %v", err)
+				}
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	forward := func(r io.Reader, typ byte) {
+		defer wg.Done()
+		io.Copy(&task.FrameWriter{W: conn, Type: typ, Mu: &mu}, r)
+	}
+
+	wg.Add(1)
+	go forward(session.Stdout, task.FrameStdout)
+	if session.Stderr != nil {
+		wg.Add(1)
+		go forward(session.Stderr, task.FrameStderr)
+	}
+	wg.Wait()
+
+	code, err := session.Wait()
+	if err != nil {
+		log.Printf("Error waiting for exec session: %v", err)
+		code = -1
+	}
+
+	mu.Lock()
+	task.WriteFrame(conn, task.FrameExit, task.ExitPayload(code))
+	mu.Unlock()
+}
@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"cube/task"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeExecClosesSessionWhenClientDisconnects(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("exec")
+	f.Script(tk.Name, task.FakeBehavior{ExecBlock: true})
+	w.AddTask(tk)
+	result := w.runTask()
+	if result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+
+	session, err := w.Runtime.Exec(result.ContainerId, task.ExecOptions{Cmd: []string{"sleep", "infinity"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		serveExec(server, session)
+		close(done)
+	}()
+
+	client.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the exec session to end after the client disconnected")
+	}
+}
+
+func TestExecTaskHandlerClosesSessionWhenHijackFails(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("exec")
+	f.Script(tk.Name, task.FakeBehavior{ExecBlock: true})
+	w.AddTask(tk)
+	result := w.runTask()
+	if result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+
+	// ResponseRecorderは接続を取り出せない
+	req := httptest.NewRequest("POST", fmt.Sprintf("/v1/tasks/%s/exec", tk.ID), strings.NewReader(`{"Cmd": ["sh"]}`))
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	api := Api{Worker: w}
+	api.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	fc, _ := f.Container(result.ContainerId)
+	if len(fc.Execs) != 1 || fc.OpenExecs != 0 {
+		t.Errorf("expected the exec session to be closed, %d of %d open", fc.OpenExecs, len(fc.Execs))
+	}
+}
@@ -2,8 +2,11 @@ package worker
 
 import (
 	"cube/task"
+	"cube/util"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 
@@ -36,6 +39,23 @@ func (a *Api) StartTaskHanndler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if te.Task.State == task.Scheduled {
+		err := a.Worker.Ports.Reserve(te.Task.ID, te.Task.BoundHostPorts())
+		var conflict *PortConflictError
+		if errors.As(err, &conflict) {
+			msg := fmt.Sprintf("[Worker] Unable to schedule task %v: %v", te.Task.ID, err)
+			log.Println(msg)
+
+			w.WriteHeader(409)
+			e := ErrResponse{
+				HTTPStatusCode: 409,
+				Message:        msg,
+			}
+			json.NewEncoder(w).Encode(e)
+			return
+		}
+	}
+
 	a.Worker.AddTask(te.Task)
 	log.Printf("Added task %v\n", te.Task.ID)
 	w.WriteHeader(201)
@@ -71,3 +91,109 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Added task %v to stop container %v\n", taskToStop.ID, taskToStop.ContainerID)
 	w.WriteHeader(204)
 }
+
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	t, err := a.Worker.Db.Get(taskID)
+	if err != nil {
+		log.Printf("No task with ID %v found", taskID)
+		w.WriteHeader(404)
+		return
+	}
+
+	q := r.URL.Query()
+	opts := task.LogsOptions{
+		Follow: q.Get("follow") == "true",
+		Tail:   q.Get("tail"),
+		Since:  q.Get("since"),
+		Stdout: q.Get("stdout") != "false",
+		Stderr: q.Get("stderr") != "false",
+	}
+
+	logs, err := a.Worker.Runtime.Logs(t.ContainerID, opts)
+	if err != nil {
+		msg := fmt.Sprintf("[Worker] Error getting logs for task %v: %v", t.ID, err)
+		log.Println(msg)
+
+		w.WriteHeader(500)
+		e := ErrResponse{
+			HTTPStatusCode: 500,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	defer logs.Close()
+
+	// クライアントが切断したらランタイム側の読み込みも止める
+	go func() {
+		<-r.Context().Done()
+		logs.Close()
+	}()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
+	util.StreamResponse(w, logs)
+}
+
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	t, err := a.Worker.Db.Get(taskID)
+	if err != nil {
+		log.Printf("No task with ID %v found", taskID)
+		w.WriteHeader(404)
+		return
+	}
+
+	var opts task.ExecOptions
+	err = json.NewDecoder(r.Body).Decode(&opts)
+	if err == nil && len(opts.Cmd) == 0 {
+		err = errors.New("no command given")
+	}
+	if err == nil && !util.IsUpgrade(r) {
+		err = errors.New("exec requires an upgraded connection")
+	}
+	if err == nil && t.State != task.Running {
+		err = fmt.Errorf("task is in state %v", t.State)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("[Worker] Unable to exec in task %v: %v", t.ID, err)
+		log.Println(msg)
+
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	session, err := a.Worker.Runtime.Exec(t.ContainerID, opts)
+	if err != nil {
+		msg := fmt.Sprintf("[Worker] Error starting exec in task %v: %v", t.ID, err)
+		log.Println(msg)
+
+		w.WriteHeader(500)
+		e := ErrResponse{
+			HTTPStatusCode: 500,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	conn, buf, err := util.Hijack(w)
+	if err != nil {
+		log.Printf("Error hijacking connection: %v", err)
+		session.Close()
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("Started exec %v in task %v", opts.Cmd, t.ID)
+	serveExec(struct {
+		io.Reader
+		io.Writer
+	}{buf, conn}, session)
+}
@@ -0,0 +1,307 @@
+package worker
+
+import (
+	"context"
+	"cube/task"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// ProbeTasksがヘルスチェックの期限を確かめる間隔
+const probeTick = time.Second
+
+// taskProbes はタスクごとのヘルスチェックの結果を保持する。タスクが再起動すると作り直す
+type taskProbes struct {
+	startTime time.Time
+	startup   probeState
+	liveness  probeState
+	readiness probeState
+}
+
+type probeState struct {
+	lastProbe time.Time
+	lastError string
+	successes int
+	failures  int
+	healthy   bool
+	// 確認が終わっていない間は次の確認を始めない
+	running bool
+}
+
+// ProbeTasks は実行中のタスクのヘルスチェックをそれぞれのIntervalで行い、結果をタスクに記録する
+func (w *Worker) ProbeTasks() {
+	for {
+		w.probeTasks(time.Now().UTC())
+		time.Sleep(probeTick)
+	}
+}
+
+func (w *Worker) probeTasks(now time.Time) {
+	tasks, err := w.Db.List()
+	if err != nil {
+		w.Logln("error getting list of tasks: %v\n", err)
+		return
+	}
+
+	for _, t := range tasks {
+		if t.State != task.Running {
+			continue
+		}
+
+		health, message, ready := t.Health, t.HealthMessage, t.Ready
+		w.checkProbes(t, now)
+		if t.Health == health && t.HealthMessage == message && t.Ready == ready {
+			continue
+		}
+
+		// 確認している間に状態が変わっていたら記録しない
+		latest, err := w.Db.Get(t.ID.String())
+		if err != nil || latest.State != task.Running || !latest.StartTime.Equal(t.StartTime) {
+			continue
+		}
+		latest.Health = t.Health
+		latest.HealthMessage = t.HealthMessage
+		latest.Ready = t.Ready
+		w.Db.Put(latest.ID.String(), latest)
+	}
+}
+
+// failed はFailureThreshold回続けて失敗したかを返す
+func (s *probeState) failed(hc *task.HealthCheck) bool {
+	return s.failures >= hc.Failures()
+}
+
+// checkProbes は起動中のタスクにスタートアップ、ライブネス、レディネスの順で確認を始め、
+// 終わった確認の結果をHealthとReadyに記録する。確認が一度も成功していないうちはReadyにしない。
+// 再起動するかどうかはマネージャが決める
+func (w *Worker) checkProbes(t *task.Task, now time.Time) {
+	// ジョブはヘルスチェックしない
+	if t.IsJob() {
+		t.Ready = true
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	p, ok := w.probes[t.ID]
+	if !ok || !p.startTime.Equal(t.StartTime) {
+		p = &taskProbes{
+			startTime: t.StartTime,
+			startup:   probeState{healthy: !t.StartupCheck.Configured()},
+			liveness:  probeState{healthy: !t.HealthCheck.Configured()},
+			readiness: probeState{healthy: !t.ReadinessCheck.Configured()},
+		}
+		w.probes[t.ID] = p
+	}
+
+	if !p.startup.healthy {
+		w.probe(*t, t.StartupCheck, &p.startup, now)
+		if !p.startup.healthy {
+			t.Health = task.HealthStarting
+			if p.startup.failed(t.StartupCheck) {
+				t.Health = task.HealthUnhealthy
+			}
+			t.HealthMessage = p.startup.lastError
+			t.Ready = false
+			return
+		}
+	}
+
+	if t.HealthCheck.Configured() {
+		w.probe(*t, t.HealthCheck, &p.liveness, now)
+		if !p.liveness.healthy {
+			// 最初の確認が成功するまでは正常とはみなさない
+			t.Health = task.HealthStarting
+			if p.liveness.failed(t.HealthCheck) {
+				t.Health = task.HealthUnhealthy
+			}
+			t.HealthMessage = p.liveness.lastError
+			t.Ready = false
+			return
+		}
+	}
+
+	if t.ReadinessCheck.Configured() {
+		w.probe(*t, t.ReadinessCheck, &p.readiness, now)
+	}
+
+	t.Health = ""
+	if t.HealthCheck.Configured() || t.StartupCheck.Configured() {
+		t.Health = task.HealthHealthy
+	}
+	t.HealthMessage = p.readiness.lastError
+	t.Ready = p.readiness.healthy
+}
+
+// probe は必要であればhcでの確認を別のgoroutineで始め、結果をsに記録する。w.muを取った状態で呼ぶ。
+// FailureThreshold回続けて失敗すると異常、SuccessThreshold回続けて成功すると正常とみなす
+func (w *Worker) probe(t task.Task, hc *task.HealthCheck, s *probeState, now time.Time) {
+	if s.running || now.Before(t.StartTime.Add(hc.InitialDelay())) || now.Before(s.lastProbe.Add(hc.Interval())) {
+		return
+	}
+	s.lastProbe = now
+	s.running = true
+
+	w.probing.Add(1)
+	go func() {
+		defer w.probing.Done()
+		err := w.checkTaskHealth(t, hc)
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		s.running = false
+		w.record(t, hc, s, err)
+	}()
+}
+
+func (w *Worker) record(t task.Task, hc *task.HealthCheck, s *probeState, err error) {
+	if err != nil {
+		w.Logln("Health check for task %s failed: %v", t.ID, err)
+		s.lastError = err.Error()
+		s.successes = 0
+		s.failures++
+		if s.failed(hc) {
+			s.healthy = false
+		}
+	} else {
+		s.lastError = ""
+		s.failures = 0
+		s.successes++
+		if s.successes >= hc.Successes() {
+			s.healthy = true
+		}
+	}
+}
+
+func (w *Worker) checkTaskHealth(t task.Task, hc *task.HealthCheck) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.Timeout())
+	defer cancel()
+
+	switch {
+	case hc.HTTP != nil:
+		addr := probeAddr(t.HostPorts, hc.HTTP.Port)
+		if addr == "" {
+			return fmt.Errorf("port %s is not published", hc.HTTP.Port)
+		}
+		return probeHTTP(ctx, fmt.Sprintf("http://%s%s", addr, hc.HTTP.Path), hc.HTTP.Status())
+	case hc.TCP != nil:
+		addr := probeAddr(t.HostPorts, hc.TCP.Port)
+		if addr == "" {
+			return fmt.Errorf("port %s is not published", hc.TCP.Port)
+		}
+		return probeTCP(ctx, addr)
+	case hc.Exec != nil:
+		return w.probeExec(ctx, t, hc.Exec.Cmd)
+	}
+
+	return nil
+}
+
+func probeHTTP(ctx context.Context, url string, status int) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error connecting to health check %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		return fmt.Errorf("health check %s returned %d, expected %d", url, resp.StatusCode, status)
+	}
+
+	return nil
+}
+
+func probeTCP(ctx context.Context, addr string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// probeExec はコンテナ内でcmdを実行し、終了コードを確認する
+func (w *Worker) probeExec(ctx context.Context, t task.Task, cmd []string) error {
+	session, err := w.Runtime.Exec(t.ContainerID, task.ExecOptions{Cmd: cmd})
+	if err != nil {
+		return err
+	}
+	if session.Stdin != nil {
+		session.Stdin.Close()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var wg sync.WaitGroup
+		for _, r := range []io.Reader{session.Stdout, session.Stderr} {
+			if r == nil {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				io.Copy(io.Discard, r)
+			}()
+		}
+		wg.Wait()
+
+		code, err := session.Wait()
+		if err == nil && code != 0 {
+			err = fmt.Errorf("command %v exited with %d", cmd, code)
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// 時間切れのコマンドを動かしたままにしない
+		session.Close()
+		return fmt.Errorf("command %v timed out", cmd)
+	}
+}
+
+// probeAddr はコンテナのポートportに対応するワーカー上のアドレスを返す。portが空の場合は公開しているポートのどれかを使う
+func probeAddr(ports nat.PortMap, port string) string {
+	var bindings []nat.PortBinding
+	if port == "" {
+		for _, b := range ports {
+			if len(b) > 0 {
+				bindings = b
+				break
+			}
+		}
+	} else {
+		proto, num := nat.SplitProtoPort(port)
+		p, err := nat.NewPort(proto, num)
+		if err != nil {
+			return ""
+		}
+		bindings = ports[p]
+	}
+
+	if len(bindings) == 0 {
+		return ""
+	}
+
+	host := bindings[0].HostIP
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, bindings[0].HostPort)
+}
@@ -0,0 +1,363 @@
+package worker
+
+import (
+	"cube/task"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+// newHealthServer はステータスコードstatusを返すヘルスチェック先を立て、そのポートを返す
+func newHealthServer(t *testing.T, status int) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	return u.Port()
+}
+
+// startProbedTask はホストポートhostPortで公開されたタスクを起動する
+func startProbedTask(t *testing.T, w *Worker, f *task.Fake, hostPort string, tk task.Task) task.Task {
+	t.Helper()
+
+	b := task.FakeBehavior{HostPorts: nat.PortMap{
+		"7777/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort}},
+	}}
+	if tk.HealthCheck != nil && tk.HealthCheck.Exec != nil {
+		b.ExecExitCode = 1
+	}
+	f.Script(tk.Name, b)
+
+	w.AddTask(tk)
+	if result := w.runTask(); result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+
+	return tk
+}
+
+// updateAndProbe はタスクを更新し、始まったヘルスチェックが終わるのを待って結果をタスクに記録する
+func updateAndProbe(w *Worker) {
+	w.updateTasks()
+	w.probing.Wait()
+	w.probeTasks(time.Now().UTC())
+	w.probing.Wait()
+}
+
+// probeAgain はIntervalを待たずに次のヘルスチェックを行わせる
+func probeAgain(w *Worker, id uuid.UUID) {
+	if p, ok := w.probes[id]; ok {
+		p.startup.lastProbe = time.Time{}
+		p.liveness.lastProbe = time.Time{}
+		p.readiness.lastProbe = time.Time{}
+	}
+}
+
+func TestUpdateTasksReportsStartingUntilLivenessSucceeds(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("liveness")
+	tk.HealthCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/health"}}
+	tk.ReadinessCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/ready"}}
+	startProbedTask(t, w, f, newHealthServer(t, http.StatusInternalServerError), tk)
+
+	// 確認の結果が出る前
+	w.updateTasks()
+	persisted := getTask(t, w, tk.ID)
+	w.probing.Wait()
+	if persisted.Health != task.HealthStarting || persisted.Ready {
+		t.Fatalf("expected starting task before the first probe, got %q ready=%v", persisted.Health, persisted.Ready)
+	}
+
+	w.probeTasks(time.Now().UTC())
+	w.probing.Wait()
+	probeAgain(w, tk.ID)
+	updateAndProbe(w)
+	if persisted := getTask(t, w, tk.ID); persisted.Health != task.HealthStarting || persisted.Ready {
+		t.Errorf("expected failing task to stay starting before the failure threshold, got %q ready=%v", persisted.Health, persisted.Ready)
+	}
+}
+
+func TestUpdateTasksReportsUnhealthyAfterFailureThreshold(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+
+	tk := newScheduledTask("liveness")
+	tk.HealthCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/health"}}
+	startProbedTask(t, w, f, u.Port(), tk)
+
+	updateAndProbe(w)
+	if persisted := getTask(t, w, tk.ID); persisted.Health != task.HealthHealthy || !persisted.Ready {
+		t.Fatalf("expected healthy task, got %q ready=%v", persisted.Health, persisted.Ready)
+	}
+
+	status.Store(http.StatusInternalServerError)
+	for range 2 {
+		probeAgain(w, tk.ID)
+		updateAndProbe(w)
+	}
+	if persisted := getTask(t, w, tk.ID); persisted.Health != task.HealthHealthy {
+		t.Fatalf("expected task to stay healthy before the failure threshold, got %q", persisted.Health)
+	}
+
+	probeAgain(w, tk.ID)
+	updateAndProbe(w)
+
+	persisted := getTask(t, w, tk.ID)
+	if persisted.Health != task.HealthUnhealthy || persisted.Ready {
+		t.Errorf("expected unhealthy task, got %q ready=%v", persisted.Health, persisted.Ready)
+	}
+	if persisted.HealthMessage == "" {
+		t.Errorf("expected the failure to be reported")
+	}
+	if persisted.State != task.Running {
+		t.Errorf("expected the worker to leave restarts to the manager, got %v", persisted.State)
+	}
+}
+
+func TestUpdateTasksHonorsInitialDelay(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("delay")
+	tk.HealthCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/health"}, InitialDelaySeconds: 60, FailureThreshold: 1}
+	startProbedTask(t, w, f, newHealthServer(t, http.StatusInternalServerError), tk)
+
+	updateAndProbe(w)
+
+	if !w.probes[tk.ID].liveness.lastProbe.IsZero() {
+		t.Errorf("expected no health check during the initial delay")
+	}
+	if persisted := getTask(t, w, tk.ID); persisted.Health == task.HealthUnhealthy {
+		t.Errorf("expected task not to be unhealthy during the initial delay")
+	}
+}
+
+func TestUpdateTasksUsesExpectedStatus(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("status")
+	tk.HealthCheck = &task.HealthCheck{
+		HTTP:             &task.HTTPProbe{Path: "/health", Port: "7777", ExpectedStatus: http.StatusNoContent},
+		FailureThreshold: 1,
+	}
+	startProbedTask(t, w, f, newHealthServer(t, http.StatusNoContent), tk)
+
+	updateAndProbe(w)
+
+	if persisted := getTask(t, w, tk.ID); persisted.Health != task.HealthHealthy || !persisted.Ready {
+		t.Errorf("expected healthy and ready task, got %q ready=%v", persisted.Health, persisted.Ready)
+	}
+}
+
+func TestUpdateTasksProbesTCP(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	tk := newScheduledTask("tcp")
+	tk.HealthCheck = &task.HealthCheck{TCP: &task.TCPProbe{Port: "7777/tcp"}, FailureThreshold: 1}
+	startProbedTask(t, w, f, port, tk)
+
+	updateAndProbe(w)
+	if persisted := getTask(t, w, tk.ID); persisted.Health != task.HealthHealthy {
+		t.Fatalf("expected healthy task, got %q", persisted.Health)
+	}
+
+	l.Close()
+	probeAgain(w, tk.ID)
+	updateAndProbe(w)
+
+	if persisted := getTask(t, w, tk.ID); persisted.Health != task.HealthUnhealthy {
+		t.Errorf("expected unhealthy task after the port closed, got %q", persisted.Health)
+	}
+}
+
+func TestUpdateTasksProbesExec(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("exec")
+	tk.HealthCheck = &task.HealthCheck{Exec: &task.ExecProbe{Cmd: []string{"pg_isready"}}, FailureThreshold: 1}
+	startProbedTask(t, w, f, "", tk)
+
+	updateAndProbe(w)
+
+	persisted := getTask(t, w, tk.ID)
+	if persisted.Health != task.HealthUnhealthy {
+		t.Errorf("expected unhealthy task, got %q", persisted.Health)
+	}
+	fc, _ := f.Container(persisted.ContainerID)
+	if len(fc.Execs) != 1 || fc.Execs[0][0] != "pg_isready" {
+		t.Errorf("expected pg_isready to be executed, got %v", fc.Execs)
+	}
+}
+
+func TestUpdateTasksReportsReadiness(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	ready := newScheduledTask("ready")
+	ready.ReadinessCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/ready"}}
+	startProbedTask(t, w, f, newHealthServer(t, http.StatusOK), ready)
+
+	notReady := newScheduledTask("not-ready")
+	notReady.ReadinessCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/ready"}, FailureThreshold: 1}
+	startProbedTask(t, w, f, newHealthServer(t, http.StatusServiceUnavailable), notReady)
+
+	plain := newScheduledTask("plain")
+	startProbedTask(t, w, f, "", plain)
+
+	updateAndProbe(w)
+
+	if persisted := getTask(t, w, ready.ID); !persisted.Ready {
+		t.Errorf("expected task %s to be ready", ready.ID)
+	}
+	if persisted := getTask(t, w, notReady.ID); persisted.Ready || persisted.Health == task.HealthUnhealthy {
+		t.Errorf("expected task %s to be healthy but not ready, got %q ready=%v", notReady.ID, persisted.Health, persisted.Ready)
+	}
+	if persisted := getTask(t, w, plain.ID); !persisted.Ready || persisted.Health != "" {
+		t.Errorf("expected task without checks to be ready, got %q ready=%v", persisted.Health, persisted.Ready)
+	}
+}
+
+func TestUpdateTasksWaitsForStartup(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("startup")
+	tk.HealthCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/health"}, FailureThreshold: 1}
+	tk.StartupCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/started"}, FailureThreshold: 3}
+	startProbedTask(t, w, f, newHealthServer(t, http.StatusInternalServerError), tk)
+
+	for range 2 {
+		updateAndProbe(w)
+		probeAgain(w, tk.ID)
+	}
+
+	persisted := getTask(t, w, tk.ID)
+	if persisted.Health != task.HealthStarting || persisted.Ready {
+		t.Fatalf("expected starting task, got %q ready=%v", persisted.Health, persisted.Ready)
+	}
+	if !w.probes[tk.ID].liveness.lastProbe.IsZero() {
+		t.Errorf("expected liveness to be suppressed during startup")
+	}
+
+	updateAndProbe(w)
+
+	if persisted := getTask(t, w, tk.ID); persisted.Health != task.HealthUnhealthy {
+		t.Errorf("expected task to be unhealthy after the startup check failed, got %q", persisted.Health)
+	}
+}
+
+func TestUpdateTasksStartsLivenessAfterStartup(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("started")
+	tk.HealthCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/health"}}
+	tk.StartupCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/started"}}
+	startProbedTask(t, w, f, newHealthServer(t, http.StatusOK), tk)
+
+	// 一度目でスタートアップ、二度目でライブネスの結果が記録される
+	updateAndProbe(w)
+	updateAndProbe(w)
+
+	p := w.probes[tk.ID]
+	if !p.startup.healthy || p.liveness.lastProbe.IsZero() {
+		t.Errorf("expected liveness to be checked once startup succeeded")
+	}
+	if persisted := getTask(t, w, tk.ID); persisted.Health != task.HealthHealthy || !persisted.Ready {
+		t.Errorf("expected healthy and ready task, got %q ready=%v", persisted.Health, persisted.Ready)
+	}
+}
+
+func TestProbeTasksUsesEachInterval(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+
+	tk := newScheduledTask("interval")
+	tk.HealthCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/health"}, IntervalSeconds: 2}
+	startProbedTask(t, w, f, u.Port(), tk)
+	w.updateTasks()
+	w.probing.Wait()
+
+	first := w.probes[tk.ID].liveness.lastProbe
+	for _, d := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second} {
+		w.probeTasks(first.Add(d))
+		w.probing.Wait()
+	}
+
+	// 最初の確認から2秒ごとに確認する
+	if got := requests.Load(); got != 3 {
+		t.Errorf("expected 3 health checks, got %d", got)
+	}
+}
+
+func TestProbeTasksDoesNotWaitForSlowProbes(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	u, _ := url.Parse(srv.URL)
+
+	slow := newScheduledTask("slow")
+	slow.HealthCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/health"}, TimeoutSeconds: 5}
+	startProbedTask(t, w, f, u.Port(), slow)
+
+	fast := newScheduledTask("fast")
+	fast.ReadinessCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/ready"}, FailureThreshold: 1}
+	startProbedTask(t, w, f, newHealthServer(t, http.StatusServiceUnavailable), fast)
+	w.updateTasks()
+
+	// 遅いタスクの確認が終わらなくても、他のタスクの結果は記録される
+	deadline := time.Now().Add(2 * time.Second)
+	for getTask(t, w, fast.ID).HealthMessage == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the fast readiness check to be recorded while the slow check is running")
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.probeTasks(time.Now().UTC())
+	}
+}
+
+func TestHTTPProbeFailsWithoutPublishedPort(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("unpublished")
+	tk.HealthCheck = &task.HealthCheck{HTTP: &task.HTTPProbe{Path: "/health", Port: "8080/tcp"}, FailureThreshold: 1}
+	startProbedTask(t, w, f, newHealthServer(t, http.StatusOK), tk)
+
+	updateAndProbe(w)
+
+	if persisted := getTask(t, w, tk.ID); persisted.Health != task.HealthUnhealthy {
+		t.Errorf("expected unhealthy task without a published port, got %q", persisted.Health)
+	}
+}
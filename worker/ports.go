@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type PortConflictError struct {
+	Ports []string
+}
+
+func (e *PortConflictError) Error() string {
+	return fmt.Sprintf("host ports already in use: %s", strings.Join(e.Ports, ", "))
+}
+
+// PortAllocator はワーカー上でタスクが確保しているホストポートを管理する
+type PortAllocator struct {
+	mu    sync.Mutex
+	ports map[string]uuid.UUID
+	// ホスト上で他のプロセスが使っていないか確認する。テストでは差し替える
+	HostPortFree func(port string) bool
+}
+
+func NewPortAllocator() *PortAllocator {
+	return &PortAllocator{
+		ports:        make(map[string]uuid.UUID),
+		HostPortFree: hostPortFree,
+	}
+}
+
+// Reserve はportsを全て確保する。一つでも確保できない場合は何も確保しない
+func (p *PortAllocator) Reserve(id uuid.UUID, ports []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var conflicts []string
+	for _, port := range ports {
+		owner, ok := p.ports[port]
+		if ok && owner != id {
+			conflicts = append(conflicts, port)
+			continue
+		}
+
+		if !ok && p.HostPortFree != nil && !p.HostPortFree(port) {
+			conflicts = append(conflicts, port)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return &PortConflictError{Ports: conflicts}
+	}
+
+	for _, port := range ports {
+		p.ports[port] = id
+	}
+
+	return nil
+}
+
+// Claim は競合を確認せずにportsをidのものとして記録する。既に起動しているタスクの復元に使う
+func (p *PortAllocator) Claim(id uuid.UUID, ports []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, port := range ports {
+		p.ports[port] = id
+	}
+}
+
+func (p *PortAllocator) Release(id uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for port, owner := range p.ports {
+		if owner == id {
+			delete(p.ports, port)
+		}
+	}
+}
+
+func (p *PortAllocator) InUse() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var ports []string
+	for port := range p.ports {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+
+	return ports
+}
+
+func hostPortFree(port string) bool {
+	num, proto, _ := strings.Cut(port, "/")
+
+	if proto == "udp" {
+		c, err := net.ListenPacket("udp", ":"+num)
+		if err != nil {
+			return false
+		}
+		c.Close()
+		return true
+	}
+
+	l, err := net.Listen("tcp", ":"+num)
+	if err != nil {
+		return false
+	}
+	l.Close()
+
+	return true
+}
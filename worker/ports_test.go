@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPortAllocatorDetectsConflicts(t *testing.T) {
+	p := NewPortAllocator()
+	p.HostPortFree = nil
+
+	a, b := uuid.New(), uuid.New()
+	if err := p.Reserve(a, []string{"7777/tcp", "8080/tcp"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 同じタスクが確保し直すのは問題ない
+	if err := p.Reserve(a, []string{"7777/tcp"}); err != nil {
+		t.Errorf("expected reserve by owner to succeed: %v", err)
+	}
+
+	err := p.Reserve(b, []string{"9090/tcp", "8080/tcp"})
+	var conflict *PortConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected a port conflict, got %v", err)
+	}
+	if len(conflict.Ports) != 1 || conflict.Ports[0] != "8080/tcp" {
+		t.Errorf("expected conflict on 8080/tcp, got %v", conflict.Ports)
+	}
+
+	// 確保に失敗した場合は何も確保しない
+	if got := p.InUse(); len(got) != 2 {
+		t.Errorf("expected 2 ports in use, got %v", got)
+	}
+
+	p.Release(a)
+	if err := p.Reserve(b, []string{"8080/tcp"}); err != nil {
+		t.Errorf("expected reserve after release to succeed: %v", err)
+	}
+}
+
+func TestPortAllocatorChecksHost(t *testing.T) {
+	p := NewPortAllocator()
+	p.HostPortFree = func(port string) bool { return port != "22/tcp" }
+
+	if err := p.Reserve(uuid.New(), []string{"22/tcp"}); err == nil {
+		t.Errorf("expected a conflict for a port used on the host")
+	}
+}
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"log"
+	"runtime"
 
 	"github.com/c9s/goprocinfo/linux"
 )
@@ -12,6 +13,7 @@ func GetStats() *Stats {
 		DiskStats: getDiskInfo(),
 		CpuStats:  getCpuStats(),
 		LoadStats: getLoadAverage(),
+		Cores:     runtime.NumCPU(),
 	}
 }
 
@@ -21,6 +23,7 @@ type Stats struct {
 	CpuStats  *linux.CPUStat
 	LoadStats *linux.LoadAvg
 	TaskCount int
+	Cores     int
 }
 
 func (s *Stats) MemTotalKb() uint64 {
@@ -6,9 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
 )
 
 type Worker struct {
@@ -17,12 +21,33 @@ type Worker struct {
 	Db        store.Store[*task.Task]
 	TaskCount int
 	Stats     *Stats
+	Runtime   task.Runtime
+	Ports     *PortAllocator
+	// probesとその中の結果はヘルスチェックのgoroutineからも更新するのでmuで守る
+	mu      sync.Mutex
+	probes  map[uuid.UUID]*taskProbes
+	probing sync.WaitGroup
+	// バインドマウントを許可するホストのパス
+	AllowedHostPaths []string
 }
 
-func New(name string, taskDbType string) (*Worker, error) {
+func New(name string, taskDbType string, runtimeType string) (*Worker, error) {
+	rt, err := task.NewRuntime(runtimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWithRuntime(name, taskDbType, rt)
+}
+
+// NewWithRuntime はランタイムを指定してワーカーを作る。テストではtask.NewFakeを渡す
+func NewWithRuntime(name string, taskDbType string, rt task.Runtime) (*Worker, error) {
 	w := Worker{
-		Name:  name,
-		Queue: *queue.New(),
+		Name:    name,
+		Queue:   *queue.New(),
+		Runtime: rt,
+		Ports:   NewPortAllocator(),
+		probes:  make(map[uuid.UUID]*taskProbes),
 	}
 
 	var s store.Store[*task.Task]
@@ -39,9 +64,22 @@ func New(name string, taskDbType string) (*Worker, error) {
 	}
 
 	w.Db = s
+	w.restorePorts()
+
 	return &w, nil
 }
 
+// restorePorts は永続化されているタスクが確保していたホストポートを確保し直す
+func (w *Worker) restorePorts() {
+	for _, t := range w.GetTasks() {
+		if t.State != task.Scheduled && t.State != task.Running {
+			continue
+		}
+
+		w.Ports.Claim(t.ID, t.BoundHostPorts())
+	}
+}
+
 func (w *Worker) CollectStats() {
 	for {
 		w.Logln("Collecting stats")
@@ -66,12 +104,12 @@ func (w *Worker) AddTask(t task.Task) {
 	w.Queue.Enqueue(t)
 }
 
-func (w *Worker) runTask() task.DockerResult {
+func (w *Worker) runTask() task.RuntimeResult {
 
 	t := w.Queue.Dequeue()
 	if t == nil {
 		w.Logln("no task in the queue")
-		return task.DockerResult{Error: nil}
+		return task.RuntimeResult{Error: nil}
 	}
 
 	taskQueued := t.(task.Task)
@@ -80,17 +118,17 @@ func (w *Worker) runTask() task.DockerResult {
 	if err != nil {
 		msg := fmt.Errorf("error storing task %s: %v", taskQueued.ID.String(), err)
 		w.Logln("%s", msg)
-		return task.DockerResult{Error: msg}
+		return task.RuntimeResult{Error: msg}
 	}
 
 	taskPersisted, err := w.Db.Get(taskQueued.ID.String())
 	if err != nil {
 		msg := fmt.Errorf("error getting task %s from database: %v", taskQueued.ID.String(), err)
 		w.Logln("%s", msg)
-		return task.DockerResult{Error: msg}
+		return task.RuntimeResult{Error: msg}
 	}
 
-	var result task.DockerResult
+	var result task.RuntimeResult
 	if task.ValidStateTransition(
 		taskPersisted.State, taskQueued.State,
 	) {
@@ -109,35 +147,98 @@ func (w *Worker) runTask() task.DockerResult {
 	return result
 }
 
-func (w *Worker) StartTask(t task.Task) task.DockerResult {
+func (w *Worker) StartTask(t task.Task) task.RuntimeResult {
+	// 再起動の場合は前回のコンテナを片付けておかないと同じ名前で作成できない。
+	// ボリュームは次のコンテナで使うので残し、ポリシーはStopTaskでだけ適用する
+	if t.ContainerID != "" {
+		if result := w.Runtime.Remove(t.ContainerID); result.Error != nil {
+			w.Logln("error removing previous container %s: %v", t.ContainerID, result.Error)
+		}
+		t.ContainerID = ""
+	}
+
 	t.StartTime = time.Now().UTC()
+	t.FinishTime = time.Time{}
+	t.ExitCode = 0
+	t.Ready = false
+	t.Health = ""
+	t.HealthMessage = ""
 	config := task.NewConfig(&t)
-	d := task.NewDocker(config)
-	result := d.Run()
+
+	var result task.RuntimeResult
+	if err := w.checkHostPaths(t); err != nil {
+		result.Error = err
+	} else {
+		result = w.Runtime.Run(config)
+	}
 	if result.Error != nil {
 		w.Logln("error staring container %s", result.Error)
+		w.Ports.Release(t.ID)
 		t.State = task.Failed
 		w.Db.Put(t.ID.String(), &t)
 		return result
 	}
 
 	t.ContainerID = result.ContainerId
+	t.Pid = result.Pid
 	t.State = task.Running
 	w.Db.Put(t.ID.String(), &t)
 
 	return result
 }
 
-func (w *Worker) StopTask(t task.Task) task.DockerResult {
-	config := task.NewConfig(&t)
-	d := task.NewDocker(config)
+func (w *Worker) checkHostPaths(t task.Task) error {
+	for _, v := range t.Volumes {
+		if v.Type != task.VolumeTypeBind {
+			continue
+		}
+
+		if !w.hostPathAllowed(v.Source) {
+			return fmt.Errorf("host path %s is not allowed on worker %s", v.Source, w.Name)
+		}
+	}
+
+	return nil
+}
+
+func (w *Worker) hostPathAllowed(p string) bool {
+	// 許可されたディレクトリの中から外を指すシンボリックリンクを通さないよう、実際のパスで比べる
+	p = resolvePath(p)
+	for _, allowed := range w.AllowedHostPaths {
+		allowed = resolvePath(allowed)
+		if p == allowed || strings.HasPrefix(p, allowed+string(filepath.Separator)) || allowed == "/" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolvePath はシンボリックリンクを解決したパスを返す。
+// まだ存在しない部分は、存在する一番近い親ディレクトリを解決した後にそのままつなげる
+func resolvePath(p string) string {
+	p = filepath.Clean(p)
+
+	var rest []string
+	for dir := p; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		}
+		if dir == filepath.Dir(dir) {
+			return p
+		}
+		rest = append([]string{filepath.Base(dir)}, rest...)
+	}
+}
 
-	result := d.Stop(t.ContainerID)
+func (w *Worker) StopTask(t task.Task) task.RuntimeResult {
+	result := w.Runtime.Stop(t.ContainerID)
 	if result.Error != nil {
 		w.Logln("error stopping container %s", result.Error)
 		return result
 	}
 
+	w.Ports.Release(t.ID)
 	t.FinishTime = time.Now().UTC()
 	t.State = task.Completed
 	w.Db.Put(t.ID.String(), &t)
@@ -163,10 +264,8 @@ func (w *Worker) RunTasks() {
 	}
 }
 
-func (w *Worker) InspecTask(t task.Task) task.DockerInspectResponse {
-	config := task.NewConfig(&t)
-	d := task.NewDocker(config)
-	return d.Inspect(t.ContainerID)
+func (w *Worker) InspecTask(t task.Task) task.InspectResult {
+	return w.Runtime.Inspect(t.ContainerID)
 }
 
 func (w *Worker) UpdateTasks() {
@@ -186,31 +285,51 @@ func (w *Worker) updateTasks() {
 		return
 	}
 
+	now := time.Now().UTC()
 	for id, t := range tasks {
 
-		if t.State == task.Running {
-			resp := w.InspecTask(*t)
-			if resp.Error != nil {
-				w.Logln("Error: %v", resp.Error)
-				continue
-			}
+		if t.State != task.Running {
+			w.mu.Lock()
+			delete(w.probes, t.ID)
+			w.mu.Unlock()
+			continue
+		}
 
-			if resp.Container == nil {
-				w.Logln("No container for running task %s", id)
-				t.State = task.Failed
-				w.Db.Put(t.ID.String(), t)
-			}
+		resp := w.InspecTask(*t)
+		if resp.Error != nil {
+			w.Logln("Error: %v", resp.Error)
+			continue
+		}
 
-			if resp.Container.State.Status == "exited" {
-				w.Logln("Container for task %s in non-running state %s", id, resp.Container.State.Status)
-				t.State = task.Failed
-				w.Db.Put(t.ID.String(), t)
+		if resp.Status == "" {
+			w.Logln("No container for running task %s", id)
+			w.Ports.Release(t.ID)
+			t.State = task.Failed
+			t.Ready = false
+			w.Db.Put(t.ID.String(), t)
+			continue
+		}
+
+		if resp.Status == task.StatusExited {
+			w.Logln("Container for task %s in non-running state %s", id, resp.Status)
+			w.Ports.Release(t.ID)
+
+			t.ExitCode = resp.ExitCode
+			t.FinishTime = resp.FinishedAt
+			if t.FinishTime.IsZero() {
+				t.FinishTime = time.Now().UTC()
 			}
 
-			t.HostPorts = resp.Container.NetworkSettings.NetworkSettingsBase.Ports
+			t.State = t.ExitState(resp.ExitCode)
+			t.Ready = false
 			w.Db.Put(t.ID.String(), t)
 		}
 
+		t.HostPorts = resp.Ports
+		if t.State == task.Running {
+			w.checkProbes(t, now)
+		}
+		w.Db.Put(t.ID.String(), t)
 	}
 }
 
@@ -0,0 +1,395 @@
+package worker
+
+import (
+	"cube/task"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+func newFakeWorker(t *testing.T) (*Worker, *task.Fake) {
+	t.Helper()
+
+	w, err := NewWithRuntime("test-worker", "memory", task.NewFake())
+	if err != nil {
+		t.Fatalf("error creating worker: %v", err)
+	}
+
+	return w, w.Runtime.(*task.Fake)
+}
+
+func newScheduledTask(name string) task.Task {
+	return task.Task{
+		ID:           uuid.New(),
+		Name:         name,
+		State:        task.Scheduled,
+		Image:        "example/echo:latest",
+		ExposedPorts: nat.PortSet{"7777/tcp": struct{}{}},
+	}
+}
+
+func getTask(t *testing.T, w *Worker, id uuid.UUID) *task.Task {
+	t.Helper()
+
+	persisted, err := w.Db.Get(id.String())
+	if err != nil {
+		t.Fatalf("error getting task %s: %v", id, err)
+	}
+
+	return persisted
+}
+
+func TestRunTaskStartsScheduledTask(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("start")
+	w.AddTask(tk)
+
+	result := w.runTask()
+	if result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+
+	persisted := getTask(t, w, tk.ID)
+	if persisted.State != task.Running {
+		t.Errorf("expected state %v, got %v", task.Running, persisted.State)
+	}
+	if persisted.ContainerID != result.ContainerId {
+		t.Errorf("expected container id %s, got %s", result.ContainerId, persisted.ContainerID)
+	}
+	if persisted.StartTime.IsZero() {
+		t.Errorf("expected start time to be set")
+	}
+	if _, ok := f.Container(persisted.ContainerID); !ok {
+		t.Errorf("expected container %s to exist", persisted.ContainerID)
+	}
+}
+
+func TestRunTaskMarksFailedWhenRuntimeFails(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("broken")
+	f.Script(tk.Name, task.FakeBehavior{RunError: errors.New("image not found")})
+	w.AddTask(tk)
+
+	result := w.runTask()
+	if result.Error == nil {
+		t.Fatalf("expected an error")
+	}
+
+	if persisted := getTask(t, w, tk.ID); persisted.State != task.Failed {
+		t.Errorf("expected state %v, got %v", task.Failed, persisted.State)
+	}
+}
+
+func TestRunTaskRejectsDisallowedHostPath(t *testing.T) {
+	w, f := newFakeWorker(t)
+	w.AllowedHostPaths = []string{"/srv/data"}
+
+	allowed := newScheduledTask("allowed")
+	allowed.Volumes = []task.Volume{{Type: task.VolumeTypeBind, Source: "/srv/data/app", Target: "/data"}}
+	w.AddTask(allowed)
+	if result := w.runTask(); result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+
+	denied := newScheduledTask("denied")
+	denied.Volumes = []task.Volume{{Type: task.VolumeTypeBind, Source: "/srv/database", Target: "/data"}}
+	w.AddTask(denied)
+	if result := w.runTask(); result.Error == nil {
+		t.Fatalf("expected an error for a disallowed host path")
+	}
+
+	if persisted := getTask(t, w, denied.ID); persisted.State != task.Failed {
+		t.Errorf("expected state %v, got %v", task.Failed, persisted.State)
+	}
+	if f.Count() != 1 {
+		t.Errorf("expected only the allowed task to run, got %d containers", f.Count())
+	}
+}
+
+func TestHostPathAllowedResolvesSymlinks(t *testing.T) {
+	w, _ := newFakeWorker(t)
+	allowed := t.TempDir()
+	w.AllowedHostPaths = []string{allowed}
+
+	os.Mkdir(filepath.Join(allowed, "app"), 0755)
+	if err := os.Symlink("/etc", filepath.Join(allowed, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink(filepath.Join(allowed, "app"), filepath.Join(allowed, "current"))
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{filepath.Join(allowed, "app"), true},
+		{filepath.Join(allowed, "current", "data"), true},
+		{filepath.Join(allowed, "new", "data"), true},
+		{filepath.Join(allowed, "etc"), false},
+		{filepath.Join(allowed, "etc", "passwd"), false},
+		{filepath.Join(allowed, "..", "other"), false},
+	}
+
+	for _, tt := range tests {
+		if got := w.hostPathAllowed(tt.path); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestRunTaskWaitsForSlowStart(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("slow")
+	f.Script(tk.Name, task.FakeBehavior{StartDelay: 50 * time.Millisecond})
+	w.AddTask(tk)
+
+	start := time.Now()
+	if result := w.runTask(); result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected run to take at least 50ms, took %v", elapsed)
+	}
+	if persisted := getTask(t, w, tk.ID); persisted.State != task.Running {
+		t.Errorf("expected state %v, got %v", task.Running, persisted.State)
+	}
+}
+
+func TestRunTaskStopsCompletedTask(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("stop")
+	w.AddTask(tk)
+	w.runTask()
+
+	running := getTask(t, w, tk.ID)
+	stop := *running
+	stop.State = task.Completed
+	w.AddTask(stop)
+
+	if result := w.runTask(); result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+
+	persisted := getTask(t, w, tk.ID)
+	if persisted.State != task.Completed {
+		t.Errorf("expected state %v, got %v", task.Completed, persisted.State)
+	}
+	if persisted.FinishTime.IsZero() {
+		t.Errorf("expected finish time to be set")
+	}
+	if f.Count() != 0 {
+		t.Errorf("expected container to be removed, %d left", f.Count())
+	}
+}
+
+func TestRunTaskRejectsInvalidTransition(t *testing.T) {
+	w, _ := newFakeWorker(t)
+
+	tk := newScheduledTask("invalid")
+	tk.State = task.Pending
+	w.AddTask(tk)
+
+	if result := w.runTask(); result.Error == nil {
+		t.Fatalf("expected an error for invalid transition")
+	}
+}
+
+func TestUpdateTasksRecordsHostPorts(t *testing.T) {
+	w, _ := newFakeWorker(t)
+
+	tk := newScheduledTask("ports")
+	w.AddTask(tk)
+	w.runTask()
+	w.updateTasks()
+
+	persisted := getTask(t, w, tk.ID)
+	bindings := persisted.HostPorts["7777/tcp"]
+	if len(bindings) != 1 || bindings[0].HostPort != "32768" {
+		t.Errorf("expected host port 32768, got %v", persisted.HostPorts)
+	}
+}
+
+func TestUpdateTasksMarksCrashedTaskFailed(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("crash")
+	w.AddTask(tk)
+	w.runTask()
+
+	persisted := getTask(t, w, tk.ID)
+	if err := f.Crash(persisted.ContainerID, 137); err != nil {
+		t.Fatal(err)
+	}
+
+	w.updateTasks()
+
+	if persisted := getTask(t, w, tk.ID); persisted.State != task.Failed {
+		t.Errorf("expected state %v, got %v", task.Failed, persisted.State)
+	}
+}
+
+func TestUpdateTasksMarksExitedTaskFailed(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("exit")
+	f.Script(tk.Name, task.FakeBehavior{ExitAfter: time.Millisecond, ExitCode: 1})
+	w.AddTask(tk)
+	w.runTask()
+
+	time.Sleep(5 * time.Millisecond)
+	w.updateTasks()
+
+	if persisted := getTask(t, w, tk.ID); persisted.State != task.Failed {
+		t.Errorf("expected state %v, got %v", task.Failed, persisted.State)
+	}
+}
+
+func TestUpdateTasksCompletesSuccessfulJob(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("job")
+	tk.Mode = task.ModeJob
+	f.Script(tk.Name, task.FakeBehavior{ExitAfter: time.Millisecond})
+	w.AddTask(tk)
+	w.runTask()
+
+	time.Sleep(5 * time.Millisecond)
+	w.updateTasks()
+
+	persisted := getTask(t, w, tk.ID)
+	if persisted.State != task.Completed {
+		t.Errorf("expected state %v, got %v", task.Completed, persisted.State)
+	}
+	if persisted.ExitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", persisted.ExitCode)
+	}
+	if persisted.FinishTime.IsZero() {
+		t.Errorf("expected finish time to be set")
+	}
+}
+
+func TestUpdateTasksFailsJobWithNonZeroExit(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("job-fail")
+	tk.Mode = task.ModeJob
+	f.Script(tk.Name, task.FakeBehavior{ExitAfter: time.Millisecond, ExitCode: 2})
+	w.AddTask(tk)
+	w.runTask()
+
+	time.Sleep(5 * time.Millisecond)
+	w.updateTasks()
+
+	persisted := getTask(t, w, tk.ID)
+	if persisted.State != task.Failed {
+		t.Errorf("expected state %v, got %v", task.Failed, persisted.State)
+	}
+	if persisted.ExitCode != 2 {
+		t.Errorf("expected exit code 2, got %d", persisted.ExitCode)
+	}
+}
+
+func TestRunTaskRemovesPreviousContainerOnRestart(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("restart")
+	w.AddTask(tk)
+	w.runTask()
+
+	restarted := *getTask(t, w, tk.ID)
+	f.Crash(restarted.ContainerID, 1)
+	w.updateTasks()
+
+	restarted = *getTask(t, w, tk.ID)
+	restarted.State = task.Scheduled
+	w.AddTask(restarted)
+	if result := w.runTask(); result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+
+	if f.Count() != 1 {
+		t.Errorf("expected the previous container to be removed, got %d containers", f.Count())
+	}
+}
+
+func TestRestartKeepsVolumes(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("volumes")
+	tk.VolumePolicy = task.VolumePolicyDelete
+	tk.Volumes = []task.Volume{{Type: task.VolumeTypeVolume, Source: "data", Target: "/data"}}
+	w.AddTask(tk)
+	w.runTask()
+
+	restart := *getTask(t, w, tk.ID)
+	restart.State = task.Scheduled
+	w.AddTask(restart)
+	if result := w.runTask(); result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+
+	// 再起動ではボリュームを削除しない
+	if cleanups := f.VolumeCleanups(); len(cleanups) != 0 {
+		t.Fatalf("expected no volume cleanup on restart, got %v", cleanups)
+	}
+
+	stop := *getTask(t, w, tk.ID)
+	stop.State = task.Completed
+	w.AddTask(stop)
+	w.runTask()
+
+	if cleanups := f.VolumeCleanups(); len(cleanups) != 1 || cleanups[0] != stop.ContainerID {
+		t.Errorf("expected the volumes of %s to be cleaned up on stop, got %v", stop.ContainerID, cleanups)
+	}
+}
+
+func TestUpdateTasksCompletesOnFailureServiceWithZeroExit(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("on-failure")
+	tk.RestartPolicy = task.RestartOnFailure
+	f.Script(tk.Name, task.FakeBehavior{ExitAfter: time.Millisecond})
+	w.AddTask(tk)
+	w.runTask()
+
+	time.Sleep(5 * time.Millisecond)
+	w.updateTasks()
+
+	if persisted := getTask(t, w, tk.ID); persisted.State != task.Completed {
+		t.Errorf("expected state %v, got %v", task.Completed, persisted.State)
+	}
+}
+
+func TestRunTaskRestartsRunningTask(t *testing.T) {
+	w, f := newFakeWorker(t)
+
+	tk := newScheduledTask("unhealthy")
+	w.AddTask(tk)
+	w.runTask()
+
+	restart := *getTask(t, w, tk.ID)
+	previous := restart.ContainerID
+	restart.State = task.Scheduled
+	w.AddTask(restart)
+
+	if result := w.runTask(); result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+
+	persisted := getTask(t, w, tk.ID)
+	if persisted.State != task.Running || persisted.ContainerID == previous {
+		t.Errorf("expected a new running container, got %v %s", persisted.State, persisted.ContainerID)
+	}
+	if f.Count() != 1 {
+		t.Errorf("expected the previous container to be removed, got %d containers", f.Count())
+	}
+}